@@ -0,0 +1,378 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+const (
+	ReportText = "text"
+	ReportJSON = "json"
+)
+
+type KeyStats struct {
+	DatabaseId   uint64 `json:"db"`
+	Key          string `json:"key"`
+	Type         string `json:"type"`
+	TTL          int64  `json:"ttl"`
+	MemoryUsage  int64  `json:"memory_usage"`
+	ElementCount int64  `json:"element_count"`
+	Frequency    int64  `json:"frequency"`
+}
+
+type DatabaseStats struct {
+	DatabaseId  uint64            `json:"db"`
+	Keys        uint64            `json:"keys"`
+	Expiring    uint64            `json:"expiring"`
+	MemoryUsage int64             `json:"memory_usage"`
+	Types       map[string]uint64 `json:"types"`
+}
+
+// PrefixStats aggregates the keys sharing a prefix, TTLCoverage being the
+// share of them which expire.
+type PrefixStats struct {
+	Prefix      string  `json:"prefix"`
+	Keys        uint64  `json:"keys"`
+	MemoryUsage int64   `json:"memory_usage"`
+	Expiring    uint64  `json:"expiring"`
+	TTLCoverage float64 `json:"ttl_coverage"`
+}
+
+type TTLBucket struct {
+	Range string `json:"range"`
+	Keys  uint64 `json:"keys"`
+}
+
+type AnalyzeReport struct {
+	Databases     []*DatabaseStats       `json:"databases"`
+	Total         DatabaseStats          `json:"total"`
+	TopByMemory   map[string][]*KeyStats `json:"top_by_memory"`
+	TopByElements map[string][]*KeyStats `json:"top_by_elements"`
+	HotKeys       []*KeyStats            `json:"hot_keys"`
+	Prefixes      []*PrefixStats         `json:"prefixes"`
+	TTL           []TTLBucket            `json:"ttl"`
+}
+
+// ttlBuckets are the upper bounds (exclusive, in seconds) of the TTL
+// distribution, keys without expiration being counted apart.
+var ttlBuckets = []struct {
+	Range string
+	Max   int64
+}{
+	{"< 1m", 60},
+	{"< 1h", 3600},
+	{"< 1d", 86400},
+	{"< 7d", 7 * 86400},
+	{"< 30d", 30 * 86400},
+	{">= 30d", math.MaxInt64},
+}
+
+const noExpiration = "no expiration"
+
+const DefaultPrefixDelimiter = ":"
+
+// Analyzer is a KeyVisitor gathering the biggest keys per type, the hot keys
+// by LFU access frequency when known, the TTL distribution, the key prefixes
+// and the totals of every database. Keys are grouped by their first Depth
+// segments split on Delimiter.
+type Analyzer struct {
+	TopN        int
+	Delimiter   string
+	Depth       int
+	lock        sync.Mutex
+	databases   map[uint64]*DatabaseStats
+	topMemory   map[string]*lib.TopN
+	topElements map[string]*lib.TopN
+	hotKeys     *lib.TopN
+	prefixes    map[string]*PrefixStats
+	ttl         map[string]uint64
+}
+
+func NewAnalyzer(topN int, delimiter string, depth int) *Analyzer {
+
+	return &Analyzer{
+		TopN:        topN,
+		Delimiter:   delimiter,
+		Depth:       depth,
+		databases:   make(map[uint64]*DatabaseStats),
+		topMemory:   make(map[string]*lib.TopN),
+		topElements: make(map[string]*lib.TopN),
+		hotKeys:     lib.NewTopN(topN),
+		prefixes:    make(map[string]*PrefixStats),
+		ttl:         make(map[string]uint64),
+	}
+}
+
+func (a *Analyzer) Visit(info *KeyInfo) error {
+
+	stats := &KeyStats{
+		DatabaseId:   info.DatabaseId,
+		Key:          info.Key,
+		Type:         info.Type,
+		TTL:          info.TTL,
+		MemoryUsage:  info.MemoryUsage,
+		ElementCount: info.ElementCount,
+		Frequency:    info.Frequency,
+	}
+
+	memory := info.MemoryUsage
+	if memory < 0 {
+		memory = 0
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	db, isExist := a.databases[info.DatabaseId]
+	if !isExist {
+
+		db = &DatabaseStats{DatabaseId: info.DatabaseId, Types: make(map[string]uint64)}
+		a.databases[info.DatabaseId] = db
+	}
+
+	db.Keys++
+	db.MemoryUsage += memory
+	db.Types[info.Type]++
+	if info.TTL > 0 {
+		db.Expiring++
+	}
+
+	a.top(a.topMemory, info.Type).Push(memory, stats)
+	if info.ElementCount >= 0 {
+		a.top(a.topElements, info.Type).Push(info.ElementCount, stats)
+	}
+	if info.Frequency >= 0 {
+		a.hotKeys.Push(info.Frequency, stats)
+	}
+
+	prefix := lib.KeyPrefix(info.Key, a.Delimiter, a.Depth)
+	prefixStats, isExist := a.prefixes[prefix]
+	if !isExist {
+
+		prefixStats = &PrefixStats{Prefix: prefix}
+		a.prefixes[prefix] = prefixStats
+	}
+	prefixStats.Keys++
+	prefixStats.MemoryUsage += memory
+	if info.TTL > 0 {
+		prefixStats.Expiring++
+	}
+
+	a.ttl[ttlRange(info.TTL)]++
+	return nil
+}
+
+func (a *Analyzer) top(tops map[string]*lib.TopN, keyType string) *lib.TopN {
+
+	top, isExist := tops[keyType]
+	if !isExist {
+
+		top = lib.NewTopN(a.TopN)
+		tops[keyType] = top
+	}
+
+	return top
+}
+
+func (a *Analyzer) Report() *AnalyzeReport {
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	report := &AnalyzeReport{
+		Total:         DatabaseStats{Types: make(map[string]uint64)},
+		TopByMemory:   topStats(a.topMemory),
+		TopByElements: topStats(a.topElements),
+	}
+
+	for _, value := range a.hotKeys.Values() {
+		report.HotKeys = append(report.HotKeys, value.(*KeyStats))
+	}
+
+	for _, db := range a.databases {
+
+		report.Databases = append(report.Databases, db)
+		report.Total.Keys += db.Keys
+		report.Total.Expiring += db.Expiring
+		report.Total.MemoryUsage += db.MemoryUsage
+		for keyType, count := range db.Types {
+			report.Total.Types[keyType] += count
+		}
+	}
+	sort.Slice(report.Databases, func(i, j int) bool {
+		return report.Databases[i].DatabaseId < report.Databases[j].DatabaseId
+	})
+
+	for _, prefix := range a.prefixes {
+
+		prefix.TTLCoverage = float64(prefix.Expiring) / float64(prefix.Keys)
+		report.Prefixes = append(report.Prefixes, prefix)
+	}
+	sort.Slice(report.Prefixes, func(i, j int) bool {
+		if report.Prefixes[i].MemoryUsage != report.Prefixes[j].MemoryUsage {
+			return report.Prefixes[i].MemoryUsage > report.Prefixes[j].MemoryUsage
+		}
+		return report.Prefixes[i].Keys > report.Prefixes[j].Keys
+	})
+
+	report.TTL = append(report.TTL, TTLBucket{Range: noExpiration, Keys: a.ttl[noExpiration]})
+	for _, bucket := range ttlBuckets {
+		report.TTL = append(report.TTL, TTLBucket{Range: bucket.Range, Keys: a.ttl[bucket.Range]})
+	}
+
+	return report
+}
+
+func topStats(tops map[string]*lib.TopN) map[string][]*KeyStats {
+
+	stats := make(map[string][]*KeyStats, len(tops))
+	for keyType, top := range tops {
+
+		for _, value := range top.Values() {
+			stats[keyType] = append(stats[keyType], value.(*KeyStats))
+		}
+	}
+
+	return stats
+}
+
+func ttlRange(ttl int64) string {
+
+	if ttl <= 0 {
+		return noExpiration
+	}
+
+	for _, bucket := range ttlBuckets {
+
+		if ttl < bucket.Max {
+			return bucket.Range
+		}
+	}
+
+	return ttlBuckets[len(ttlBuckets)-1].Range
+}
+
+// Write returns a function writing the report in format, text or json.
+func (report *AnalyzeReport) Write(format string) func(w io.Writer) error {
+
+	if format == ReportJSON {
+		return report.WriteJSON
+	}
+
+	return report.WriteText
+}
+
+func (report *AnalyzeReport) WriteJSON(w io.Writer) error {
+
+	return writeJSON(w, report)
+}
+
+func (report *AnalyzeReport) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "Databases")
+	fmt.Fprintln(tw, "  db\tkeys\texpiring\tmemory\ttypes")
+	for _, db := range report.Databases {
+		fmt.Fprintf(tw, "  %d\t%d\t%d\t%s\t%s\n", db.DatabaseId, db.Keys, db.Expiring, formatBytes(db.MemoryUsage), formatTypes(db.Types))
+	}
+	fmt.Fprintf(tw, "  total\t%d\t%d\t%s\t%s\n", report.Total.Keys, report.Total.Expiring, formatBytes(report.Total.MemoryUsage), formatTypes(report.Total.Types))
+
+	writeTopText(tw, "Biggest keys by memory usage", report.TopByMemory)
+	writeTopText(tw, "Biggest keys by element count", report.TopByElements)
+
+	fmt.Fprintln(tw, "\nHot keys by access frequency")
+	if len(report.HotKeys) == 0 {
+		fmt.Fprintln(tw, "  unknown, OBJECT FREQ needs a redis whose maxmemory-policy is allkeys-lfu or volatile-lfu")
+	} else {
+		fmt.Fprintln(tw, "  type\tdb\tkey\tfrequency\tmemory\tttl")
+	}
+	for _, stats := range report.HotKeys {
+		fmt.Fprintf(tw, "  %s\t%d\t%q\t%d\t%s\t%d\n", stats.Type, stats.DatabaseId, stats.Key, stats.Frequency, formatBytes(stats.MemoryUsage), stats.TTL)
+	}
+
+	fmt.Fprintln(tw, "\nTTL distribution")
+	for _, bucket := range report.TTL {
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", bucket.Range, bucket.Keys, percent(bucket.Keys, report.Total.Keys))
+	}
+
+	fmt.Fprintln(tw, "\nKey prefixes")
+	fmt.Fprintln(tw, "  prefix\tkeys\tmemory\texpiring\tttl coverage")
+	for _, prefix := range report.Prefixes {
+
+		name := prefix.Prefix
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%s\t%d\t%s\n", name, prefix.Keys, formatBytes(prefix.MemoryUsage), prefix.Expiring, percent(prefix.Expiring, prefix.Keys))
+	}
+
+	return tw.Flush()
+}
+
+func writeTopText(w io.Writer, title string, tops map[string][]*KeyStats) {
+
+	fmt.Fprintf(w, "\n%s\n", title)
+	fmt.Fprintln(w, "  type\tdb\tkey\tmemory\telements\tttl")
+
+	var types []string
+	for keyType := range tops {
+		types = append(types, keyType)
+	}
+	sort.Strings(types)
+
+	for _, keyType := range types {
+
+		for _, stats := range tops[keyType] {
+			fmt.Fprintf(w, "  %s\t%d\t%q\t%s\t%d\t%d\n", keyType, stats.DatabaseId, stats.Key, formatBytes(stats.MemoryUsage), stats.ElementCount, stats.TTL)
+		}
+	}
+}
+
+func formatTypes(types map[string]uint64) string {
+
+	var parts []string
+	for keyType, count := range types {
+		parts = append(parts, fmt.Sprintf("%s=%d", keyType, count))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, " ")
+}
+
+func formatBytes(size int64) string {
+
+	if size < 0 {
+		return "-"
+	}
+
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+func percent(part, total uint64) string {
+
+	if total == 0 {
+		return "0.0%"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
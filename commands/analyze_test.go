@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzer_Report(t *testing.T) {
+
+	analyzer := NewAnalyzer(2, DefaultPrefixDelimiter, 1)
+	for _, info := range []*KeyInfo{
+		{DatabaseId: 0, Key: "user:1", Type: TypeHash, TTL: 30, MemoryUsage: 100, ElementCount: 2, Frequency: 5},
+		{DatabaseId: 0, Key: "user:2", Type: TypeHash, TTL: -1, MemoryUsage: 300, ElementCount: 1, Frequency: 200},
+		{DatabaseId: 1, Key: "session", Type: TypeString, TTL: 7200, MemoryUsage: 50, ElementCount: 10, Frequency: 0},
+		{DatabaseId: 1, Key: "cold", Type: TypeString, TTL: -1, MemoryUsage: -1, ElementCount: 1, Frequency: -1},
+	} {
+		assert.NoError(t, analyzer.Visit(info))
+	}
+
+	report := analyzer.Report()
+	assert.Equal(t, uint64(4), report.Total.Keys)
+	assert.Equal(t, uint64(2), report.Total.Expiring)
+	assert.Equal(t, int64(450), report.Total.MemoryUsage)
+	assert.Equal(t, []string{"user:2", "user:1"}, statsKeys(report.TopByMemory[TypeHash]))
+	assert.Equal(t, []string{"session", "cold"}, statsKeys(report.TopByElements[TypeString]))
+	assert.Equal(t, []string{"user:2", "user:1"}, statsKeys(report.HotKeys))
+	assert.Equal(t, "user:", report.Prefixes[0].Prefix)
+	assert.Equal(t, 0.5, report.Prefixes[0].TTLCoverage)
+	assert.Equal(t, TTLBucket{Range: noExpiration, Keys: 2}, report.TTL[0])
+
+	var text bytes.Buffer
+	assert.NoError(t, report.WriteText(&text))
+	assert.Contains(t, text.String(), "Hot keys by access frequency")
+	assert.Contains(t, text.String(), `"user:2"  200`)
+
+	// without an LFU policy no frequency is known
+	analyzer = NewAnalyzer(2, DefaultPrefixDelimiter, 1)
+	assert.NoError(t, analyzer.Visit(&KeyInfo{Key: "k", Type: TypeString, TTL: -1, Frequency: -1}))
+	report = analyzer.Report()
+	assert.Empty(t, report.HotKeys)
+
+	text.Reset()
+	assert.NoError(t, report.WriteText(&text))
+	assert.Contains(t, text.String(), "maxmemory-policy is allkeys-lfu or volatile-lfu")
+}
+
+func statsKeys(stats []*KeyStats) []string {
+
+	var keys []string
+	for _, s := range stats {
+		keys = append(keys, s.Key)
+	}
+
+	return keys
+}
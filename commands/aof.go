@@ -0,0 +1,309 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// aofFile is a file of a redis 7 appendonlydir, as listed in its manifest.
+type aofFile struct {
+	Name     string
+	Sequence uint64
+	Type     string
+}
+
+// Types of the files of a manifest: the base (RDB or AOF), the incremental
+// AOFs and the history files left by a rewrite.
+const (
+	aofTypeBase        = "b"
+	aofTypeIncremental = "i"
+	aofTypeHistory     = "h"
+)
+
+// aofFiles returns the files to read in order for an AOF path: the base then
+// the incrementals of a redis 7 appendonlydir or of its manifest, or the
+// file itself.
+func aofFiles(path string) ([]string, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+
+		return nil, &ConfigError{Err: fmt.Errorf("Open data file error, %s", err)}
+	}
+
+	manifest := path
+	if !isAOFDir(path, info) {
+		return []string{path}, nil
+	}
+	if info.IsDir() {
+
+		manifests, _ := filepath.Glob(filepath.Join(path, "*.manifest"))
+		if len(manifests) != 1 {
+
+			return nil, &ConfigError{Err: fmt.Errorf("%s holds %d AOF manifest(s), 1 expected", path, len(manifests))}
+		}
+		manifest = manifests[0]
+	}
+
+	files, err := readManifest(manifest)
+	if err != nil {
+
+		return nil, &ConfigError{Err: fmt.Errorf("%s, %s", manifest, err)}
+	}
+
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, filepath.Join(filepath.Dir(manifest), file.Name))
+	}
+
+	return paths, nil
+}
+
+// readManifest reads the lines of an AOF manifest, "file <name> seq <n> type
+// <b|i|h>", and returns the base then the incrementals by sequence.
+func readManifest(path string) ([]*aofFile, error) {
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var base *aofFile
+	var incrementals []*aofFile
+	scanner := bufio.NewScanner(fp)
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		words, err := manifestWords(text)
+		if err != nil || len(words)%2 != 0 {
+			return nil, fmt.Errorf("invalid manifest line %d %q", line, text)
+		}
+
+		file := &aofFile{}
+		for i := 0; i < len(words); i += 2 {
+
+			switch words[i] {
+			case "file":
+				file.Name = words[i+1]
+			case "seq":
+				if file.Sequence, err = strconv.ParseUint(words[i+1], 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid manifest line %d %q", line, text)
+				}
+			case "type":
+				file.Type = words[i+1]
+			}
+		}
+
+		switch {
+		case file.Name == "" || strings.ContainsAny(file.Name, `/\`):
+			return nil, fmt.Errorf("invalid manifest line %d %q", line, text)
+		case file.Type == aofTypeBase:
+			base = file
+		case file.Type == aofTypeIncremental:
+			incrementals = append(incrementals, file)
+		case file.Type != aofTypeHistory:
+			return nil, fmt.Errorf("unknown AOF file type %q, line %d", file.Type, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(incrementals, func(i, j int) bool { return incrementals[i].Sequence < incrementals[j].Sequence })
+	if base != nil {
+		incrementals = append([]*aofFile{base}, incrementals...)
+	}
+	if len(incrementals) == 0 {
+		return nil, fmt.Errorf("no AOF file listed")
+	}
+
+	return incrementals, nil
+}
+
+// manifestWords splits a manifest line on spaces, a file name with spaces
+// being quoted.
+func manifestWords(line string) ([]string, error) {
+
+	var words []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+
+		if line[0] != '"' {
+
+			end := strings.IndexByte(line, ' ')
+			if end < 0 {
+				end = len(line)
+			}
+			words = append(words, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		end := 1
+		for ; end < len(line) && line[end] != '"'; end++ {
+			if line[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(line) {
+			return nil, fmt.Errorf("unterminated quote")
+		}
+		quoted := line[:end+1]
+		word, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+		line = line[len(quoted):]
+	}
+
+	return words, nil
+}
+
+// replayAOF calls command with every command of a RESP file or AOF, or of
+// the files of a redis 7 appendonlydir in manifest order, each file starting
+// on database 0. The keys of an RDB base or preamble are given as SELECT and
+// RESTORE ... REPLACE commands.
+func replayAOF(ctx context.Context, path string, command func(args []string) error) error {
+
+	paths, err := aofFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+
+		if err = command([]string{"SELECT", "0"}); err != nil {
+			return err
+		}
+
+		if err = readAOFFile(ctx, path, command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readAOFFile(ctx context.Context, path string, command func(args []string) error) error {
+
+	fp, err := os.Open(path)
+	if err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("Open data file error, %s", err)}
+	}
+	defer fp.Close()
+
+	reader := bufio.NewReaderSize(fp, 1<<20)
+	if header, _ := reader.Peek(5); string(header) == "REDIS" {
+
+		dbId := int64(-1)
+		err = readRDB(ctx, path, reader, func(record *Record) error {
+
+			if int64(record.DatabaseId) != dbId {
+
+				if err := command([]string{"SELECT", strconv.FormatUint(record.DatabaseId, 10)}); err != nil {
+					return err
+				}
+				dbId = int64(record.DatabaseId)
+			}
+
+			ttl := "0"
+			if record.TTL > 0 {
+				ttl = strconv.FormatInt(record.TTL*1000, 10)
+			}
+			return command([]string{"RESTORE", record.Key, ttl, record.Value, "REPLACE"})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	respReader := NewRESPReader(reader)
+	for count := 1; ; count++ {
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		args, err := respReader.ReadCommand()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+
+			return &ConfigError{Err: fmt.Errorf("Read RESP file %s error, %s", path, err)}
+		}
+
+		if err = command(args); err != nil {
+			return err
+		}
+	}
+}
+
+// isAOF tells whether path is read as commands: a RESP file, an appendonlydir
+// or its manifest, or a file opening with the RDB magic, which may be the RDB
+// preamble of an AOF with or without commands after it.
+func isAOF(path string) (bool, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	if isAOFDir(path, info) {
+		return true, nil
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fp.Close()
+
+	if isRESP, err := isRESPFile(fp); err != nil || isRESP {
+		return isRESP, err
+	}
+
+	return isRDBFile(fp)
+}
+
+// isAOFDir tells whether path is a redis 7 appendonlydir or its manifest.
+func isAOFDir(path string, info os.FileInfo) bool {
+
+	return info.IsDir() || strings.HasSuffix(path, ".manifest")
+}
+
+// hasRDBPreamble tells whether the file is an AOF starting with RDB data and
+// followed by commands: it opens with an RDB header but ends with a command
+// line rather than the RDB end of file and checksum. An AOF holding only its
+// preamble cannot be told from an RDB file.
+func hasRDBPreamble(file *os.File) (bool, error) {
+
+	if isRDB, err := isRDBFile(file); err != nil || !isRDB {
+		return false, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.Size() < 9+9 {
+		return false, err
+	}
+
+	end := make([]byte, 9)
+	if _, err = file.ReadAt(end, info.Size()-9); err != nil {
+		return false, err
+	}
+
+	return end[0] != rdbOpEOF && string(end[7:]) == "\r\n", nil
+}
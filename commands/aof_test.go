@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeAOFFile writes an AOF of the RDB data of records, if any, followed by
+// commands.
+func writeAOFFile(t *testing.T, path string, records []*Record, commands ...[]string) {
+
+	var data []byte
+	if records != nil {
+
+		writer, err := NewRDBWriter(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range records {
+			assert.NoError(t, writer.Write(record))
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if data, err = ioutil.ReadFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buffer bytes.Buffer
+	for _, command := range commands {
+		writeRESP(&buffer, command...)
+	}
+	if err := ioutil.WriteFile(path, append(data, buffer.Bytes()...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// aofValues reads an AOF as RESP and returns its values by "db:key".
+func aofValues(t *testing.T, path string) map[string]interface{} {
+
+	now := time.Now()
+	keyspace := NewKeyspace()
+	err := readRESPFile(context.Background(), path, func(record *Record) error {
+		return keyspace.Load(record, now)
+	})
+	assert.NoError(t, err, path)
+
+	values := make(map[string]interface{})
+	for _, dbId := range keyspace.Databases() {
+
+		for _, key := range keyspace.Keys(dbId, now) {
+			values[fmt.Sprintf("%d:%s", dbId, key)], _ = keyspace.Lookup(dbId, key, now).Value()
+		}
+	}
+
+	return values
+}
+
+func TestReadRESPFile_RDBPreamble(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	path := filepath.Join(dir, "appendonly.aof")
+	writeAOFFile(t, path,
+		[]*Record{
+			payloadRecord(0, "a", TypeString, "1", -1),
+			payloadRecord(0, "b", TypeString, "1", -1),
+			payloadRecord(2, "c", TypeSet, []string{"x"}, 100),
+		},
+		[]string{"SELECT", "0"},
+		[]string{"SET", "b", "2"},
+		[]string{"SELECT", "2"},
+		[]string{"SADD", "c", "y"},
+		[]string{"DEL", "a"},
+	)
+
+	format, err := detectFormat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatRESP, format)
+
+	err = ReadRDBFile(context.Background(), path, func(record *Record) error { return nil })
+	_, isConfigError := err.(*ConfigError)
+	assert.True(t, isConfigError)
+
+	assert.Equal(t, map[string]interface{}{"0:a": "1", "0:b": "2", "2:c": []string{"x", "y"}}, aofValues(t, path))
+}
+
+func TestReadRESPFile_Manifest(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	writeAOFFile(t, filepath.Join(dir, "appendonly.aof.1.base.rdb"), []*Record{payloadRecord(1, "a", TypeString, "base", -1)})
+	writeAOFFile(t, filepath.Join(dir, "appendonly.aof.2.incr.aof"), nil, []string{"SET", "a", "second"})
+	writeAOFFile(t, filepath.Join(dir, "appendonly aof.1.incr.aof"), nil, []string{"SELECT", "1"}, []string{"SET", "a", "first"}, []string{"SET", "b", "first"})
+	writeAOFFile(t, filepath.Join(dir, "appendonly.aof.0.base.aof"), nil, []string{"SET", "old", "1"})
+
+	manifest := "file appendonly.aof.0.base.aof seq 0 type h\n" +
+		"file appendonly.aof.1.base.rdb seq 1 type b\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type i\n" +
+		"file \"appendonly aof.1.incr.aof\" seq 1 type i\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{"1:a": "first", "1:b": "first", "0:a": "second"}
+	for _, path := range []string{dir, filepath.Join(dir, "appendonly.aof.manifest")} {
+
+		format, err := detectFormat(path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, FormatRESP, format, path)
+		assert.Equal(t, expected, aofValues(t, path), path)
+	}
+
+	for _, manifest := range []string{"", "file appendonly.aof.1.base.rdb seq 1 type x\n", "file ../dump.rdb seq 1 type i\n", "file \"a seq 1 type i\n"} {
+
+		path := filepath.Join(dir, "appendonly.aof.manifest")
+		if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := readRESPFile(context.Background(), path, func(record *Record) error { return nil })
+		_, isConfigError := err.(*ConfigError)
+		assert.True(t, isConfigError, "%q", manifest)
+	}
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "appendonly.aof.manifest")))
+	err := readRESPFile(context.Background(), dir, func(record *Record) error { return nil })
+	assert.Error(t, err)
+}
+
+// An AOF whose RDB preamble has no command after it is an RDB file, restore
+// replays it all the same.
+func TestRestorer_ReplayAOF(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	records := []*Record{
+		payloadRecord(0, "a", TypeString, "1", -1),
+		payloadRecord(1, "b", TypeList, []string{"x"}, -1),
+	}
+
+	cases := []struct {
+		name     string
+		commands [][]string
+		format   string
+		values   map[string]interface{}
+	}{
+		{"preamble only", nil, FormatRDB, map[string]interface{}{"0:a": "1", "1:b": []string{"x"}}},
+		{"preamble and commands", [][]string{{"SELECT", "1"}, {"RPUSH", "b", "y"}, {"SET", "c", "2"}}, FormatRESP,
+			map[string]interface{}{"0:a": "1", "1:b": []string{"x", "y"}, "1:c": "2"}},
+	}
+
+	for _, c := range cases {
+
+		path := filepath.Join(dir, "appendonly.aof")
+		writeAOFFile(t, path, records, c.commands...)
+
+		isRESP, err := isAOF(path)
+		assert.NoError(t, err, c.name)
+		assert.True(t, isRESP, c.name)
+		format, err := detectFormat(path)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.format, format, c.name)
+
+		destination := newFakeRedis(t)
+		_, err = (&RestoreLauncher{}).SetHost(destination.Addr).SetPath(path).SetThreadCount(1).Launch(context.Background())
+		assert.NoError(t, err, c.name)
+
+		values := make(map[string]interface{})
+		for _, dbId := range []uint64{0, 1} {
+
+			for _, key := range destination.Keys(dbId) {
+				values[fmt.Sprintf("%d:%s", dbId, key)] = destination.Value(t, dbId, key)
+			}
+		}
+		assert.Equal(t, c.values, values, c.name)
+		destination.Close()
+	}
+}
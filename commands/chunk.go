@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/atomic"
+)
+
+const defaultChunkSize = 1000
+
+// Chunker tells which keys are too big for a single DUMP and RESTORE: a DUMP
+// of a multi-GB key blocks the source and its payload may exceed the
+// destination proto-max-bulk-len. Such keys are copied ChunkSize elements at
+// a time with a KeyReader and rebuilt with native commands.
+type Chunker struct {
+	MaxBytes    int64
+	MaxElements int64
+	ChunkSize   int64
+	Chunked     atomic.Uint64
+}
+
+// NewChunker returns nil when both thresholds are 0, no key is chunked then.
+func NewChunker(maxBytes, maxElements, chunkSize int64) *Chunker {
+
+	if maxBytes <= 0 && maxElements <= 0 {
+		return nil
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	return &Chunker{MaxBytes: maxBytes, MaxElements: maxElements, ChunkSize: chunkSize}
+}
+
+// IsBig returns the type of key when it has to be chunked, "" otherwise.
+func (c *Chunker) IsBig(ctx context.Context, client *redis.Client, key string, policy *ErrorPolicy, limits *RateLimits) (keyType string, err error) {
+
+	if c == nil {
+		return
+	}
+
+	err = policy.Retry(ctx, "TYPE", func() (err error) {
+		if err = limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		keyType, err = client.Type(key).Result()
+		return
+	})
+	if err != nil || keyType == "none" {
+		return "", err
+	}
+
+	var size int64
+	if c.MaxElements > 0 && keyType != TypeString {
+
+		err = policy.Retry(ctx, "LEN", func() (err error) {
+			if err = limits.Read(ctx, 1, 0); err != nil {
+				return
+			}
+			size, err = elementCount(client, key, keyType)
+			return
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if size > c.MaxElements {
+			return keyType, nil
+		}
+	}
+
+	if c.MaxBytes > 0 {
+
+		err = policy.Retry(ctx, "MEMORY USAGE", func() (err error) {
+			if err = limits.Read(ctx, 1, 0); err != nil {
+				return
+			}
+
+			// MEMORY USAGE needs redis 4, without it only strings can be measured
+			size, err = client.MemoryUsage(key).Result()
+			if isErrorReply(err) && keyType == TypeString {
+				size, err = client.StrLen(key).Result()
+			}
+			return
+		})
+
+		// an unknown or disabled command leaves the key unchunked, a key
+		// deleted meanwhile has nothing to chunk
+		if isErrorReply(err) || err == redis.Nil {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if size > c.MaxBytes {
+			return keyType, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (c *Chunker) Reader(client *redis.Client, key, keyType string, policy *ErrorPolicy, limits *RateLimits) *KeyReader {
+
+	c.Chunked.Inc()
+	return &KeyReader{
+		Client: client,
+		Key:    key,
+		Type:   keyType,
+		Count:  c.ChunkSize,
+		Policy: policy,
+		Limits: limits,
+	}
+}
+
+func elementCount(client *redis.Client, key, keyType string) (int64, error) {
+
+	switch keyType {
+	case TypeHash:
+		return client.HLen(key).Result()
+	case TypeList:
+		return client.LLen(key).Result()
+	case TypeSet:
+		return client.SCard(key).Result()
+	case TypeZSet:
+		return client.ZCard(key).Result()
+	case TypeStream:
+		return client.XLen(key).Result()
+	}
+
+	return client.StrLen(key).Result()
+}
+
+// RestorePart rebuilds a key from its logical value, part by part for a big
+// key (part 1 being the first one, more false on the last one) or at once
+// with part 0. The first part decides about an existing key like Restore
+// does, the last one sets the TTL.
+func (d *Destination) RestorePart(ctx context.Context, key, keyType string, part uint64, more bool, ttl time.Duration, value string) (restored bool, err error) {
+
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	if part <= 1 {
+
+		if d.Plan != nil {
+			return d.plan(ctx, key, ttl)
+		}
+
+		restored, err = d.prepare(ctx, key, ttl)
+		if !restored || err != nil {
+			return
+		}
+	} else if d.Plan != nil {
+
+		return true, nil
+	}
+
+	if err = d.writeLogical(ctx, key, keyType, value); err != nil {
+		return false, err
+	}
+
+	if more || ttl <= 0 {
+		return true, nil
+	}
+
+	err = d.Policy.Retry(ctx, "PEXPIRE", func() (err error) {
+		if err = d.Limits.Write(ctx, 1, 0); err != nil {
+			return
+		}
+		_, err = d.Client.PExpire(key, ttl).Result()
+		return
+	})
+	return err == nil, err
+}
+
+// prepare applies the conflict policy before a key is rebuilt, deleting the
+// existing key when it is to be replaced.
+func (d *Destination) prepare(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+
+	if d.Conflict == nil {
+		return true, d.Delete(ctx, key)
+	}
+
+	destinationTTL, err := d.ttl(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if destinationTTL == keyNotExistTTL {
+		return true, nil
+	}
+
+	switch d.Conflict.OnConflict {
+	case OnConflictFail:
+		d.Conflict.Failed.Inc()
+		return false, &KeyExistsError{Key: key}
+
+	case OnConflictKeepNewerTTL:
+		if isNewerTTL(ttl, destinationTTL) {
+			d.Conflict.Replaced.Inc()
+			return true, d.Delete(ctx, key)
+		}
+	}
+
+	d.Conflict.Skipped.Inc()
+	return false, nil
+}
+
+// isErrorReply tells whether err is a generic ERR reply of the server, such
+// as for an unknown command, rather than a connection or transient error.
+func isErrorReply(err error) bool {
+
+	return err != nil && strings.HasPrefix(err.Error(), "ERR ")
+}
@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunker_IsBig(t *testing.T) {
+
+	fake := newFakeRedis(t,
+		logicalRecord(0, "list", []string{"a", "b", "c"}, -1),
+		logicalRecord(0, "hash", map[string]string{"a": "1"}, -1),
+		logicalRecord(0, "string", strings.Repeat("x", 100), -1),
+	)
+	defer fake.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: fake.Addr})
+	defer client.Close()
+
+	tests := []struct {
+		name    string
+		chunker *Chunker
+		key     string
+		want    string
+	}{
+		{"no thresholds", NewChunker(0, 0, 0), "list", ""},
+		{"more elements", NewChunker(0, 2, 0), "list", TypeList},
+		{"few elements", NewChunker(0, 2, 0), "hash", ""},
+		{"strings have no element count", NewChunker(0, 2, 0), "string", ""},
+		{"more bytes", NewChunker(50, 0, 0), "string", TypeString},
+		{"few bytes", NewChunker(1000, 0, 0), "string", ""},
+		{"missing key", NewChunker(1, 1, 0), "missing", ""},
+	}
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			keyType, err := test.chunker.IsBig(context.Background(), client, test.key, nil, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, keyType)
+		})
+	}
+
+	assert.Equal(t, int64(defaultChunkSize), NewChunker(1, 0, 0).ChunkSize)
+}
+
+func TestKeyReader_Next(t *testing.T) {
+
+	fake := newFakeRedis(t,
+		logicalRecord(0, "list", []string{"a", "b", "c", "d", "e"}, -1),
+		logicalRecord(0, "hash", map[string]string{"a": "1", "b": "2"}, -1),
+		logicalRecord(0, "string", "text", -1),
+		logicalRecord(0, "stream", []StreamEntry{{ID: "1-0", Values: map[string]string{"a": "1"}}, {ID: "2-0", Values: map[string]string{"b": "2"}}}, -1),
+	)
+	defer fake.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: fake.Addr})
+	defer client.Close()
+
+	tests := []struct {
+		key    string
+		count  int64
+		chunks []string
+	}{
+		{"list", 2, []string{`["a","b"]`, `["c","d"]`, `["e"]`}},
+		{"hash", 1, []string{`{"a":"1","b":"2"}`}},
+		{"string", 2, []string{`"text"`}},
+		{"stream", 1, []string{`[{"id":"1-0","values":{"a":"1"}}]`, `[{"id":"2-0","values":{"b":"2"}}]`, `[]`}},
+	}
+	for _, test := range tests {
+
+		t.Run(test.key, func(t *testing.T) {
+
+			keyType, err := client.Type(test.key).Result()
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			reader := NewChunker(0, 1, test.count).Reader(client, test.key, keyType, nil, nil)
+			var chunks []string
+			for !reader.Done() {
+
+				chunk, err := reader.Next(context.Background())
+				if !assert.NoError(t, err) {
+					return
+				}
+				chunks = append(chunks, chunk)
+			}
+			assert.Equal(t, test.chunks, chunks)
+		})
+	}
+}
+
+// A big key read with errors leaves its first parts in the dump, restore must
+// neither keep them as the key nor count the key as restored.
+func TestRestorer_TruncatedParts(t *testing.T) {
+
+	dir, clean := tempDir(t)
+	defer clean()
+
+	source := newFakeRedis(t,
+		logicalRecord(0, "big", []string{"a", "b", "c", "d", "e"}, -1),
+		logicalRecord(0, "small", "value", -1),
+	)
+	defer source.Close()
+	source.SetFail(func(args []string) string {
+
+		if strings.EqualFold(args[0], "LRANGE") && args[2] == "2" {
+			return "ERR injected"
+		}
+		return ""
+	})
+
+	path := filepath.Join(dir, "dump.json")
+	policy, err := NewErrorPolicy(OnErrorSkip, 0, 0, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := (&DumpLauncher{}).SetHost(source.Addr).SetPath(path).SetDatabaseCount(1).SetThreadCount(1).
+		SetErrorPolicy(policy).SetChunker(NewChunker(0, 2, 2)).Launch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), result.Failed)
+
+	records := readDumpFile(t, path)
+	for _, record := range records {
+
+		if record.Key == "big" {
+			assert.True(t, record.More)
+		}
+	}
+
+	destination := newFakeRedis(t)
+	defer destination.Close()
+
+	deadLetter := filepath.Join(dir, "dead.json")
+	policy, err = NewErrorPolicy(OnErrorSkip, 0, 0, deadLetter)
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err = (&RestoreLauncher{}).SetHost(destination.Addr).SetPath(path).SetThreadCount(1).
+		SetErrorPolicy(policy).Launch(context.Background())
+	policy.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Succeeded: 1, Failed: 1}, result)
+	assert.Equal(t, []string{"small"}, destination.Keys(0))
+
+	failed := readDumpFile(t, deadLetter)
+	if assert.Len(t, failed, 1) {
+
+		assert.Equal(t, "big", failed[0].Key)
+		assert.True(t, failed[0].IsValueless())
+		assert.Contains(t, failed[0].Error, "misses its last part")
+	}
+}
+
+// A dump retrying a big key writes it again from its first part, which must
+// not be skipped as an existing key.
+func TestRestorer_RestartedParts(t *testing.T) {
+
+	dir, clean := tempDir(t)
+	defer clean()
+
+	part := func(part uint64, more bool, items ...string) *Record {
+
+		record := logicalRecord(0, "big", items, -1)
+		record.Part, record.More = part, more
+		return record
+	}
+
+	path := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, path,
+		part(1, true, "a", "b"),
+		part(1, true, "a", "b"),
+		part(2, true, "c", "d"),
+		part(3, false, "e"),
+	)
+
+	destination := newFakeRedis(t, logicalRecord(0, "other", "value", -1))
+	defer destination.Close()
+
+	conflict, err := NewConflictPolicy(OnConflictSkip)
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := (&RestoreLauncher{}).SetHost(destination.Addr).SetPath(path).SetThreadCount(1).
+		SetConflictPolicy(conflict).Launch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Succeeded: 1}, result)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, destination.Value(t, 0, "big"))
+}
@@ -0,0 +1,238 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/atomic"
+)
+
+const (
+	OnConflictReplace      = "replace"
+	OnConflictSkip         = "skip"
+	OnConflictFail         = "fail"
+	OnConflictKeepNewerTTL = "keep-newer-ttl"
+)
+
+// ConflictPolicy decides what happens to a key which already exists on the
+// destination, and counts the decisions taken.
+type ConflictPolicy struct {
+	OnConflict string
+	Skipped    atomic.Uint64
+	Replaced   atomic.Uint64
+	Failed     atomic.Uint64
+}
+
+// keyNotExistTTL is the PTTL reply for a missing key.
+const keyNotExistTTL = -2 * time.Millisecond
+
+type KeyExistsError struct {
+	Key string
+}
+
+func (e *KeyExistsError) Error() string {
+
+	return fmt.Sprintf("key %q already exists on the destination", e.Key)
+}
+
+func NewConflictPolicy(onConflict string) (*ConflictPolicy, error) {
+
+	switch onConflict {
+	case "", OnConflictReplace:
+		return nil, nil
+	case OnConflictSkip, OnConflictFail, OnConflictKeepNewerTTL:
+		return &ConflictPolicy{OnConflict: onConflict}, nil
+	}
+
+	return nil, &ConfigError{Err: fmt.Errorf("unknown on-conflict policy %q, use replace, skip, fail or keep-newer-ttl", onConflict)}
+}
+
+func (c *ConflictPolicy) PrintReport() {
+
+	if c == nil {
+		return
+	}
+
+	log.Printf("Existing keys (on-conflict=%s): %d skipped, %d replaced, %d failed.\n",
+		c.OnConflict, c.Skipped.Load(), c.Replaced.Load(), c.Failed.Load())
+}
+
+// Destination writes dumped payloads into one destination database, applying
+// the transient retries, the write rate limits and the conflict policy. With a
+// Plan nothing is written, the decisions are recorded in the plan instead.
+type Destination struct {
+	Client                  *redis.Client
+	DatabaseId              uint64
+	Policy                  *ErrorPolicy
+	Limits                  *RateLimits
+	Conflict                *ConflictPolicy
+	Plan                    *Plan
+	IsSupportReplaceRestore bool
+}
+
+// Restore writes the payload under key, restored is false when an existing
+// destination key was kept.
+func (d *Destination) Restore(ctx context.Context, key string, ttl time.Duration, value string) (restored bool, err error) {
+
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	if d.Plan != nil {
+		return d.plan(ctx, key, ttl)
+	}
+
+	if d.Conflict == nil {
+		return true, d.replace(ctx, key, ttl, value)
+	}
+
+	err = d.restore(ctx, key, ttl, value)
+	if !isBusyKey(err) {
+		return err == nil, err
+	}
+
+	switch d.Conflict.OnConflict {
+	case OnConflictFail:
+		d.Conflict.Failed.Inc()
+		return false, &KeyExistsError{Key: key}
+
+	case OnConflictKeepNewerTTL:
+		destinationTTL, err := d.ttl(ctx, key)
+		if err != nil {
+			return false, err
+		}
+
+		if destinationTTL == keyNotExistTTL {
+			return true, d.restore(ctx, key, ttl, value)
+		}
+
+		if !isNewerTTL(ttl, destinationTTL) {
+			break
+		}
+
+		if err = d.replace(ctx, key, ttl, value); err != nil {
+			return false, err
+		}
+
+		d.Conflict.Replaced.Inc()
+		return true, nil
+	}
+
+	d.Conflict.Skipped.Inc()
+	return false, nil
+}
+
+func (d *Destination) Delete(ctx context.Context, key string) error {
+
+	if d.Plan != nil {
+		return d.Plan.Add(d.DatabaseId, key, PlanDelete)
+	}
+
+	return d.Policy.Retry(ctx, "DEL", func() (err error) {
+		if err = d.Limits.Write(ctx, 1, 0); err != nil {
+			return
+		}
+		_, err = d.Client.Del(key).Result()
+		return
+	})
+}
+
+// plan decides what Restore would do with key, the same way but reading the
+// destination key TTL instead of trying RESTORE.
+func (d *Destination) plan(ctx context.Context, key string, ttl time.Duration) (restored bool, err error) {
+
+	destinationTTL, err := d.ttl(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	action := PlanReplace
+	switch {
+	case destinationTTL == keyNotExistTTL:
+		action = PlanCreate
+	case d.Conflict == nil:
+	case d.Conflict.OnConflict == OnConflictFail:
+		action = PlanFail
+	case d.Conflict.OnConflict == OnConflictKeepNewerTTL && isNewerTTL(ttl, destinationTTL):
+	default:
+		action = PlanSkip
+	}
+
+	if err = d.Plan.Add(d.DatabaseId, key, action); err != nil {
+		return false, err
+	}
+
+	return action == PlanCreate || action == PlanReplace, nil
+}
+
+func (d *Destination) replace(ctx context.Context, key string, ttl time.Duration, value string) error {
+
+	if d.IsSupportReplaceRestore {
+
+		return d.Policy.Retry(ctx, "RESTORE", func() (err error) {
+			if err = d.Limits.Write(ctx, 1, len(value)); err != nil {
+				return
+			}
+			_, err = d.Client.RestoreReplace(key, ttl, value).Result()
+			return
+		})
+	}
+
+	if err := d.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	return d.restore(ctx, key, ttl, value)
+}
+
+func (d *Destination) restore(ctx context.Context, key string, ttl time.Duration, value string) error {
+
+	return d.Policy.Retry(ctx, "RESTORE", func() (err error) {
+		if err = d.Limits.Write(ctx, 1, len(value)); err != nil {
+			return
+		}
+		_, err = d.Client.Restore(key, ttl, value).Result()
+		return
+	})
+}
+
+func (d *Destination) ttl(ctx context.Context, key string) (ttl time.Duration, err error) {
+
+	err = d.Policy.Retry(ctx, "PTTL", func() (err error) {
+		if err = d.Limits.Write(ctx, 1, 0); err != nil {
+			return
+		}
+		ttl, err = d.Client.PTTL(key).Result()
+		return
+	})
+	return
+}
+
+func isBusyKey(err error) bool {
+
+	return err != nil && strings.HasPrefix(err.Error(), "BUSYKEY")
+}
+
+// isNewerTTL tells whether a key expiring after source outlives one expiring
+// after destination, a TTL of 0 or less meaning the key never expires. A
+// missing destination key is outlived by any key.
+func isNewerTTL(source, destination time.Duration) bool {
+
+	if destination == keyNotExistTTL {
+		return true
+	}
+
+	if source <= 0 {
+		return destination > 0
+	}
+
+	if destination <= 0 {
+		return false
+	}
+
+	return source > destination
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConflictPolicy(t *testing.T) {
+
+	cases := []struct {
+		onConflict string
+		isNil      bool
+		isValid    bool
+	}{
+		{"", true, true},
+		{OnConflictReplace, true, true},
+		{OnConflictSkip, false, true},
+		{OnConflictFail, false, true},
+		{OnConflictKeepNewerTTL, false, true},
+		{"keep-newer", true, false},
+	}
+
+	for _, c := range cases {
+
+		conflict, err := NewConflictPolicy(c.onConflict)
+		if !c.isValid {
+
+			assert.IsType(t, &ConfigError{}, err, c.onConflict)
+			continue
+		}
+		assert.NoError(t, err, c.onConflict)
+		assert.Equal(t, c.isNil, conflict == nil, c.onConflict)
+		if conflict != nil {
+			assert.Equal(t, c.onConflict, conflict.OnConflict)
+		}
+	}
+}
+
+func TestIsNewerTTL(t *testing.T) {
+
+	const noExpiration = -time.Millisecond
+	cases := []struct {
+		name        string
+		source      time.Duration
+		destination time.Duration
+		isNewer     bool
+	}{
+		{"both persistent", 0, noExpiration, false},
+		{"persistent source", 0, 10 * time.Second, true},
+		{"persistent destination", 10 * time.Second, noExpiration, false},
+		{"missing destination", 10 * time.Second, keyNotExistTTL, true},
+		{"persistent source, missing destination", 0, keyNotExistTTL, true},
+		{"source expires later", 10 * time.Second, 5 * time.Second, true},
+		{"source expires sooner", 5 * time.Second, 10 * time.Second, false},
+		{"equal TTLs", 10 * time.Second, 10 * time.Second, false},
+		{"persistent records", -time.Second, -time.Second, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.isNewer, isNewerTTL(c.source, c.destination), c.name)
+	}
+}
+
+func TestDestination_Restore(t *testing.T) {
+
+	source := payloadRecord(0, "k", TypeString, "new", -1)
+	cases := []struct {
+		onConflict string
+		ttl        time.Duration
+		restored   bool
+		isFailed   bool
+		value      string
+	}{
+		{OnConflictReplace, 0, true, false, "new"},
+		{OnConflictSkip, 0, false, false, "old"},
+		{OnConflictFail, 0, false, true, "old"},
+		{OnConflictKeepNewerTTL, time.Second, false, false, "old"},
+		{OnConflictKeepNewerTTL, time.Hour, true, false, "new"},
+		{OnConflictKeepNewerTTL, 0, true, false, "new"},
+	}
+
+	for _, c := range cases {
+
+		fake := newFakeRedis(t, payloadRecord(0, "k", TypeString, "old", 60))
+		client := redis.NewClient(&redis.Options{Addr: fake.Addr})
+		conflict, err := NewConflictPolicy(c.onConflict)
+		assert.NoError(t, err)
+
+		destination := &Destination{Client: client, Conflict: conflict, IsSupportReplaceRestore: true}
+		restored, err := destination.Restore(context.Background(), "k", c.ttl, source.Value)
+		assert.Equal(t, c.restored, restored, "%s %s", c.onConflict, c.ttl)
+		assert.Equal(t, c.isFailed, err != nil, "%s %s", c.onConflict, c.ttl)
+		assert.Equal(t, c.value, fake.Value(t, 0, "k"), "%s %s", c.onConflict, c.ttl)
+
+		// a missing key is restored whatever the policy
+		restored, err = destination.Restore(context.Background(), "missing", c.ttl, source.Value)
+		assert.NoError(t, err)
+		assert.True(t, restored)
+
+		client.Close()
+		fake.Close()
+	}
+}
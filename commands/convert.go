@@ -0,0 +1,324 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// recordWriter is the output of a conversion.
+type recordWriter interface {
+	Write(record *Record) error
+	Close() error
+}
+
+// respFileWriter writes records as RESP commands, a SELECT opening every run
+// of records of the same database.
+type respFileWriter struct {
+	Path   string
+	stream *os.File
+	writer *bufio.Writer
+	dbId   int64
+}
+
+func newRESPFileWriter(path string) (*respFileWriter, error) {
+
+	stream, err := newStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &respFileWriter{Path: path, stream: stream, writer: bufio.NewWriterSize(stream, 64*1024), dbId: -1}, nil
+}
+
+func (w *respFileWriter) Write(record *Record) error {
+
+	data, err := record.RESP(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if int64(record.DatabaseId) != w.dbId {
+
+		data = append(selectRESP(record.DatabaseId), data...)
+		w.dbId = int64(record.DatabaseId)
+	}
+
+	if _, err = w.writer.Write(data); err != nil {
+
+		return fmt.Errorf("Write file %s error, %s", w.Path, err)
+	}
+
+	return nil
+}
+
+func (w *respFileWriter) Close() error {
+
+	err := w.writer.Flush()
+	if closeErr := w.stream.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Converter rewrites a dump file in another format without a redis: JSON
+// lines of DUMP payloads (json), JSON lines of logical values
+// (json-logical), RESP commands (resp) or an RDB file (rdb). Records are the
+// common form, a RESP file being replayed in memory and an RDB file read key
+// by key. An empty InputFormat is detected from the file. Keys which cannot
+// be converted, such as streams to DUMP payloads, are counted as Failed.
+type Converter struct {
+	Input        string
+	InputFormat  string
+	Output       string
+	OutputFormat string
+}
+
+func (c *Converter) Convert(ctx context.Context) (result Result, err error) {
+
+	if err = checkOutput(c.Output, c.Input); err != nil {
+		return
+	}
+
+	inputFormat := c.InputFormat
+	if inputFormat == "" {
+
+		if inputFormat, err = detectFormat(c.Input); err != nil {
+			return
+		}
+	}
+
+	for _, format := range []string{inputFormat, c.OutputFormat} {
+
+		switch format {
+		case FormatJSON, FormatJSONLogical, FormatRESP, FormatRDB:
+		default:
+			return result, &ConfigError{Err: fmt.Errorf("unknown format %q, use json, json-logical, resp or rdb", format)}
+		}
+	}
+
+	writer, err := c.newWriter(ctx, inputFormat)
+	if err != nil {
+		return
+	}
+
+	parts := make(map[string]*Record)
+	err = readRecords(ctx, c.Input, inputFormat, func(record *Record) error {
+
+		converted, err := c.convert(record, parts)
+		if err != nil {
+
+			log.Printf("Convert key \"%s\" error, %s\n", record.Key, err)
+			result.Failed++
+			return nil
+		}
+
+		if converted == nil {
+			return nil
+		}
+
+		if err = writer.Write(converted); err != nil {
+			return err
+		}
+
+		if !converted.More {
+			result.Succeeded++
+		}
+		return nil
+	})
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	return
+}
+
+// newWriter opens the output. An RDB file header holds the RDB version, the
+// newest one of the input payloads, found by reading the input once first.
+func (c *Converter) newWriter(ctx context.Context, inputFormat string) (recordWriter, error) {
+
+	switch c.OutputFormat {
+	case FormatRESP:
+		return newRESPFileWriter(c.Output)
+
+	case FormatRDB:
+		var version uint16
+		err := readRecords(ctx, c.Input, inputFormat, func(record *Record) error {
+
+			if record.Type == "" && !record.Deleted && payloadVersion(record.Value) > version {
+				version = payloadVersion(record.Value)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRDBWriter(c.Output, version)
+	}
+
+	return NewDumpFileWriter(c.Output)
+}
+
+// convert returns the record in the output format: logical values encoded
+// as DUMP payloads for json and rdb, their parts being gathered in parts
+// first, and payloads decoded for json-logical. A nil record is a part kept
+// for later.
+func (c *Converter) convert(record *Record, parts map[string]*Record) (*Record, error) {
+
+	if record.Deleted {
+
+		if c.OutputFormat == FormatRDB {
+			return nil, fmt.Errorf("deletions cannot be written to an RDB file")
+		}
+		return record, nil
+	}
+
+	switch c.OutputFormat {
+	case FormatJSONLogical:
+		if record.Type != "" {
+			return record, nil
+		}
+
+		keyType, value, err := payloadToLogical(record.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		converted := *record
+		converted.Type, converted.Value = keyType, value
+		return &converted, nil
+
+	case FormatJSON, FormatRDB:
+		if record.Type == "" {
+			return record, nil
+		}
+
+		id := fmt.Sprintf("%d:%s", record.DatabaseId, record.Key)
+		gathered, isExist := parts[id]
+		if isExist {
+
+			previous, err := decodeLogical(gathered.Type, gathered.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := decodeLogical(record.Type, record.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := encodeLogical(mergeValue(previous, data))
+			if err != nil {
+				return nil, err
+			}
+			gathered.Value, gathered.TTL, gathered.Hash = value, record.TTL, record.Hash
+		} else {
+
+			copied := *record
+			gathered = &copied
+			gathered.Part = 0
+		}
+
+		if record.More {
+
+			gathered.More = false
+			parts[id] = gathered
+			return nil, nil
+		}
+		delete(parts, id)
+
+		payload, err := logicalToPayload(gathered.Type, gathered.Value)
+		if err != nil {
+
+			// the JSON format holds logical values as well
+			if c.OutputFormat == FormatJSON {
+				return gathered, nil
+			}
+			return nil, err
+		}
+
+		gathered.Type, gathered.Value = "", payload
+		return gathered, nil
+	}
+
+	return record, nil
+}
+
+// readRecords calls read with every record of a file in format.
+func readRecords(ctx context.Context, path, format string, read func(record *Record) error) error {
+
+	switch format {
+	case FormatRDB:
+		return ReadRDBFile(ctx, path, read)
+	case FormatRESP:
+		return readRESPFile(ctx, path, read)
+	}
+
+	return ReadDumpFile(ctx, path, read)
+}
+
+// readRESPFile replays the commands of a RESP file or AOF into a Keyspace,
+// then calls read with its keys.
+func readRESPFile(ctx context.Context, path string, read func(record *Record) error) error {
+
+	keyspace := NewKeyspace()
+	now := time.Now()
+	count := 0
+	err := replayAOF(ctx, path, func(args []string) error {
+
+		count++
+		if err := keyspace.Apply(args, now); err != nil {
+
+			return &ConfigError{Err: fmt.Errorf("%s command %d, %s", path, count, err)}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return keyspace.Each(now, read)
+}
+
+// detectFormat tells the format of a file from its first bytes: the RDB
+// header, a RESP array, or else JSON lines. An AOF with an RDB preamble and
+// a redis 7 appendonlydir or its manifest are read as RESP.
+func detectFormat(path string) (string, error) {
+
+	if info, err := os.Stat(path); err == nil && isAOFDir(path, info) {
+		return FormatRESP, nil
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+
+		return "", &ConfigError{Err: fmt.Errorf("Open data file error, %s", err)}
+	}
+	defer fp.Close()
+
+	isRDB, err := isRDBFile(fp)
+	if err != nil {
+		return "", err
+	}
+
+	if isRDB {
+
+		// an AOF with an RDB preamble is replayed, its commands included
+		if isAOF, err := hasRDBPreamble(fp); err != nil || isAOF {
+			return FormatRESP, err
+		}
+		return FormatRDB, nil
+	}
+
+	if isRESP, err := isRESPFile(fp); err != nil || isRESP {
+		return FormatRESP, err
+	}
+
+	return FormatJSON, nil
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConverter_Convert converts a dump file through every format and back,
+// the keys staying the same.
+func TestConverter_Convert(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	input := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, input,
+		payloadRecord(0, "string", TypeString, "\x00binary", -1),
+		logicalRecord(0, "hash", map[string]string{"a": "1", "b": "2"}, 1000),
+		&Record{Key: "list", Type: TypeList, Value: `["a","b"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "list", Type: TypeList, Value: `["c"]`, TTL: -1, Part: 2},
+		payloadRecord(2, "set", TypeSet, []string{"a", "b"}, -1),
+		logicalRecord(2, "zset", map[string]Score{"a": 1, "b": -2.5}, -1),
+	)
+
+	steps := []struct {
+		format       string
+		inputFormat  string
+		isDetectable bool
+	}{
+		{FormatRDB, FormatJSON, true},
+		{FormatJSONLogical, FormatRDB, true},
+		{FormatRESP, FormatJSONLogical, false},
+		{FormatJSON, FormatRESP, true},
+	}
+
+	path := input
+	for _, step := range steps {
+
+		if step.isDetectable {
+
+			format, err := detectFormat(path)
+			assert.NoError(t, err)
+			assert.Equal(t, step.inputFormat, format)
+		}
+
+		output := filepath.Join(dir, "dump."+step.format)
+		converter := &Converter{Input: path, InputFormat: step.inputFormat, Output: output, OutputFormat: step.format}
+		result, err := converter.Convert(context.Background())
+		if !assert.NoError(t, err, step.format) {
+			return
+		}
+		assert.Equal(t, Result{Succeeded: 5}, result, step.format)
+		path = output
+	}
+
+	now := time.Now()
+	expected := keyspaceOf(t, now, input)
+	converted := keyspaceOf(t, now, path)
+	assert.Equal(t, expected.Databases(), converted.Databases())
+	for _, dbId := range expected.Databases() {
+
+		assert.Equal(t, expected.Keys(dbId, now), converted.Keys(dbId, now))
+		for _, key := range expected.Keys(dbId, now) {
+
+			want, _ := expected.Lookup(dbId, key, now).Value()
+			entry := converted.Lookup(dbId, key, now)
+			got, err := entry.Value()
+			assert.NoError(t, err, key)
+			assert.Equal(t, want, got, key)
+			assert.InDelta(t, expected.Lookup(dbId, key, now).TTL(now), entry.TTL(now), 2000, key)
+		}
+	}
+}
+
+func TestConverter_ConvertStreamToRDB(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	input := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, input,
+		logicalRecord(0, "stream", []StreamEntry{{ID: "1-0", Values: map[string]string{"f": "v"}}}, -1),
+		logicalRecord(0, "string", "v", -1),
+		&Record{Key: "gone", Deleted: true},
+	)
+
+	converter := &Converter{Input: input, Output: filepath.Join(dir, "dump.rdb"), OutputFormat: FormatRDB}
+	result, err := converter.Convert(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Succeeded: 1, Failed: 2}, result)
+
+	converter = &Converter{Input: input, Output: filepath.Join(dir, "dump2.json"), OutputFormat: FormatJSON}
+	result, err = converter.Convert(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Succeeded: 3}, result)
+}
@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DatabaseMap tells which destination database receives the keys of a source
+// database, e.g. "0:3,1:4" or "*:0" to consolidate everything into DB 0.
+// Databases not listed keep their number.
+type DatabaseMap struct {
+	mapping map[uint64]uint64
+	all     bool
+	allTo   uint64
+}
+
+func ParseDatabaseMap(mapString string) (*DatabaseMap, error) {
+
+	if strings.TrimSpace(mapString) == "" {
+		return nil, nil
+	}
+
+	dbMap := &DatabaseMap{mapping: make(map[uint64]uint64)}
+	for _, part := range strings.Split(mapString, ",") {
+
+		pair := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(pair) != 2 {
+
+			return nil, &ConfigError{Err: fmt.Errorf("invalid db-map entry %q, use source:destination", part)}
+		}
+
+		to, err := strconv.ParseUint(strings.TrimSpace(pair[1]), 10, 64)
+		if err != nil {
+
+			return nil, &ConfigError{Err: fmt.Errorf("invalid db-map destination %q, %s", pair[1], err)}
+		}
+
+		from := strings.TrimSpace(pair[0])
+		if from == "*" {
+
+			dbMap.all = true
+			dbMap.allTo = to
+			continue
+		}
+
+		fromId, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+
+			return nil, &ConfigError{Err: fmt.Errorf("invalid db-map source %q, %s", from, err)}
+		}
+
+		if _, isExist := dbMap.mapping[fromId]; isExist {
+
+			return nil, &ConfigError{Err: fmt.Errorf("db-map source %d is mapped twice", fromId)}
+		}
+
+		dbMap.mapping[fromId] = to
+	}
+
+	return dbMap, nil
+}
+
+func (m *DatabaseMap) Map(dbId uint64) uint64 {
+
+	if m == nil {
+		return dbId
+	}
+
+	if to, isExist := m.mapping[dbId]; isExist {
+		return to
+	}
+
+	if m.all {
+		return m.allTo
+	}
+
+	return dbId
+}
+
+// IsMerging tells whether several source databases may land in the same
+// destination database, their keys colliding there.
+func (m *DatabaseMap) IsMerging() bool {
+
+	if m == nil {
+		return false
+	}
+
+	if m.all {
+		return true
+	}
+
+	destinations := make(map[uint64]bool)
+	for from, to := range m.mapping {
+
+		if destinations[to] {
+			return true
+		}
+		destinations[to] = true
+
+		// an unlisted database keeps its number
+		if _, isListed := m.mapping[to]; !isListed && to != from {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *DatabaseMap) String() string {
+
+	if m == nil {
+		return ""
+	}
+
+	var sources []uint64
+	for from := range m.mapping {
+		sources = append(sources, from)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+
+	var parts []string
+	for _, from := range sources {
+		parts = append(parts, fmt.Sprintf("%d:%d", from, m.mapping[from]))
+	}
+
+	if m.all {
+		parts = append(parts, fmt.Sprintf("*:%d", m.allTo))
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDatabaseMap(t *testing.T) {
+
+	cases := []struct {
+		mapString string
+		mapped    map[uint64]uint64
+		text      string
+		isMerging bool
+	}{
+		{"", map[uint64]uint64{0: 0, 5: 5}, "", false},
+		{"0:3, 1:4", map[uint64]uint64{0: 3, 1: 4, 3: 3, 5: 5}, "0:3,1:4", true},
+		{"0:1,1:0", map[uint64]uint64{0: 1, 1: 0, 2: 2}, "0:1,1:0", false},
+		{"2:2", map[uint64]uint64{2: 2, 3: 3}, "2:2", false},
+		{"0:3,1:3", map[uint64]uint64{0: 3, 1: 3}, "0:3,1:3", true},
+		{"*:0", map[uint64]uint64{0: 0, 7: 0}, "*:0", true},
+		{"1:2,*:0", map[uint64]uint64{0: 0, 1: 2, 7: 0}, "1:2,*:0", true},
+	}
+
+	for _, c := range cases {
+
+		dbMap, err := ParseDatabaseMap(c.mapString)
+		if !assert.NoError(t, err, c.mapString) {
+			continue
+		}
+
+		for from, to := range c.mapped {
+			assert.Equal(t, to, dbMap.Map(from), "%s maps %d", c.mapString, from)
+		}
+		assert.Equal(t, c.text, dbMap.String(), c.mapString)
+		assert.Equal(t, c.isMerging, dbMap.IsMerging(), c.mapString)
+	}
+
+	for _, mapString := range []string{"0", "0:x", "x:0", "-1:0", "0:1,0:2", "0:1,"} {
+
+		_, err := ParseDatabaseMap(mapString)
+		assert.IsType(t, &ConfigError{}, err, mapString)
+	}
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+const (
+	DeleteExtraneousOff    = "0"
+	DeleteExtraneousOn     = "1"
+	DeleteExtraneousDryRun = "dry-run"
+)
+
+// DeletionGuard lets sync delete the destination keys missing on the source.
+// Protected keys are never deleted, and a round which would delete more than
+// MaxCount keys or MaxPercent of the destination database deletes nothing,
+// the sync going on. With DryRun the keys are only listed.
+type DeletionGuard struct {
+	DryRun     bool
+	MaxCount   uint64
+	MaxPercent float64
+	Protected  []string
+}
+
+type DeletionLimitError struct {
+	DatabaseId uint64
+	Count      uint64
+	Total      uint64
+	Limit      string
+}
+
+func (e *DeletionLimitError) Error() string {
+
+	return fmt.Sprintf("refuse to delete %d of %d keys in destination database %d, above -%s",
+		e.Count, e.Total, e.DatabaseId, e.Limit)
+}
+
+// NewDeletionGuard returns nil when deleteExtraneous is off, sync then never
+// deletes. protectKeys is a comma separated list of glob patterns.
+func NewDeletionGuard(deleteExtraneous string, maxCount uint64, maxPercent float64, protectKeys string) (*DeletionGuard, error) {
+
+	guard := &DeletionGuard{MaxCount: maxCount, MaxPercent: maxPercent}
+	switch deleteExtraneous {
+	case "", DeleteExtraneousOff:
+		return nil, nil
+	case DeleteExtraneousOn:
+	case DeleteExtraneousDryRun:
+		guard.DryRun = true
+	default:
+		return nil, &ConfigError{Err: fmt.Errorf("unknown delete-extraneous %q, use 0, 1 or dry-run", deleteExtraneous)}
+	}
+
+	if maxPercent < 0 || maxPercent > 100 {
+
+		return nil, &ConfigError{Err: fmt.Errorf("max-delete-percent %g out of range 0-100", maxPercent)}
+	}
+
+	for _, pattern := range strings.Split(protectKeys, ",") {
+
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			guard.Protected = append(guard.Protected, pattern)
+		}
+	}
+
+	return guard, nil
+}
+
+func (g *DeletionGuard) IsProtected(key string) bool {
+
+	for _, pattern := range g.Protected {
+
+		if lib.MatchGlob(pattern, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check is called once the extraneous keys of a round are known, total being
+// the number of keys scanned in the destination database.
+func (g *DeletionGuard) Check(dbId, count, total uint64) error {
+
+	if g.MaxCount > 0 && count > g.MaxCount {
+
+		return &DeletionLimitError{DatabaseId: dbId, Count: count, Total: total, Limit: fmt.Sprintf("max-delete=%d", g.MaxCount)}
+	}
+
+	if g.MaxPercent > 0 && total > 0 && float64(count)*100/float64(total) > g.MaxPercent {
+
+		return &DeletionLimitError{DatabaseId: dbId, Count: count, Total: total, Limit: fmt.Sprintf("max-delete-percent=%g", g.MaxPercent)}
+	}
+
+	return nil
+}
+
+func (g *DeletionGuard) List(dbId uint64, keys []string) {
+
+	for _, key := range keys {
+		log.Printf("Dry run, would delete key \"%s\" from destination database(%d)\n", key, dbId)
+	}
+
+	log.Printf("Dry run, %d extraneous key(s) in destination database(%d)\n", len(keys), dbId)
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDeletionGuard(t *testing.T) {
+
+	guard, err := NewDeletionGuard(DeleteExtraneousOff, 10, 5, "a*")
+	assert.NoError(t, err)
+	assert.Nil(t, guard)
+
+	guard, err = NewDeletionGuard(DeleteExtraneousDryRun, 10, 5, " local:*, ,cache:* ")
+	if assert.NoError(t, err) {
+
+		assert.True(t, guard.DryRun)
+		assert.Equal(t, []string{"local:*", "cache:*"}, guard.Protected)
+		assert.True(t, guard.IsProtected("local:1"))
+		assert.False(t, guard.IsProtected("remote:1"))
+	}
+
+	for _, c := range []struct {
+		deleteExtraneous string
+		maxPercent       float64
+	}{
+		{"yes", 0},
+		{DeleteExtraneousOn, -1},
+		{DeleteExtraneousOn, 101},
+	} {
+		_, err = NewDeletionGuard(c.deleteExtraneous, 0, c.maxPercent, "")
+		assert.IsType(t, &ConfigError{}, err, "%s %g", c.deleteExtraneous, c.maxPercent)
+	}
+}
+
+func TestDeletionGuard_Check(t *testing.T) {
+
+	cases := []struct {
+		name       string
+		maxCount   uint64
+		maxPercent float64
+		count      uint64
+		total      uint64
+		limit      string
+	}{
+		{"no limit", 0, 0, 1000, 1000, ""},
+		{"count at the limit", 10, 0, 10, 1000, ""},
+		{"count above the limit", 10, 0, 11, 1000, "max-delete=10"},
+		{"percent at the limit", 0, 5, 5, 100, ""},
+		{"percent above the limit", 0, 5, 6, 100, "max-delete-percent=5"},
+		{"empty destination", 0, 5, 0, 0, ""},
+		{"count checked first", 10, 5, 50, 100, "max-delete=10"},
+	}
+
+	for _, c := range cases {
+
+		guard := &DeletionGuard{MaxCount: c.maxCount, MaxPercent: c.maxPercent}
+		err := guard.Check(3, c.count, c.total)
+		if c.limit == "" {
+
+			assert.NoError(t, err, c.name)
+			continue
+		}
+		assert.Equal(t, &DeletionLimitError{DatabaseId: 3, Count: c.count, Total: c.total, Limit: c.limit}, err, c.name)
+	}
+}
+
+// A round deleting too many keys deletes none, the keys are still written and
+// the sync does not fail. Protected keys are never deleted.
+func TestSynchronizer_DeletionGuard(t *testing.T) {
+
+	cases := []struct {
+		name      string
+		guard     *DeletionGuard
+		remaining []string
+		deleted   uint64
+	}{
+		{"above the limit", &DeletionGuard{MaxCount: 1, Protected: []string{"local:*"}}, []string{"a", "b", "local:z", "x", "y"}, 0},
+		{"within the limit", &DeletionGuard{MaxCount: 2, Protected: []string{"local:*"}}, []string{"a", "b", "local:z"}, 2},
+		{"dry run", &DeletionGuard{DryRun: true}, []string{"a", "b", "local:z", "x", "y"}, 0},
+	}
+
+	for _, c := range cases {
+
+		source := newFakeRedis(t, logicalRecord(0, "a", "new", -1), logicalRecord(0, "b", "new", -1))
+		destination := newFakeRedis(t,
+			logicalRecord(0, "a", "old", -1),
+			logicalRecord(0, "x", "old", -1),
+			logicalRecord(0, "y", "old", -1),
+			logicalRecord(0, "local:z", "old", -1),
+		)
+
+		result, err := (&SyncLauncher{}).SetSourceHost(source.Addr).SetDestinationHost(destination.Addr).
+			SetDatabaseCount(1).SetSyncTimes(1).SetThreadCount(1).SetIsSupportReplaceRestore(true).
+			SetDeletionGuard(c.guard).Launch(context.Background())
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, Result{Succeeded: 2, Deleted: c.deleted}, result, c.name)
+		assert.Equal(t, c.remaining, destination.Keys(0), c.name)
+		assert.Equal(t, "new", destination.Value(t, 0, "a"), c.name)
+
+		source.Close()
+		destination.Close()
+	}
+}
@@ -0,0 +1,292 @@
+package commands
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// keyDigest identifies the content of a key of a dump file: a hash of its
+// type and of the values of all its parts, and its TTL.
+type keyDigest struct {
+	DatabaseId uint64
+	Key        string
+	TTL        int64
+	Sum        string
+	hash       hash.Hash
+}
+
+// digestDumpFile calls f with the digest of every key of a dump file once its
+// last part is read, deletions are ignored.
+func digestDumpFile(ctx context.Context, path string, f func(digest *keyDigest) error) error {
+
+	pending := make(map[string]*keyDigest)
+	return ReadDumpFile(ctx, path, func(record *Record) error {
+
+		if record.Deleted {
+			return nil
+		}
+
+		id := fmt.Sprintf("%d:%s", record.DatabaseId, record.Key)
+		digest, isExist := pending[id]
+		if !isExist {
+
+			digest = &keyDigest{DatabaseId: record.DatabaseId, Key: record.Key, hash: newPayloadHash(record.Type)}
+		}
+
+		digest.hash.Write([]byte(record.Value))
+		digest.TTL = record.TTL
+		if record.More {
+
+			pending[id] = digest
+			return nil
+		}
+
+		delete(pending, id)
+		digest.Sum = hex.EncodeToString(digest.hash.Sum(nil))
+		digest.hash = nil
+		return f(digest)
+	})
+}
+
+type DiffStats struct {
+	DatabaseId uint64 `json:"db"`
+	Added      uint64 `json:"added"`
+	Removed    uint64 `json:"removed"`
+	Modified   uint64 `json:"modified"`
+	Unchanged  uint64 `json:"unchanged"`
+}
+
+// KeyChange is a key added, removed or modified, Reason telling whether the
+// payload, the TTL or both changed.
+type KeyChange struct {
+	DatabaseId uint64 `json:"db"`
+	Key        string `json:"key"`
+	Change     string `json:"change"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+type DiffReport struct {
+	Databases []*DiffStats `json:"databases"`
+	Total     DiffStats    `json:"total"`
+	Keys      []*KeyChange `json:"keys"`
+}
+
+// Differ compares two dump files. A TTL is changed when the key gains or
+// loses its expiration, or when the TTLs differ by more than TTLTolerance
+// seconds. With an Output, the delta is written there as a dump file which
+// restored over Old gives New: the records of the added and modified keys,
+// then a Deleted record per removed key.
+type Differ struct {
+	Old          string
+	New          string
+	Output       string
+	TTLTolerance int64
+}
+
+func (d *Differ) Diff(ctx context.Context) (report *DiffReport, err error) {
+
+	if d.Output != "" {
+
+		if err = checkOutput(d.Output, d.Old, d.New); err != nil {
+			return
+		}
+	}
+
+	old := make(map[string]*keyDigest)
+	err = digestDumpFile(ctx, d.Old, func(digest *keyDigest) error {
+
+		old[fmt.Sprintf("%d:%s", digest.DatabaseId, digest.Key)] = digest
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	databases := make(map[uint64]*DiffStats)
+	stats := func(dbId uint64) *DiffStats {
+
+		db, isExist := databases[dbId]
+		if !isExist {
+
+			db = &DiffStats{DatabaseId: dbId}
+			databases[dbId] = db
+		}
+		return db
+	}
+
+	report = &DiffReport{}
+	changed := make(map[string]bool)
+	err = digestDumpFile(ctx, d.New, func(digest *keyDigest) error {
+
+		id := fmt.Sprintf("%d:%s", digest.DatabaseId, digest.Key)
+		db := stats(digest.DatabaseId)
+		previous, isExist := old[id]
+		if !isExist {
+
+			db.Added++
+			changed[id] = true
+			report.Keys = append(report.Keys, &KeyChange{DatabaseId: digest.DatabaseId, Key: digest.Key, Change: DiffAdded})
+			return nil
+		}
+		delete(old, id)
+
+		reason := ""
+		switch isPayloadChanged, isTTLChanged := previous.Sum != digest.Sum, d.isTTLChanged(previous.TTL, digest.TTL); {
+		case isPayloadChanged && isTTLChanged:
+			reason = "payload,ttl"
+		case isPayloadChanged:
+			reason = "payload"
+		case isTTLChanged:
+			reason = "ttl"
+		default:
+			db.Unchanged++
+			return nil
+		}
+
+		db.Modified++
+		changed[id] = true
+		report.Keys = append(report.Keys, &KeyChange{DatabaseId: digest.DatabaseId, Key: digest.Key, Change: DiffModified, Reason: reason})
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	var removed []*KeyChange
+	for _, digest := range old {
+
+		stats(digest.DatabaseId).Removed++
+		removed = append(removed, &KeyChange{DatabaseId: digest.DatabaseId, Key: digest.Key, Change: DiffRemoved})
+	}
+	sortChanges(removed)
+	sortChanges(report.Keys)
+	report.Keys = append(report.Keys, removed...)
+
+	for _, db := range databases {
+
+		report.Databases = append(report.Databases, db)
+		report.Total.Added += db.Added
+		report.Total.Removed += db.Removed
+		report.Total.Modified += db.Modified
+		report.Total.Unchanged += db.Unchanged
+	}
+	sort.Slice(report.Databases, func(i, j int) bool {
+		return report.Databases[i].DatabaseId < report.Databases[j].DatabaseId
+	})
+
+	if d.Output != "" {
+		err = d.writeDelta(ctx, changed, removed)
+	}
+
+	return
+}
+
+func (d *Differ) isTTLChanged(old, new int64) bool {
+
+	return isTTLChanged(old, new, d.TTLTolerance)
+}
+
+// isTTLChanged tells whether a key gained or lost its expiration, or whether
+// its TTLs differ by more than tolerance seconds.
+func isTTLChanged(old, new, tolerance int64) bool {
+
+	if old <= 0 || new <= 0 {
+		return (old <= 0) != (new <= 0)
+	}
+
+	delta := old - new
+	if delta < 0 {
+		delta = -delta
+	}
+
+	return delta > tolerance
+}
+
+func (d *Differ) writeDelta(ctx context.Context, changed map[string]bool, removed []*KeyChange) (err error) {
+
+	writer, err := NewDumpFileWriter(d.Output)
+	if err != nil {
+		return
+	}
+
+	err = ReadDumpFile(ctx, d.New, func(record *Record) error {
+
+		if record.Deleted || !changed[fmt.Sprintf("%d:%s", record.DatabaseId, record.Key)] {
+			return nil
+		}
+
+		return writer.Write(record)
+	})
+
+	for _, change := range removed {
+
+		if err != nil {
+			break
+		}
+		err = writer.Write(&Record{DatabaseId: change.DatabaseId, Key: change.Key, Deleted: true})
+	}
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	return
+}
+
+func sortChanges(changes []*KeyChange) {
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].DatabaseId != changes[j].DatabaseId {
+			return changes[i].DatabaseId < changes[j].DatabaseId
+		}
+		return changes[i].Key < changes[j].Key
+	})
+}
+
+// Write returns a function writing the report in format, text or json.
+func (report *DiffReport) Write(format string) func(w io.Writer) error {
+
+	if format == ReportJSON {
+		return func(w io.Writer) error { return writeJSON(w, report) }
+	}
+
+	return report.WriteText
+}
+
+// WriteText writes a line per changed key, "+" added, "-" removed and "~"
+// modified, then the counts of every database.
+func (report *DiffReport) WriteText(w io.Writer) error {
+
+	marks := map[string]string{DiffAdded: "+", DiffRemoved: "-", DiffModified: "~"}
+	for _, change := range report.Keys {
+
+		line := fmt.Sprintf("%s %d %q", marks[change.Change], change.DatabaseId, change.Key)
+		if change.Reason != "" {
+			line += " " + change.Reason
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\n  db\tadded\tremoved\tmodified\tunchanged")
+	for _, db := range report.Databases {
+		fmt.Fprintf(tw, "  %d\t%d\t%d\t%d\t%d\n", db.DatabaseId, db.Added, db.Removed, db.Modified, db.Unchanged)
+	}
+	fmt.Fprintf(tw, "  total\t%d\t%d\t%d\t%d\n", report.Total.Added, report.Total.Removed, report.Total.Modified, report.Total.Unchanged)
+
+	return tw.Flush()
+}
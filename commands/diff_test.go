@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffer_Diff(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	oldPath := filepath.Join(dir, "old.json")
+	writeDumpFile(t, oldPath,
+		payloadRecord(0, "same", TypeString, "v", -1),
+		payloadRecord(0, "payload", TypeString, "v1", -1),
+		payloadRecord(0, "ttl", TypeString, "v", 100),
+		payloadRecord(0, "close ttl", TypeString, "v", 100),
+		payloadRecord(0, "persisted", TypeString, "v", 100),
+		payloadRecord(0, "removed", TypeString, "v", -1),
+		payloadRecord(1, "removed", TypeString, "v", -1),
+		&Record{Key: "big", Type: TypeList, Value: `["a","b"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "big", Type: TypeList, Value: `["c"]`, TTL: -1, Part: 2},
+		&Record{Key: "big2", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "big2", Type: TypeList, Value: `["b"]`, TTL: -1, Part: 2},
+	)
+
+	newPath := filepath.Join(dir, "new.json")
+	writeDumpFile(t, newPath,
+		payloadRecord(0, "same", TypeString, "v", -1),
+		payloadRecord(0, "payload", TypeString, "v2", -1),
+		payloadRecord(0, "ttl", TypeString, "v", 1000),
+		payloadRecord(0, "close ttl", TypeString, "v", 95),
+		payloadRecord(0, "persisted", TypeString, "v", -1),
+		payloadRecord(0, "added", TypeHash, map[string]string{"f": "v"}, 50),
+		&Record{Key: "big", Type: TypeList, Value: `["a","b"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "big", Type: TypeList, Value: `["c"]`, TTL: -1, Part: 2},
+		&Record{Key: "big2", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "big2", Type: TypeList, Value: `["c"]`, TTL: -1, Part: 2},
+		&Record{Key: "gone", Deleted: true},
+	)
+
+	delta := filepath.Join(dir, "delta.json")
+	differ := &Differ{Old: oldPath, New: newPath, Output: delta, TTLTolerance: 10}
+	report, err := differ.Diff(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []*KeyChange{
+		{DatabaseId: 0, Key: "added", Change: DiffAdded},
+		{DatabaseId: 0, Key: "big2", Change: DiffModified, Reason: "payload"},
+		{DatabaseId: 0, Key: "payload", Change: DiffModified, Reason: "payload"},
+		{DatabaseId: 0, Key: "persisted", Change: DiffModified, Reason: "ttl"},
+		{DatabaseId: 0, Key: "ttl", Change: DiffModified, Reason: "ttl"},
+		{DatabaseId: 0, Key: "removed", Change: DiffRemoved},
+		{DatabaseId: 1, Key: "removed", Change: DiffRemoved},
+	}, report.Keys)
+	assert.Equal(t, DiffStats{Added: 1, Removed: 2, Modified: 4, Unchanged: 3}, report.Total)
+
+	// restoring the delta over the old file gives the new one
+	now := time.Now()
+	restored := keyspaceOf(t, now, oldPath, delta)
+	expected := keyspaceOf(t, now, newPath)
+	assert.Equal(t, expected.Databases(), restored.Databases())
+	for _, dbId := range expected.Databases() {
+
+		assert.Equal(t, expected.Keys(dbId, now), restored.Keys(dbId, now), "db %d", dbId)
+		for _, key := range expected.Keys(dbId, now) {
+
+			want, _ := expected.Lookup(dbId, key, now).Value()
+			entry := restored.Lookup(dbId, key, now)
+			got, _ := entry.Value()
+			assert.Equal(t, want, got, key)
+			assert.False(t, isTTLChanged(expected.Lookup(dbId, key, now).TTL(now)/1000, entry.TTL(now)/1000, differ.TTLTolerance), key)
+		}
+	}
+
+	records := readDumpFile(t, delta)
+	assert.Equal(t, 8, len(records))
+	assert.Equal(t, &Record{DatabaseId: 1, Key: "removed", Deleted: true}, records[len(records)-1])
+}
+
+func TestIsTTLChanged(t *testing.T) {
+
+	cases := []struct {
+		old       int64
+		new       int64
+		tolerance int64
+		isChanged bool
+	}{
+		{-1, -1, 0, false},
+		{-1, 10, 100, true},
+		{10, -1, 100, true},
+		{10, 10, 0, false},
+		{10, 11, 0, true},
+		{10, 20, 10, false},
+		{20, 9, 10, true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.isChanged, isTTLChanged(c.old, c.new, c.tolerance), "%d %d %d", c.old, c.new, c.tolerance)
+	}
+}
@@ -1,12 +1,13 @@
 package commands
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -22,47 +23,93 @@ type Dumper struct {
 	Path        string
 	DatabaseId  uint64
 	Count       atomic.Uint64
+	Failed      atomic.Uint64
 	workers     *lib.Workers
-	hasError    bool
 	Stream      *os.File
 	ThreadCount int
+	Policy      *ErrorPolicy
+	Limits      *RateLimits
+	Throttle    *Throttle
+	Chunker     *Chunker
+	Transfer    string
+	Format      string
+	Visitor     KeyVisitor
+	IsFrequency bool
+	Baseline    *Baseline
 }
 
 type DumpWorker struct {
-	Client     *redis.Client
-	DatabaseId uint64
-	stream     *os.File
+	Client      *redis.Client
+	DatabaseId  uint64
+	Policy      *ErrorPolicy
+	Limits      *RateLimits
+	Chunker     *Chunker
+	Transfer    string
+	Format      string
+	Visitor     KeyVisitor
+	IsFrequency bool
+	Baseline    *Baseline
+	stream      *os.File
+}
+
+type DumpLauncher struct {
+	Host             string
+	Password         string
+	Path             string
+	DatabaseCount    uint64
+	ThreadCount      int
+	Policy           *ErrorPolicy
+	Limits           *RateLimits
+	TargetLatency    time.Duration
+	ThrottleInterval time.Duration
+	Chunker          *Chunker
+	Transfer         string
+	Format           string
+	Visitor          KeyVisitor
+	IsFrequency      bool
+	Baseline         *Baseline
 }
 
-func (d *Dumper) Dump() {
+func (d *Dumper) Dump(ctx context.Context) (err error) {
+
+	if d.Format == FormatRESP && d.Visitor == nil {
+
+		if _, err = d.Stream.Write(selectRESP(d.DatabaseId)); err != nil {
 
-	d.initSemaphore(d.ThreadCount)
+			d.CloseClient()
+			return
+		}
+	}
+
+	d.initSemaphore(ctx, d.ThreadCount)
+	d.Throttle.Attach(d.workers)
+	defer d.Throttle.Detach(d.workers)
 
 	cursor := uint64(0)
 
 	for {
 
-		keys, nextCursor, err := d.scan(cursor)
+		keys, nextCursor, err := d.scan(ctx, cursor)
 		if err != nil {
 
 			log.Printf("Error: Scan keys error, %s\n", err)
+			d.workers.Fail(err)
 			break
 		}
 
 		for _, key := range keys {
 
-			worker := d.getSemaphore()
+			key := key
+			d.Baseline.See(d.DatabaseId, key)
+			err = d.workers.Go(ctx, func(ctx context.Context, worker interface{}) error {
 
-			go func(key string) {
-
-				defer func() {
-					d.putSemaphore(worker)
-				}()
-				err := worker.Dump(key)
+				err := d.Policy.Do(ctx, func() error {
+					return worker.(*DumpWorker).Dump(ctx, key)
+				})
 
 				if err != nil {
-					d.hasError = true
-					return
+					d.Failed.Inc()
+					return d.Policy.Failed(&Record{DatabaseId: d.DatabaseId, Key: key}, err)
 				}
 
 				d.Count.Inc()
@@ -71,16 +118,15 @@ func (d *Dumper) Dump() {
 					d.PrintReport()
 				}
 
-			}(key)
+				return nil
+			})
 
-			if d.hasError {
+			if err != nil {
 				break
 			}
 		}
 
-		d.workers.Wait()
-
-		if d.hasError {
+		if err := d.workers.Wait(ctx); err != nil {
 			break
 		}
 
@@ -91,15 +137,36 @@ func (d *Dumper) Dump() {
 		cursor = nextCursor
 	}
 
+	err = d.workers.Err()
+	if err == nil && d.Visitor == nil {
+		d.writeRemoved()
+	}
+
 	d.CloseClient()
 	d.closeSemaphore()
 
 	d.PrintReport()
+	return
+}
+
+// writeRemoved writes a Deleted record per key of the baseline gone from the
+// database.
+func (d *Dumper) writeRemoved() {
+
+	for _, key := range d.Baseline.RemovedKeys(d.DatabaseId) {
+		writeRecord(d.Stream, d.Format, &Record{DatabaseId: d.DatabaseId, Key: key, Deleted: true})
+	}
 }
 
-func (d *Dumper) scan(cursor uint64) (keys []string, nextCursor uint64, err error) {
+func (d *Dumper) scan(ctx context.Context, cursor uint64) (keys []string, nextCursor uint64, err error) {
 
-	keys, nextCursor, err = d.Client.Scan(cursor, "", 100).Result()
+	err = d.Policy.Retry(ctx, "SCAN", func() (err error) {
+		if err = d.Limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		keys, nextCursor, err = d.Client.Scan(cursor, "", 100).Result()
+		return
+	})
 	return
 }
 
@@ -112,55 +179,80 @@ func (d *Dumper) CloseClient() {
 	d.Client.Close()
 }
 
+func (d *Dumper) Result() Result {
+
+	return Result{
+		Succeeded: d.Count.Load(),
+		Failed:    d.Failed.Load(),
+	}
+}
+
 func (d *Dumper) PrintReport() {
 
 	log.Printf("DB %d dumped %d Record(s).\n", d.DatabaseId, d.Count.Load())
 }
 
-func (d *Dumper) initSemaphore(threadCount int) {
+func (d *Dumper) initSemaphore(ctx context.Context, threadCount int) {
 
-	d.workers = lib.NewWorkers(threadCount,
+	d.workers = lib.NewWorkers(ctx, threadCount,
 		func() interface{} {
 			return &DumpWorker{
-				Client:     d.Client,
-				DatabaseId: d.DatabaseId,
-				stream:     d.Stream,
+				Client:      d.Client,
+				DatabaseId:  d.DatabaseId,
+				Policy:      d.Policy,
+				Limits:      d.Limits,
+				Chunker:     d.Chunker,
+				Transfer:    d.Transfer,
+				Format:      d.Format,
+				Visitor:     d.Visitor,
+				IsFrequency: d.IsFrequency,
+				Baseline:    d.Baseline,
+				stream:      d.Stream,
 			}
 		},
 	)
 }
 
-func (d *Dumper) getSemaphore() *DumpWorker {
-
-	dw := d.workers.Get()
-	return dw.(*DumpWorker)
-}
-
-func (d *Dumper) putSemaphore(dw *DumpWorker) {
-
-	d.workers.Put(dw)
-}
-
 func (d *Dumper) closeSemaphore() {
 
-	d.workers.Wait()
 	for d.workers.IdleCount() > 0 {
 
-		worker := d.getSemaphore()
-		if worker == nil {
+		worker, err := d.workers.Get(context.Background())
+		if err != nil {
 
 			break
 		}
 
-		worker.CloseClient()
+		worker.(*DumpWorker).CloseClient()
 	}
 }
 
-func (dw *DumpWorker) Dump(key string) (err error) {
+func (dw *DumpWorker) Dump(ctx context.Context, key string) (err error) {
+
+	if dw.Visitor != nil {
+
+		return dw.visit(ctx, key)
+	}
+
+	keyType, err := dw.Chunker.IsBig(ctx, dw.Client, key, dw.Policy, dw.Limits)
+	if err != nil {
+
+		log.Printf("Error: Get key size error, %s\n", err)
+		return
+	}
+
+	if keyType != "" {
+
+		return dw.dumpChunks(ctx, key, keyType)
+	}
 
 	record := &Record{Key: key}
 
-	record.Value, err = dw.getSerializeString(key)
+	if dw.Transfer == TransferLogical {
+		record.Type, record.Value, err = ReadLogical(ctx, dw.Client, key, dw.Policy, dw.Limits)
+	} else {
+		record.Value, err = dw.getSerializeString(ctx, key)
+	}
 
 	if err != nil {
 
@@ -172,7 +264,7 @@ func (dw *DumpWorker) Dump(key string) (err error) {
 		return
 	}
 
-	record.TTL, err = dw.getTTL(key)
+	record.TTL, err = dw.getTTL(ctx, key)
 	if err != nil {
 
 		log.Printf("Error: Get key ttl error, %s\n", err)
@@ -180,35 +272,122 @@ func (dw *DumpWorker) Dump(key string) (err error) {
 	}
 
 	record.DatabaseId = dw.DatabaseId
+	record.Hash = payloadHash(record.Type, record.Value)
+	if !dw.Baseline.IsChanged(dw.DatabaseId, key, record.Hash, record.TTL) {
+		return
+	}
 
 	dw.writeRecord(record)
 	return
 }
 
-func (dw *DumpWorker) getSerializeString(key string) (value string, err error) {
+func (dw *DumpWorker) visit(ctx context.Context, key string) error {
+
+	info, err := inspectKey(ctx, dw.Client, dw.DatabaseId, key, dw.IsFrequency, dw.Policy, dw.Limits)
+	if err != nil {
+
+		log.Printf("Error: Inspect key \"%s\" error, %s\n", key, err)
+		return err
+	}
+
+	if info == nil {
+		return nil
+	}
+
+	return dw.Visitor.Visit(info)
+}
+
+// dumpChunks writes a big key as several records, a retry writes the key again
+// from its first part, which restarts the key on restore. Its hash is only
+// known once written, so an incremental dump always includes it.
+func (dw *DumpWorker) dumpChunks(ctx context.Context, key, keyType string) (err error) {
 
-	value, err = dw.Client.Dump(key).Result()
+	ttl, err := dw.getTTL(ctx, key)
+	if err != nil {
+
+		log.Printf("Error: Get key ttl error, %s\n", err)
+		return
+	}
+
+	log.Printf("Big %s key \"%s\", dumped in chunks of %d\n", keyType, key, dw.Chunker.ChunkSize)
+	reader := dw.Chunker.Reader(dw.Client, key, keyType, dw.Policy, dw.Limits)
+	keyHash := newPayloadHash(keyType)
+	for part := uint64(1); ; part++ {
+
+		record := &Record{DatabaseId: dw.DatabaseId, Key: key, TTL: ttl, Type: keyType, Part: part}
+		record.Value, err = reader.Next(ctx)
+		if err != nil {
+
+			log.Printf("Error: Read key \"%s\" part %d error, %s\n", key, part, err)
+			return
+		}
+
+		keyHash.Write([]byte(record.Value))
+		record.More = !reader.Done()
+		if !record.More {
+			record.Hash = hex.EncodeToString(keyHash.Sum(nil))
+		}
+		dw.writeRecord(record)
+		if !record.More {
+			return
+		}
+	}
+}
+
+func (dw *DumpWorker) getSerializeString(ctx context.Context, key string) (value string, err error) {
+
+	err = dw.Policy.Retry(ctx, "DUMP", func() (err error) {
+		if err = dw.Limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		value, err = dw.Client.Dump(key).Result()
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	err = dw.Limits.Read(ctx, 0, len(value))
 	return
 }
 
-func (dw *DumpWorker) getTTL(key string) (ttl int64, err error) {
+func (dw *DumpWorker) getTTL(ctx context.Context, key string) (ttl int64, err error) {
 
-	duration, err := dw.Client.TTL(key).Result()
-	ttl = int64(duration.Seconds())
+	var duration time.Duration
+	err = dw.Policy.Retry(ctx, "PTTL", func() (err error) {
+		if err = dw.Limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		duration, err = dw.Client.PTTL(key).Result()
+		return
+	})
+	ttl = ttlSeconds(duration)
 	return
 }
 
 func (dw *DumpWorker) writeRecord(record *Record) {
 
-	record.Value = base64.StdEncoding.EncodeToString([]byte(record.Value))
-	jsonBytes, err := json.Marshal(record)
+	writeRecord(dw.stream, dw.Format, record)
+}
+
+func writeRecord(stream *os.File, format string, record *Record) {
+
+	var data []byte
+	var err error
+	if format == FormatRESP {
+		data, err = record.RESP(time.Now())
+	} else {
+		data, err = record.Marshal()
+		data = append(data, '\n')
+	}
+
 	if err != nil {
 
 		log.Printf("Marshal data error , %s\n", err)
 		return
 	}
 
-	_, err = dw.stream.WriteString(string(jsonBytes) + "\n")
+	_, err = stream.Write(data)
 	if err != nil {
 
 		log.Printf("Write file error: %s\n", err)
@@ -224,40 +403,188 @@ func (dw *DumpWorker) CloseClient() {
 	dw.Client.Close()
 }
 
-func newStream(path string) *os.File {
+func newStream(path string) (*os.File, error) {
 
 	fs, err := os.Create(path)
 	if err != nil {
 
-		log.Printf("Init file error , %s\n", err)
-		return nil
+		return nil, &ConfigError{Err: fmt.Errorf("Init file error , %s", err)}
 	}
 
-	return fs
+	return fs, nil
+}
+
+func (launcher *DumpLauncher) SetHost(host string) *DumpLauncher {
+
+	launcher.Host = host
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetPassword(password string) *DumpLauncher {
+
+	launcher.Password = password
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetPath(path string) *DumpLauncher {
+
+	launcher.Path = path
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetDatabaseCount(databaseCount uint64) *DumpLauncher {
+
+	launcher.DatabaseCount = databaseCount
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetThreadCount(threadCount int) *DumpLauncher {
+
+	launcher.ThreadCount = threadCount
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetErrorPolicy(policy *ErrorPolicy) *DumpLauncher {
+
+	launcher.Policy = policy
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetRateLimits(limits *RateLimits) *DumpLauncher {
+
+	launcher.Limits = limits
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetThrottle(targetLatency, interval time.Duration) *DumpLauncher {
+
+	launcher.TargetLatency = targetLatency
+	launcher.ThrottleInterval = interval
+	return launcher
 }
 
-func Dump(host, password, path string, databaseCount uint64, threadCount int) {
+func (launcher *DumpLauncher) SetChunker(chunker *Chunker) *DumpLauncher {
+
+	launcher.Chunker = chunker
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetTransfer(transfer string) *DumpLauncher {
+
+	launcher.Transfer = transfer
+	return launcher
+}
+
+func (launcher *DumpLauncher) SetFormat(format string) *DumpLauncher {
+
+	launcher.Format = format
+	return launcher
+}
+
+// SetVisitor makes the launcher hand every key to visitor instead of dumping
+// it, no dump file is written then.
+func (launcher *DumpLauncher) SetVisitor(visitor KeyVisitor) *DumpLauncher {
+
+	launcher.Visitor = visitor
+	return launcher
+}
+
+// SetFrequency makes the visited keys carry their LFU access counter, read
+// only when the maxmemory-policy of the redis is an LFU one, their idle time
+// is read otherwise.
+func (launcher *DumpLauncher) SetFrequency(isFrequency bool) *DumpLauncher {
+
+	launcher.IsFrequency = isFrequency
+	return launcher
+}
+
+// SetBaseline makes the dump incremental, only the keys changed since the
+// baseline are written.
+func (launcher *DumpLauncher) SetBaseline(baseline *Baseline) *DumpLauncher {
+
+	launcher.Baseline = baseline
+	return launcher
+}
+
+func (launcher *DumpLauncher) Launch(ctx context.Context) (result Result, err error) {
+
+	if launcher.DatabaseCount == 0 {
+		launcher.DatabaseCount, err = getDatabaseCount(launcher.Host, launcher.Password)
+		if err != nil {
+			return
+		}
+	}
+
+	if launcher.IsFrequency {
+		launcher.IsFrequency = isLFU(launcher.Host, launcher.Password)
+	}
 
-	if databaseCount == 0 {
-		databaseCount = getDatabaseCount(host, password)
+	throttle, err := newThrottle(launcher.Host, launcher.Password, launcher.TargetLatency, launcher.ThrottleInterval, launcher.ThreadCount)
+	if err != nil {
+		return
 	}
+	defer throttle.Close()
+
+	throttleCtx, stopThrottle := context.WithCancel(ctx)
+	defer stopThrottle()
+	go throttle.Run(throttleCtx)
+
+	var stream *os.File
+	if launcher.Visitor == nil {
 
-	stream := newStream(path)
-	defer stream.Close()
+		if launcher.Baseline != nil {
+
+			if err = checkOutput(launcher.Path, launcher.Baseline.Paths...); err != nil {
+				return
+			}
+			defer launcher.Baseline.PrintReport()
+		}
+
+		stream, err = newStream(launcher.Path)
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+	}
 
 	var currentDatabase uint64
-	for currentDatabase = 0; currentDatabase < databaseCount; currentDatabase++ {
+	for currentDatabase = 0; currentDatabase < launcher.DatabaseCount; currentDatabase++ {
+
+		client := createNewClient(launcher.Host, launcher.Password, int(currentDatabase), launcher.ThreadCount)
+		if err = ping(client, launcher.Host); err != nil {
+
+			client.Close()
+			return
+		}
 
 		dumper := &Dumper{
-			Client:      createNewClient(host, password, int(currentDatabase), threadCount),
-			Host:        host,
-			Password:    password,
+			Client:      client,
+			Host:        launcher.Host,
+			Password:    launcher.Password,
 			DatabaseId:  currentDatabase,
 			Stream:      stream,
-			ThreadCount: threadCount,
+			ThreadCount: launcher.ThreadCount,
+			Policy:      launcher.Policy,
+			Limits:      launcher.Limits,
+			Throttle:    throttle,
+			Chunker:     launcher.Chunker,
+			Transfer:    launcher.Transfer,
+			Format:      launcher.Format,
+			Visitor:     launcher.Visitor,
+			IsFrequency: launcher.IsFrequency,
+			Baseline:    launcher.Baseline,
+		}
+		err = dumper.Dump(ctx)
+		result.Merge(dumper.Result())
+
+		if err != nil {
+
+			err = fmt.Errorf("DB %d dump aborted, %w", currentDatabase, err)
+			return
 		}
-		dumper.Dump()
 	}
+
+	return
 }
 
 func createNewClient(host, password string, db, poolSize int) *redis.Client {
@@ -271,8 +598,7 @@ func createNewClient(host, password string, db, poolSize int) *redis.Client {
 	})
 }
 
-func getDatabaseCount(host, password string) uint64 {
-	var databaseCount uint64
+func getDatabaseCount(host, password string) (databaseCount uint64, err error) {
 
 	client := redis.NewClient(&redis.Options{
 		Addr:     host,
@@ -282,25 +608,57 @@ func getDatabaseCount(host, password string) uint64 {
 
 	defer client.Close()
 
+	if err = ping(client, host); err != nil {
+		return
+	}
+
 	databases, err := client.ConfigGet("databases").Result()
 	if err != nil {
-		log.Printf("Database config read error, %s\n", err)
-		return 0
+
+		err = &ConfigError{Err: fmt.Errorf("Database config read error, please specify -database-count, %s", err)}
+		return
 	}
 
 	if len(databases) == 2 {
 		databaseCount, err = strconv.ParseUint(fmt.Sprint(databases[1]), 10, 64)
 		if err != nil {
 
-			log.Printf("Read database count error: %s\n", err)
-			return 0
+			err = &ConfigError{Err: fmt.Errorf("Read database count error: %s", err)}
+			return
 		}
 	}
 
 	if databaseCount <= 0 {
 
-		log.Printf("Database count read failure\n")
-		return 0
+		err = &ConfigError{Err: fmt.Errorf("Database count read failure")}
+		return
+	}
+	return
+}
+
+// isLFU tells whether the maxmemory-policy of the redis is an LFU one, the
+// only policy keeping the access frequency OBJECT FREQ reads.
+func isLFU(host, password string) bool {
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     host,
+		Password: password,
+	})
+
+	defer client.Close()
+
+	policy, err := client.ConfigGet("maxmemory-policy").Result()
+	if err != nil || len(policy) != 2 {
+
+		log.Printf("Read maxmemory-policy error, the access frequency of the keys is unknown, %v\n", err)
+		return false
 	}
-	return databaseCount
+
+	if !strings.HasSuffix(fmt.Sprint(policy[1]), "-lfu") {
+
+		log.Printf("maxmemory-policy is %s, not allkeys-lfu or volatile-lfu: the access frequency of the keys is unknown, their idle time is read instead\n", policy[1])
+		return false
+	}
+
+	return true
 }
@@ -0,0 +1,248 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+)
+
+// rdbTypes maps the type byte opening a DUMP payload to the key type and the
+// encoding it was serialized with.
+var rdbTypes = map[byte][2]string{
+	0:  {TypeString, "raw"},
+	1:  {TypeList, "linkedlist"},
+	2:  {TypeSet, "hashtable"},
+	3:  {TypeZSet, "skiplist"},
+	4:  {TypeHash, "hashtable"},
+	5:  {TypeZSet, "skiplist"},
+	6:  {"module", ""},
+	7:  {"module", ""},
+	9:  {TypeHash, "zipmap"},
+	10: {TypeList, "ziplist"},
+	11: {TypeSet, "intset"},
+	12: {TypeZSet, "ziplist"},
+	13: {TypeHash, "ziplist"},
+	14: {TypeList, "quicklist"},
+	15: {TypeStream, "stream"},
+	16: {TypeHash, "listpack"},
+	17: {TypeZSet, "listpack"},
+	18: {TypeList, "quicklist"},
+	19: {TypeStream, "stream"},
+	20: {TypeSet, "listpack"},
+	21: {TypeStream, "stream"},
+	22: {TypeHash, "hashtable"},
+	23: {TypeHash, "listpack"},
+	24: {TypeHash, "hashtable"},
+	25: {TypeHash, "listpack"},
+}
+
+// PayloadType decodes the key type and encoding of a DUMP payload from its
+// first byte, "" for a payload too short or of an unknown type.
+func PayloadType(payload string) (keyType, encoding string) {
+
+	// type byte, value, then 2 bytes of RDB version and 8 bytes of CRC64
+	if len(payload) < 11 {
+		return "", ""
+	}
+
+	rdbType, isExist := rdbTypes[payload[0]]
+	if !isExist {
+		return "", ""
+	}
+
+	return rdbType[0], rdbType[1]
+}
+
+// ReadDumpFile calls read with every record of a JSON lines dump file, in
+// file order, until read returns an error.
+func ReadDumpFile(ctx context.Context, path string, read func(record *Record) error) error {
+
+	fp, err := os.Open(path)
+	if err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("Open data file error, %s", err)}
+	}
+	defer fp.Close()
+
+	isRESP, err := isRESPFile(fp)
+	if err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("Read data file error, %s", err)}
+	}
+	if isRESP {
+
+		return &ConfigError{Err: fmt.Errorf("%s is a RESP file, only JSON dump files can be read", path)}
+	}
+
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordLength)
+	for line := 1; scanner.Scan(); line++ {
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record, err := UnmarshalRecord(scanner.Text())
+		if err != nil {
+
+			return &ConfigError{Err: fmt.Errorf("%s line %d, %s", path, line, err)}
+		}
+
+		if err = read(record); err != nil {
+			return err
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("Read data file error, %s", err)}
+	}
+
+	return nil
+}
+
+// VisitDumpFile calls visitor with every key of a dump file, the parts of a
+// big key being gathered into one KeyInfo and deletions ignored. Without a
+// redis to ask, MemoryUsage is the serialized size of the value, ElementCount
+// is -1 for a DUMP payload and Frequency is unknown.
+func VisitDumpFile(ctx context.Context, path string, visitor KeyVisitor) error {
+
+	pending := make(map[string]*KeyInfo)
+	return ReadDumpFile(ctx, path, func(record *Record) error {
+
+		if record.Deleted {
+			return nil
+		}
+
+		id := fmt.Sprintf("%d:%s", record.DatabaseId, record.Key)
+		info, isExist := pending[id]
+		if !isExist {
+
+			info = &KeyInfo{DatabaseId: record.DatabaseId, Key: record.Key, Type: record.Type, Frequency: -1, IdleTime: -1}
+			if record.Type == "" {
+
+				info.Type, info.Encoding = PayloadType(record.Value)
+				info.ElementCount = -1
+			}
+		}
+
+		info.TTL = record.TTL
+		info.MemoryUsage += int64(len(record.Value))
+		if record.Type != "" {
+
+			count, err := logicalCount(record.Type, record.Value)
+			if err != nil {
+
+				return &ConfigError{Err: fmt.Errorf("Decode key \"%s\" error, %s", record.Key, err)}
+			}
+			info.ElementCount += count
+		}
+
+		if record.More {
+
+			pending[id] = info
+			return nil
+		}
+
+		delete(pending, id)
+		return visitor.Visit(info)
+	})
+}
+
+// logicalCount is the number of elements of a logical value, its length in
+// bytes for a string.
+func logicalCount(keyType, value string) (int64, error) {
+
+	data, err := decodeLogical(keyType, value)
+	if err != nil {
+		return 0, err
+	}
+
+	switch data := data.(type) {
+	case string:
+		return int64(len(data)), nil
+	case map[string]string:
+		return int64(len(data)), nil
+	case []string:
+		return int64(len(data)), nil
+	case map[string]Score:
+		return int64(len(data)), nil
+	case []StreamEntry:
+		return int64(len(data)), nil
+	}
+
+	return 0, nil
+}
+
+// DumpFileWriter writes records to a JSON lines dump file.
+type DumpFileWriter struct {
+	Path   string
+	Count  uint64
+	Size   int64
+	stream *os.File
+	writer *bufio.Writer
+}
+
+func NewDumpFileWriter(path string) (*DumpFileWriter, error) {
+
+	stream, err := newStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DumpFileWriter{Path: path, stream: stream, writer: bufio.NewWriterSize(stream, 64*1024)}, nil
+}
+
+func (w *DumpFileWriter) Write(record *Record) error {
+
+	data, err := record.Marshal()
+	if err != nil {
+		return err
+	}
+
+	n, err := w.writer.Write(append(data, '\n'))
+	w.Size += int64(n)
+	if err != nil {
+
+		return fmt.Errorf("Write file %s error, %s", w.Path, err)
+	}
+
+	w.Count++
+	return nil
+}
+
+func (w *DumpFileWriter) Close() error {
+
+	err := w.writer.Flush()
+	if closeErr := w.stream.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// checkOutput refuses to write over an input file, which would be truncated
+// before being read.
+func checkOutput(output string, inputs ...string) error {
+
+	outputInfo, err := os.Stat(output)
+	if err != nil {
+		return nil
+	}
+
+	for _, input := range inputs {
+
+		inputInfo, err := os.Stat(input)
+		if err == nil && os.SameFile(inputInfo, outputInfo) {
+
+			return &ConfigError{Err: fmt.Errorf("output %s is also an input", output)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tempDir creates a directory for the files of a test, removed by the
+// returned function.
+func tempDir(t *testing.T) (string, func()) {
+
+	dir, err := ioutil.TempDir("", "redis-transmission")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writeDumpFile(t *testing.T, path string, records ...*Record) {
+
+	writer, err := NewDumpFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range records {
+
+		if err = writer.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readDumpFile(t *testing.T, path string) []*Record {
+
+	var records []*Record
+	err := ReadDumpFile(context.Background(), path, func(record *Record) error {
+
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+// logicalRecord is a record of a logical value, encoded as in a dump file.
+func logicalRecord(dbId uint64, key string, data interface{}, ttl int64) *Record {
+
+	keyType := TypeString
+	switch data.(type) {
+	case map[string]string:
+		keyType = TypeHash
+	case []string:
+		keyType = TypeList
+	case map[string]Score:
+		keyType = TypeZSet
+	case []StreamEntry:
+		keyType = TypeStream
+	}
+
+	value, err := encodeLogical(data)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Record{DatabaseId: dbId, Key: key, Type: keyType, Value: value, TTL: ttl}
+}
+
+// payloadRecord is a record of a DUMP payload.
+func payloadRecord(dbId uint64, key, keyType string, data interface{}, ttl int64) *Record {
+
+	payload, err := EncodePayload(keyType, data)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Record{DatabaseId: dbId, Key: key, Value: payload, TTL: ttl}
+}
+
+// keyspaceOf loads dump files one after the other, as a chained restore does.
+func keyspaceOf(t *testing.T, now time.Time, paths ...string) *Keyspace {
+
+	keyspace := NewKeyspace()
+	for _, path := range paths {
+
+		for _, record := range readDumpFile(t, path) {
+			assert.NoError(t, keyspace.Load(record, now))
+		}
+	}
+
+	return keyspace
+}
@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+const (
+	OnErrorAbort = "abort"
+	OnErrorSkip  = "skip"
+	OnErrorRetry = "retry"
+)
+
+type ErrorPolicy struct {
+	OnError    string
+	MaxErrors  uint64
+	RetryTimes int
+	DeadLetter *DeadLetter
+	Backoff    *lib.Backoff
+	errorCount atomic.Uint64
+}
+
+type DeadLetter struct {
+	Path   string
+	Count  atomic.Uint64
+	lock   sync.Mutex
+	stream *os.File
+}
+
+func NewErrorPolicy(onError string, maxErrors uint64, retryTimes int, deadLetterPath string) (*ErrorPolicy, error) {
+
+	switch onError {
+	case OnErrorAbort, OnErrorSkip, OnErrorRetry:
+	default:
+		return nil, &ConfigError{Err: fmt.Errorf("unknown on-error policy %q, use abort, skip or retry", onError)}
+	}
+
+	if onError == OnErrorRetry && retryTimes <= 0 {
+
+		return nil, &ConfigError{Err: fmt.Errorf("retry-times must be greater than 0 when on-error is retry")}
+	}
+
+	policy := &ErrorPolicy{
+		OnError:    onError,
+		MaxErrors:  maxErrors,
+		RetryTimes: retryTimes,
+	}
+
+	if deadLetterPath != "" {
+		policy.DeadLetter = &DeadLetter{Path: deadLetterPath}
+	}
+
+	return policy, nil
+}
+
+// Do runs op, running it again up to RetryTimes times when the policy is retry,
+// pausing between attempts as the backoff says. The commands of op retry their
+// transient errors on every run, see Retry, so attempts multiply.
+func (p *ErrorPolicy) Do(ctx context.Context, op func() error) (err error) {
+
+	err = op()
+	if p == nil || p.OnError != OnErrorRetry {
+		return
+	}
+
+	for attempt := 1; err != nil && attempt <= p.RetryTimes; attempt++ {
+
+		if p.Backoff != nil {
+
+			if sleepErr := lib.Sleep(ctx, p.Backoff.Delay(attempt)); sleepErr != nil {
+				return
+			}
+		}
+
+		log.Printf("Retry %d/%d after error, %s\n", attempt, p.RetryTimes, err)
+		err = op()
+	}
+
+	return
+}
+
+// Failed records a key that could not be transferred into the dead-letter file,
+// record may be nil when there is nothing to re-attempt. A non-nil error means
+// the run must stop.
+func (p *ErrorPolicy) Failed(record *Record, cause error) error {
+
+	if p == nil {
+		return cause
+	}
+
+	if p.DeadLetter != nil && record != nil {
+
+		if err := p.DeadLetter.Write(record, cause); err != nil {
+
+			log.Printf("Write dead-letter error, %s\n", err)
+		}
+	}
+
+	count := p.errorCount.Inc()
+	if p.OnError == OnErrorAbort {
+		return cause
+	}
+
+	if p.MaxErrors > 0 && count > p.MaxErrors {
+
+		return fmt.Errorf("too many errors (%d > max-errors %d), last error: %w", count, p.MaxErrors, cause)
+	}
+
+	return nil
+}
+
+func (p *ErrorPolicy) Close() {
+
+	if p == nil || p.DeadLetter == nil {
+		return
+	}
+
+	p.DeadLetter.Close()
+}
+
+func (dl *DeadLetter) Write(record *Record, cause error) (err error) {
+
+	failed := *record
+	if cause != nil {
+		failed.Error = cause.Error()
+	}
+
+	jsonBytes, err := failed.Marshal()
+	if err != nil {
+		return
+	}
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stream == nil {
+
+		dl.stream, err = os.Create(dl.Path)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = dl.stream.Write(append(jsonBytes, '\n'))
+	if err == nil {
+		dl.Count.Inc()
+	}
+
+	return
+}
+
+func (dl *DeadLetter) Close() {
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stream == nil {
+		return
+	}
+
+	dl.stream.Close()
+	dl.stream = nil
+	log.Printf("%d failed Record(s) written to dead-letter file %s.\n", dl.Count.Load(), dl.Path)
+}
@@ -0,0 +1,144 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewErrorPolicy(t *testing.T) {
+
+	cases := []struct {
+		onError    string
+		retryTimes int
+		isValid    bool
+	}{
+		{OnErrorAbort, 0, true},
+		{OnErrorSkip, 0, true},
+		{OnErrorRetry, 3, true},
+		{OnErrorRetry, 0, false},
+		{"ignore", 3, false},
+	}
+
+	for _, c := range cases {
+
+		policy, err := NewErrorPolicy(c.onError, 0, c.retryTimes, "")
+		if !c.isValid {
+
+			assert.IsType(t, &ConfigError{}, err, c.onError)
+			continue
+		}
+		assert.NoError(t, err, c.onError)
+		assert.Equal(t, c.onError, policy.OnError)
+		assert.Nil(t, policy.DeadLetter)
+	}
+
+	policy, err := NewErrorPolicy(OnErrorSkip, 0, 0, "failed.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "failed.json", policy.DeadLetter.Path)
+}
+
+func TestErrorPolicy_Do(t *testing.T) {
+
+	failure := errors.New("failure")
+	cases := []struct {
+		name     string
+		onError  string
+		failures int
+		calls    int
+		isFailed bool
+	}{
+		{"abort runs once", OnErrorAbort, 5, 1, true},
+		{"skip runs once", OnErrorSkip, 5, 1, true},
+		{"retry until it works", OnErrorRetry, 1, 2, false},
+		{"retry then give up", OnErrorRetry, 5, 3, true},
+		{"no failure", OnErrorRetry, 0, 1, false},
+	}
+
+	for _, c := range cases {
+
+		policy, err := NewErrorPolicy(c.onError, 0, 2, "")
+		if !assert.NoError(t, err, c.name) {
+			continue
+		}
+
+		calls := 0
+		err = policy.Do(context.Background(), func() error {
+
+			calls++
+			if calls <= c.failures {
+				return failure
+			}
+			return nil
+		})
+		assert.Equal(t, c.calls, calls, c.name)
+		assert.Equal(t, c.isFailed, err != nil, c.name)
+	}
+
+	var none *ErrorPolicy
+	assert.Equal(t, failure, none.Do(context.Background(), func() error { return failure }))
+}
+
+func TestErrorPolicy_Failed(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	failure := errors.New("failure")
+	cases := []struct {
+		onError   string
+		maxErrors uint64
+		stops     []bool
+	}{
+		{OnErrorAbort, 0, []bool{true, true}},
+		{OnErrorSkip, 0, []bool{false, false, false}},
+		{OnErrorSkip, 2, []bool{false, false, true}},
+		{OnErrorRetry, 1, []bool{false, true}},
+	}
+
+	for _, c := range cases {
+
+		policy, err := NewErrorPolicy(c.onError, c.maxErrors, 1, "")
+		if !assert.NoError(t, err, c.onError) {
+			continue
+		}
+
+		for i, stops := range c.stops {
+
+			err = policy.Failed(&Record{Key: "k"}, failure)
+			assert.Equal(t, stops, err != nil, "%s max %d, error %d", c.onError, c.maxErrors, i+1)
+			if err != nil {
+				assert.True(t, errors.Is(err, failure))
+			}
+		}
+	}
+
+	var none *ErrorPolicy
+	assert.Equal(t, failure, none.Failed(&Record{Key: "k"}, failure))
+
+	// the failed records go to the dead-letter file with their error
+	path := filepath.Join(dir, "failed.json")
+	policy, err := NewErrorPolicy(OnErrorSkip, 0, 0, path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, policy.Failed(payloadRecord(1, "a", TypeString, "1", 10), failure))
+	assert.NoError(t, policy.Failed(nil, failure))
+	assert.NoError(t, policy.Failed(&Record{Key: "b"}, failure))
+	policy.Close()
+	policy.Close()
+
+	assert.Equal(t, uint64(2), policy.DeadLetter.Count.Load())
+	records := readDumpFile(t, path)
+	if assert.Len(t, records, 2) {
+
+		assert.Equal(t, "a", records[0].Key)
+		assert.Equal(t, uint64(1), records[0].DatabaseId)
+		assert.Equal(t, "failure", records[0].Error)
+		assert.False(t, records[0].IsValueless())
+		assert.True(t, records[1].IsValueless())
+	}
+}
@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a redis for the tests holding its keys in a Keyspace: reads are
+// answered like serve does, writes are applied like a RESP replay. Every
+// command received is kept in Commands.
+type fakeRedis struct {
+	Addr     string
+	server   *Server
+	listener net.Listener
+	lock     sync.Mutex
+	config   map[string]string
+	info     string
+	fail     func(args []string) string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, records ...*Record) *fakeRedis {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		Addr:     listener.Addr().String(),
+		config:   map[string]string{"databases": "16", "maxmemory-policy": "noeviction"},
+		server:   &Server{keyspace: NewKeyspace(), keys: make(map[uint64][]string), DatabaseCount: defaultDatabaseCount},
+		listener: listener,
+	}
+
+	now := time.Now()
+	for _, record := range records {
+
+		if err = f.server.keyspace.Load(record, now); err != nil {
+			t.Fatal(err)
+		}
+		f.server.keys[record.DatabaseId] = f.server.keyspace.Keys(record.DatabaseId, now)
+	}
+
+	go func() {
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeRedis) Close() {
+
+	f.listener.Close()
+}
+
+// SetFail makes the commands fail returns an error for answer that error.
+func (f *fakeRedis) SetFail(fail func(args []string) string) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.fail = fail
+}
+
+// SetConfig sets a parameter answered by CONFIG GET.
+func (f *fakeRedis) SetConfig(name, value string) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.config[name] = value
+}
+
+// SetInfo sets the reply of INFO, whatever its section.
+func (f *fakeRedis) SetInfo(info string) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.info = info
+}
+
+// Commands returns the commands received, upper case and space separated,
+// which start with one of prefixes.
+func (f *fakeRedis) Commands(prefixes ...string) []string {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var commands []string
+	for _, command := range f.commands {
+
+		for _, prefix := range prefixes {
+
+			if strings.HasPrefix(command, prefix) {
+				commands = append(commands, command)
+				break
+			}
+		}
+	}
+
+	return commands
+}
+
+// Value returns the logical value of a key, nil when it does not exist.
+func (f *fakeRedis) Value(t *testing.T, dbId uint64, key string) interface{} {
+
+	f.server.lock.Lock()
+	defer f.server.lock.Unlock()
+
+	entry := f.server.keyspace.Lookup(dbId, key, time.Now())
+	if entry == nil {
+		return nil
+	}
+
+	value, err := entry.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+// Keys returns the keys of a database, sorted.
+func (f *fakeRedis) Keys(dbId uint64) []string {
+
+	f.server.lock.Lock()
+	defer f.server.lock.Unlock()
+
+	return f.server.keyspace.Keys(dbId, time.Now())
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+
+	defer conn.Close()
+
+	reader := NewRESPReader(conn)
+	writer := bufio.NewWriter(conn)
+	state := &session{}
+	for {
+
+		args, err := reader.ReadCommand()
+		if err != nil {
+			return
+		}
+
+		writeReply(writer, f.execute(state, args))
+		if reader.reader.Buffered() == 0 {
+
+			if err = writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (f *fakeRedis) execute(state *session, args []string) interface{} {
+
+	command := strings.ToUpper(args[0])
+	f.lock.Lock()
+	f.commands = append(f.commands, strings.ToUpper(strings.Join(args, " ")))
+	fail, info := f.fail, f.info
+	isLFU := strings.HasSuffix(f.config["maxmemory-policy"], "-lfu")
+	var config []interface{}
+	if len(args) == 3 && strings.EqualFold(args[1], "GET") {
+
+		if value, isExist := f.config[args[2]]; isExist {
+			config = []interface{}{args[2], value}
+		}
+	}
+	f.lock.Unlock()
+
+	if fail != nil {
+
+		if reply := fail(args); reply != "" {
+			return respError(reply)
+		}
+	}
+
+	switch command {
+	case "CONFIG":
+		if config == nil {
+			return []interface{}{}
+		}
+		return config
+
+	case "INFO":
+		if info != "" {
+			return info
+		}
+
+	case "MEMORY", "OBJECT":
+		return f.object(state, args, isLFU)
+	}
+
+	if !writeCommands[command] {
+		return f.server.execute(state, args)
+	}
+
+	f.server.lock.Lock()
+	defer f.server.lock.Unlock()
+
+	now := time.Now()
+	keyspace := f.server.keyspace
+	isExist := keyspace.Lookup(state.dbId, args[1], now) != nil
+	var reply interface{} = respStatus("OK")
+	switch command {
+	case "RESTORE":
+		isReplace := false
+		for _, option := range args[4:] {
+			isReplace = isReplace || strings.EqualFold(option, "REPLACE")
+		}
+		if isExist && !isReplace {
+			return respError("BUSYKEY Target key name already exists.")
+		}
+
+	case "DEL", "UNLINK":
+		var count int64
+		for _, key := range args[1:] {
+
+			if keyspace.Lookup(state.dbId, key, now) != nil {
+				count++
+			}
+		}
+		reply = count
+
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "PERSIST":
+		reply = int64(0)
+		if isExist {
+			reply = int64(1)
+		}
+
+	case "XADD":
+		reply = args[2]
+
+	case "HMSET", "SET":
+
+	default:
+		reply = int64(1)
+	}
+
+	keyspace.Apply([]string{"SELECT", strconv.FormatUint(state.dbId, 10)}, now)
+	if err := keyspace.Apply(args, now); err != nil {
+		return respError("ERR " + err.Error())
+	}
+	f.server.keys[state.dbId] = keyspace.Keys(state.dbId, now)
+
+	return reply
+}
+
+// object answers MEMORY USAGE with the length of the DUMP payload, OBJECT
+// ENCODING, FREQ and IDLETIME with fixed values, FREQ for an LFU
+// maxmemory-policy only and IDLETIME otherwise.
+func (f *fakeRedis) object(state *session, args []string, isLFU bool) interface{} {
+
+	if len(args) < 3 {
+		return errSyntax
+	}
+
+	f.server.lock.Lock()
+	defer f.server.lock.Unlock()
+
+	entry := f.server.keyspace.Lookup(state.dbId, args[2], time.Now())
+	if entry == nil {
+		return nil
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "USAGE":
+		payload, err := entry.DumpPayload()
+		if err != nil {
+			return respError("ERR " + err.Error())
+		}
+		return int64(len(payload))
+	case "ENCODING":
+		return "raw"
+	case "FREQ":
+		if !isLFU {
+			return respError("ERR An LFU maxmemory policy is not selected, access frequency not tracked.")
+		}
+		return int64(7)
+	case "IDLETIME":
+		if isLFU {
+			return respError("ERR An LFU maxmemory policy is selected, idle time not tracked.")
+		}
+		return int64(3)
+	}
+
+	return errSyntax
+}
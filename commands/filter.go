@@ -0,0 +1,248 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+const (
+	SplitByDB   = "db"
+	SplitByHash = "hash"
+	SplitBySize = "size"
+)
+
+// RecordFilter selects the records of a dump file. Empty criteria select
+// everything, a key without expiration has an infinite TTL: it passes MinTTL
+// but not MaxTTL.
+type RecordFilter struct {
+	Databases DatabaseSet
+	Pattern   string
+	Regexp    *regexp.Regexp
+	Types     map[string]bool
+	MinTTL    int64
+	MaxTTL    int64
+}
+
+func NewRecordFilter(databases, pattern, regex, types string, minTTL, maxTTL time.Duration) (*RecordFilter, error) {
+
+	databaseSet, err := ParseDatabaseSet(databases)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &RecordFilter{
+		Databases: databaseSet,
+		Pattern:   pattern,
+		MinTTL:    int64(minTTL / time.Second),
+		MaxTTL:    int64(maxTTL / time.Second),
+	}
+
+	if regex != "" {
+
+		filter.Regexp, err = regexp.Compile(regex)
+		if err != nil {
+
+			return nil, &ConfigError{Err: fmt.Errorf("invalid key regex %q, %s", regex, err)}
+		}
+	}
+
+	if strings.TrimSpace(types) != "" {
+
+		filter.Types = make(map[string]bool)
+		for _, keyType := range strings.Split(types, ",") {
+			filter.Types[strings.TrimSpace(keyType)] = true
+		}
+	}
+
+	return filter, nil
+}
+
+func (f *RecordFilter) Match(record *Record) bool {
+
+	if f == nil {
+		return true
+	}
+
+	if !f.Databases.Contains(record.DatabaseId) {
+		return false
+	}
+
+	if f.Pattern != "" && !lib.MatchGlob(f.Pattern, record.Key) {
+		return false
+	}
+
+	if f.Regexp != nil && !f.Regexp.MatchString(record.Key) {
+		return false
+	}
+
+	if f.Types != nil {
+
+		keyType := record.Type
+		if keyType == "" {
+			keyType, _ = PayloadType(record.Value)
+		}
+
+		if !f.Types[keyType] {
+			return false
+		}
+	}
+
+	isPersistent := record.TTL <= 0
+	if f.MinTTL > 0 && !isPersistent && record.TTL < f.MinTTL {
+		return false
+	}
+
+	if f.MaxTTL > 0 && (isPersistent || record.TTL > f.MaxTTL) {
+		return false
+	}
+
+	return true
+}
+
+// Filter copies the records of the input dump file selected by filter to
+// output, Succeeded counting the keys copied and Skipped the others.
+func Filter(ctx context.Context, input, output string, filter *RecordFilter) (result Result, err error) {
+
+	if err = checkOutput(output, input); err != nil {
+		return
+	}
+
+	writer, err := NewDumpFileWriter(output)
+	if err != nil {
+		return
+	}
+
+	err = ReadDumpFile(ctx, input, func(record *Record) error {
+
+		if !filter.Match(record) {
+
+			if !record.More {
+				result.Skipped++
+			}
+			return nil
+		}
+
+		if !record.More {
+			result.Succeeded++
+		}
+		return writer.Write(record)
+	})
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	return
+}
+
+// Splitter shards a dump file into several ones for a parallel restore: one
+// per database, Count by key hash, or new files of about MaxBytes each. The
+// parts of a big key always go to the same file.
+type Splitter struct {
+	Input    string
+	Output   string
+	By       string
+	Count    int
+	MaxBytes int64
+	writers  map[string]*DumpFileWriter
+	names    []string
+}
+
+// Split returns the written files in creation order.
+func (s *Splitter) Split(ctx context.Context) (paths []string, err error) {
+
+	switch {
+	case s.By == SplitByHash && s.Count <= 0:
+		return nil, &ConfigError{Err: fmt.Errorf("split by hash needs a count of files")}
+	case s.By == SplitBySize && s.MaxBytes <= 0:
+		return nil, &ConfigError{Err: fmt.Errorf("split by size needs a file size")}
+	case s.By != SplitByDB && s.By != SplitByHash && s.By != SplitBySize:
+		return nil, &ConfigError{Err: fmt.Errorf("unknown split %q, use db, hash or size", s.By)}
+	}
+
+	s.writers = make(map[string]*DumpFileWriter)
+	var (
+		current = 0
+		open    = make(map[string]bool)
+	)
+
+	err = ReadDumpFile(ctx, s.Input, func(record *Record) error {
+
+		var name string
+		switch s.By {
+		case SplitByDB:
+			name = "db" + strconv.FormatUint(record.DatabaseId, 10)
+
+		case SplitByHash:
+			hash := fnv.New32a()
+			hash.Write([]byte(record.Key))
+			name = strconv.Itoa(int(hash.Sum32() % uint32(s.Count)))
+
+		case SplitBySize:
+			// roll over only between keys, never in the middle of a big key
+			if writer, isExist := s.writers[strconv.Itoa(current)]; isExist && writer.Size >= s.MaxBytes && len(open) == 0 {
+				current++
+			}
+			name = strconv.Itoa(current)
+
+			id := fmt.Sprintf("%d:%s", record.DatabaseId, record.Key)
+			if record.More {
+				open[id] = true
+			} else {
+				delete(open, id)
+			}
+		}
+
+		writer, err := s.writer(name)
+		if err != nil {
+			return err
+		}
+
+		return writer.Write(record)
+	})
+
+	for _, name := range s.names {
+
+		writer := s.writers[name]
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		log.Printf("%s, %d record(s)\n", writer.Path, writer.Count)
+		paths = append(paths, writer.Path)
+	}
+
+	return paths, err
+}
+
+func (s *Splitter) writer(name string) (*DumpFileWriter, error) {
+
+	if writer, isExist := s.writers[name]; isExist {
+		return writer, nil
+	}
+
+	writer, err := NewDumpFileWriter(splitPath(s.Output, name))
+	if err != nil {
+		return nil, err
+	}
+
+	s.writers[name] = writer
+	s.names = append(s.names, name)
+	return writer, nil
+}
+
+// splitPath inserts name before the extension, dump.json becoming
+// dump.db0.json.
+func splitPath(path, name string) string {
+
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
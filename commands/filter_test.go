@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordFilter_Match(t *testing.T) {
+
+	hash := payloadRecord(1, "user:1", TypeHash, map[string]string{"f": "v"}, 60)
+	cases := []struct {
+		name      string
+		databases string
+		pattern   string
+		regex     string
+		types     string
+		minTTL    time.Duration
+		maxTTL    time.Duration
+		record    *Record
+		isMatch   bool
+	}{
+		{"everything", "", "", "", "", 0, 0, hash, true},
+		{"database", "0,1", "", "", "", 0, 0, hash, true},
+		{"other database", "0", "", "", "", 0, 0, hash, false},
+		{"pattern", "", "user:*", "", "", 0, 0, hash, true},
+		{"other pattern", "", "session:*", "", "", 0, 0, hash, false},
+		{"regex", "", "", `^user:\d+$`, "", 0, 0, hash, true},
+		{"other regex", "", "", `^user:[a-z]+$`, "", 0, 0, hash, false},
+		{"payload type", "", "", "", "string, hash", 0, 0, hash, true},
+		{"other payload type", "", "", "", "string", 0, 0, hash, false},
+		{"logical type", "", "", "", "list", 0, 0, logicalRecord(0, "l", []string{"a"}, -1), true},
+		{"min TTL", "", "", "", "", time.Minute, 0, hash, true},
+		{"below min TTL", "", "", "", "", time.Hour, 0, hash, false},
+		{"persistent above min TTL", "", "", "", "", time.Hour, 0, logicalRecord(0, "k", "v", -1), true},
+		{"max TTL", "", "", "", "", 0, time.Minute, hash, true},
+		{"above max TTL", "", "", "", "", 0, time.Second, hash, false},
+		{"persistent above max TTL", "", "", "", "", 0, time.Hour, logicalRecord(0, "k", "v", -1), false},
+		{"all criteria", "1", "user:*", `1$`, "hash", time.Second, time.Hour, hash, true},
+	}
+
+	for _, c := range cases {
+
+		filter, err := NewRecordFilter(c.databases, c.pattern, c.regex, c.types, c.minTTL, c.maxTTL)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.isMatch, filter.Match(c.record), c.name)
+	}
+
+	_, err := NewRecordFilter("", "", "(", "", 0, 0)
+	assert.Error(t, err)
+	_, err = NewRecordFilter("x", "", "", "", 0, 0)
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	input := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, input,
+		logicalRecord(0, "user:1", "1", -1),
+		logicalRecord(0, "session:1", "1", -1),
+		&Record{Key: "user:big", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "user:big", Type: TypeList, Value: `["b"]`, TTL: -1, Part: 2},
+		&Record{Key: "session:big", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "session:big", Type: TypeList, Value: `["b"]`, TTL: -1, Part: 2},
+	)
+
+	filter, _ := NewRecordFilter("", "user:*", "", "", 0, 0)
+	output := filepath.Join(dir, "users.json")
+	result, err := Filter(context.Background(), input, output, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Succeeded: 2, Skipped: 2}, result)
+
+	var keys []string
+	for _, record := range readDumpFile(t, output) {
+		keys = append(keys, record.Key)
+	}
+	assert.Equal(t, []string{"user:1", "user:big", "user:big"}, keys)
+
+	_, err = Filter(context.Background(), input, input, filter)
+	assert.Error(t, err)
+}
+
+func TestSplitter_Split(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	input := filepath.Join(dir, "dump.json")
+	var records []*Record
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		records = append(records, logicalRecord(0, key, key, -1))
+	}
+	records = append(records,
+		&Record{DatabaseId: 2, Key: "big", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{DatabaseId: 2, Key: "big", Type: TypeList, Value: `["b"]`, TTL: -1, Part: 2},
+	)
+	writeDumpFile(t, input, records...)
+
+	output := filepath.Join(dir, "split.json")
+	cases := []struct {
+		splitter *Splitter
+		names    []string
+	}{
+		{&Splitter{By: SplitByDB}, []string{"db0", "db2"}},
+		{&Splitter{By: SplitByHash, Count: 3}, nil},
+		{&Splitter{By: SplitBySize, MaxBytes: 1}, []string{"0", "1", "2", "3", "4", "5", "6"}},
+	}
+
+	for _, c := range cases {
+
+		c.splitter.Input, c.splitter.Output = input, output
+		paths, err := c.splitter.Split(context.Background())
+		if !assert.NoError(t, err, c.splitter.By) {
+			continue
+		}
+
+		if c.names != nil {
+
+			var names []string
+			for _, name := range c.names {
+				names = append(names, splitPath(output, name))
+			}
+			assert.Equal(t, names, paths, c.splitter.By)
+		} else {
+			assert.True(t, len(paths) <= c.splitter.Count, c.splitter.By)
+		}
+
+		// every record is written once, the parts of a key to the same file
+		files := make(map[string]string)
+		var count int
+		for _, path := range paths {
+
+			for _, record := range readDumpFile(t, path) {
+
+				count++
+				if previous, isExist := files[record.Key]; isExist {
+					assert.Equal(t, previous, path, "%s %s", c.splitter.By, record.Key)
+				}
+				files[record.Key] = path
+			}
+		}
+		assert.Equal(t, len(records), count, c.splitter.By)
+	}
+
+	for _, splitter := range []*Splitter{{By: SplitByHash}, {By: SplitBySize}, {By: "type"}} {
+
+		splitter.Input, splitter.Output = input, output
+		_, err := splitter.Split(context.Background())
+		assert.Error(t, err, splitter.By)
+	}
+
+	assert.Equal(t, "/tmp/dump.db0.json", splitPath("/tmp/dump.json", "db0"))
+	assert.Equal(t, "dump.1", splitPath("dump", "1"))
+}
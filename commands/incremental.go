@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"sort"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// newPayloadHash starts the hash of a key value, fed with the values of all
+// its parts in order. Its hex sum is the Hash of the last record of the key.
+func newPayloadHash(keyType string) hash.Hash {
+
+	payloadHash := sha1.New()
+	payloadHash.Write([]byte(keyType))
+	return payloadHash
+}
+
+func payloadHash(keyType, value string) string {
+
+	payloadHash := newPayloadHash(keyType)
+	payloadHash.Write([]byte(value))
+	return hex.EncodeToString(payloadHash.Sum(nil))
+}
+
+// baselineEntry is the payload hash and TTL of a key in the baseline.
+type baselineEntry struct {
+	Hash string
+	TTL  int64
+}
+
+// Baseline is the state of a previous dump: the payload hash and TTL of every
+// key of a base dump file updated by its incrementals in order. An
+// incremental dump writes only the keys whose hash changed or whose TTL
+// changed by more than TTLTolerance seconds, as the Differ does, and a
+// Deleted record for the keys of the baseline which are gone.
+type Baseline struct {
+	Paths        []string
+	TTLTolerance int64
+	Unchanged    atomic.Uint64
+	Removed      atomic.Uint64
+	lock         sync.Mutex
+	entries      map[string]baselineEntry
+	keys         map[uint64]map[string]bool
+}
+
+// LoadBaseline reads a base dump file then its incrementals, a key without
+// hash (dumped by an older version or in chunks) always counts as changed.
+func LoadBaseline(ctx context.Context, paths []string) (*Baseline, error) {
+
+	baseline := &Baseline{Paths: paths, entries: make(map[string]baselineEntry), keys: make(map[uint64]map[string]bool)}
+	for _, path := range paths {
+
+		err := ReadDumpFile(ctx, path, func(record *Record) error {
+
+			keys, isExist := baseline.keys[record.DatabaseId]
+			if !isExist {
+
+				keys = make(map[string]bool)
+				baseline.keys[record.DatabaseId] = keys
+			}
+
+			id := fmt.Sprintf("%d:%s", record.DatabaseId, record.Key)
+			switch {
+			case record.Deleted:
+				delete(baseline.entries, id)
+				delete(keys, record.Key)
+			case !record.More:
+				baseline.entries[id] = baselineEntry{Hash: record.Hash, TTL: record.TTL}
+				keys[record.Key] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return baseline, nil
+}
+
+// See marks a key as still existing, it is not deleted by the incremental.
+func (b *Baseline) See(dbId uint64, key string) {
+
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.keys[dbId], key)
+}
+
+// IsChanged tells whether a key has to be written, true without baseline.
+func (b *Baseline) IsChanged(dbId uint64, key, hash string, ttl int64) bool {
+
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	previous, isExist := b.entries[fmt.Sprintf("%d:%s", dbId, key)]
+	if isExist && previous.Hash != "" && previous.Hash == hash && !isTTLChanged(previous.TTL, ttl, b.TTLTolerance) {
+
+		b.Unchanged.Inc()
+		return false
+	}
+
+	return true
+}
+
+// RemovedKeys returns the keys of the baseline database not seen since, in
+// key order.
+func (b *Baseline) RemovedKeys(dbId uint64) []string {
+
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var keys []string
+	for key := range b.keys[dbId] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b.Removed.Add(uint64(len(keys)))
+	return keys
+}
+
+func (b *Baseline) PrintReport() {
+
+	if b == nil {
+		return
+	}
+
+	log.Printf("Incremental dump: %d unchanged key(s) left out, %d removed key(s).\n", b.Unchanged.Load(), b.Removed.Load())
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseline(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	hashed := func(record *Record, hash string) *Record {
+
+		record.Hash = hash
+		return record
+	}
+
+	base := filepath.Join(dir, "base.json")
+	writeDumpFile(t, base,
+		hashed(payloadRecord(0, "a", TypeString, "1", -1), "h1"),
+		payloadRecord(0, "no hash", TypeString, "1", -1),
+		hashed(payloadRecord(0, "deleted", TypeString, "1", 100), "h3"),
+		&Record{Key: "big", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "big", Type: TypeList, Value: `["b"]`, TTL: -1, Part: 2, Hash: "h4"},
+		hashed(payloadRecord(0, "expiring", TypeString, "1", 100), "h5"),
+		hashed(payloadRecord(1, "a", TypeString, "1", -1), "h6"),
+	)
+
+	incremental := filepath.Join(dir, "incremental.json")
+	writeDumpFile(t, incremental,
+		hashed(payloadRecord(0, "a", TypeString, "2", -1), "h2"),
+		&Record{Key: "deleted", Deleted: true},
+	)
+
+	baseline, err := LoadBaseline(context.Background(), []string{base, incremental})
+	if !assert.NoError(t, err) {
+		return
+	}
+	baseline.TTLTolerance = 5
+
+	cases := []struct {
+		name      string
+		dbId      uint64
+		key       string
+		hash      string
+		ttl       int64
+		isChanged bool
+	}{
+		{"updated by the incremental", 0, "a", "h2", -1, false},
+		{"hash of the base", 0, "a", "h1", -1, true},
+		{"expiring now", 0, "a", "h2", 100, true},
+		{"no hash in the baseline", 0, "no hash", "", -1, true},
+		{"deleted by the incremental", 0, "deleted", "h3", 100, true},
+		{"hash of the last part", 0, "big", "h4", -1, false},
+		{"ttl within tolerance", 0, "expiring", "h5", 96, false},
+		{"ttl beyond tolerance", 0, "expiring", "h5", 90, true},
+		{"persisted", 0, "expiring", "h5", -1, true},
+		{"other database", 1, "a", "h6", -1, false},
+		{"new key", 1, "new", "h7", -1, true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.isChanged, baseline.IsChanged(c.dbId, c.key, c.hash, c.ttl), c.name)
+	}
+	assert.Equal(t, uint64(4), baseline.Unchanged.Load())
+
+	baseline.See(0, "a")
+	baseline.See(0, "big")
+	baseline.See(0, "new")
+	assert.Equal(t, []string{"expiring", "no hash"}, baseline.RemovedKeys(0))
+	assert.Equal(t, []string{"a"}, baseline.RemovedKeys(1))
+	assert.Empty(t, baseline.RemovedKeys(2))
+	assert.Equal(t, uint64(3), baseline.Removed.Load())
+
+	var none *Baseline
+	none.See(0, "a")
+	assert.True(t, none.IsChanged(0, "a", "h1", -1))
+	assert.Empty(t, none.RemovedKeys(0))
+
+	_, err = LoadBaseline(context.Background(), []string{filepath.Join(dir, "missing.json")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,310 @@
+package commands
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+const (
+	InspectStats = "stats"
+	InspectKeys  = "keys"
+	InspectShow  = "show"
+)
+
+// DatabaseSet selects databases by number, e.g. "0,3", nil selects them all.
+type DatabaseSet map[uint64]bool
+
+func ParseDatabaseSet(setString string) (DatabaseSet, error) {
+
+	if strings.TrimSpace(setString) == "" {
+		return nil, nil
+	}
+
+	set := make(DatabaseSet)
+	for _, part := range strings.Split(setString, ",") {
+
+		dbId, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+
+			return nil, &ConfigError{Err: fmt.Errorf("invalid database %q, %s", part, err)}
+		}
+		set[dbId] = true
+	}
+
+	return set, nil
+}
+
+func (s DatabaseSet) Contains(dbId uint64) bool {
+
+	return s == nil || s[dbId]
+}
+
+// keyFilter passes to next the keys of the selected databases matching the
+// glob pattern Match.
+type keyFilter struct {
+	Databases DatabaseSet
+	Match     string
+	next      KeyVisitor
+}
+
+func (f *keyFilter) Visit(info *KeyInfo) error {
+
+	if !f.Databases.Contains(info.DatabaseId) {
+		return nil
+	}
+
+	if f.Match != "" && !lib.MatchGlob(f.Match, info.Key) {
+		return nil
+	}
+
+	return f.next.Visit(info)
+}
+
+type DumpFileStats struct {
+	Path      string           `json:"path"`
+	FileSize  int64            `json:"file_size"`
+	Databases []*DatabaseStats `json:"databases"`
+	Total     DatabaseStats    `json:"total"`
+	TTL       []TTLBucket      `json:"ttl"`
+}
+
+// RecordInfo describes a key of a dump file, Values holding the value of each
+// of its parts as written in the file.
+type RecordInfo struct {
+	DatabaseId uint64            `json:"db"`
+	Key        string            `json:"key"`
+	Type       string            `json:"type"`
+	Encoding   string            `json:"encoding,omitempty"`
+	RDBVersion uint16            `json:"rdb_version,omitempty"`
+	Logical    bool              `json:"logical"`
+	TTL        int64             `json:"ttl"`
+	Size       int64             `json:"size"`
+	Values     []json.RawMessage `json:"values"`
+}
+
+// Inspector answers questions about a dump file without restoring it, the
+// keys being restricted to Databases and to the glob pattern Match.
+type Inspector struct {
+	Path      string
+	Databases DatabaseSet
+	Match     string
+	Key       string
+	Format    string
+	Out       io.Writer
+}
+
+// Inspect runs the stats, keys or show command.
+func (i *Inspector) Inspect(ctx context.Context, command string) error {
+
+	switch command {
+	case InspectStats:
+		return i.Stats(ctx)
+	case InspectKeys:
+		return i.Keys(ctx)
+	case InspectShow:
+		return i.Show(ctx, i.Key)
+	}
+
+	return &ConfigError{Err: fmt.Errorf("unknown inspect command %q, use stats, keys or show", command)}
+}
+
+// Stats writes the key count, size, expiring keys and types of every
+// database and the TTL distribution.
+func (i *Inspector) Stats(ctx context.Context) error {
+
+	fileInfo, err := os.Stat(i.Path)
+	if err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("Open data file error, %s", err)}
+	}
+
+	analyzer := NewAnalyzer(0, "", 0)
+	if err = VisitDumpFile(ctx, i.Path, i.filter(analyzer)); err != nil {
+		return err
+	}
+
+	report := analyzer.Report()
+	stats := &DumpFileStats{
+		Path:      i.Path,
+		FileSize:  fileInfo.Size(),
+		Databases: report.Databases,
+		Total:     report.Total,
+		TTL:       report.TTL,
+	}
+
+	if i.Format == ReportJSON {
+		return writeJSON(i.Out, stats)
+	}
+
+	tw := tabwriter.NewWriter(i.Out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "File %s, %s\n\n", stats.Path, formatBytes(stats.FileSize))
+	fmt.Fprintln(tw, "Databases")
+	fmt.Fprintln(tw, "  db\tkeys\texpiring\tsize\ttypes")
+	for _, db := range stats.Databases {
+		fmt.Fprintf(tw, "  %d\t%d\t%d\t%s\t%s\n", db.DatabaseId, db.Keys, db.Expiring, formatBytes(db.MemoryUsage), formatTypes(db.Types))
+	}
+	fmt.Fprintf(tw, "  total\t%d\t%d\t%s\t%s\n", stats.Total.Keys, stats.Total.Expiring, formatBytes(stats.Total.MemoryUsage), formatTypes(stats.Total.Types))
+
+	fmt.Fprintln(tw, "\nTTL distribution")
+	for _, bucket := range stats.TTL {
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", bucket.Range, bucket.Keys, percent(bucket.Keys, stats.Total.Keys))
+	}
+
+	return tw.Flush()
+}
+
+// Keys writes a line per key: database, key, type, TTL and size, or the same
+// as JSON lines.
+func (i *Inspector) Keys(ctx context.Context) error {
+
+	return VisitDumpFile(ctx, i.Path, i.filter(keyPrinter{Format: i.Format, Out: i.Out}))
+}
+
+type keyPrinter struct {
+	Format string
+	Out    io.Writer
+}
+
+func (p keyPrinter) Visit(info *KeyInfo) error {
+
+	if p.Format == ReportJSON {
+
+		jsonBytes, err := json.Marshal(&KeyStats{
+			DatabaseId:   info.DatabaseId,
+			Key:          info.Key,
+			Type:         info.Type,
+			TTL:          info.TTL,
+			MemoryUsage:  info.MemoryUsage,
+			ElementCount: info.ElementCount,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = p.Out.Write(append(jsonBytes, '\n'))
+		return err
+	}
+
+	_, err := fmt.Fprintf(p.Out, "%d\t%q\t%s\t%d\t%d\n", info.DatabaseId, info.Key, info.Type, info.TTL, info.MemoryUsage)
+	return err
+}
+
+// Show writes the records of key, one per selected database holding it.
+func (i *Inspector) Show(ctx context.Context, key string) error {
+
+	if key == "" {
+		return &ConfigError{Err: fmt.Errorf("inspect show needs a key")}
+	}
+
+	var infos []*RecordInfo
+	found := make(map[uint64]*RecordInfo)
+	err := ReadDumpFile(ctx, i.Path, func(record *Record) error {
+
+		if record.Key != key || !i.Databases.Contains(record.DatabaseId) {
+			return nil
+		}
+
+		info, isExist := found[record.DatabaseId]
+		if !isExist {
+
+			info = &RecordInfo{DatabaseId: record.DatabaseId, Key: record.Key, Type: record.Type, Logical: record.Type != ""}
+			if record.Type == "" {
+
+				info.Type, info.Encoding = PayloadType(record.Value)
+				info.RDBVersion = payloadVersion(record.Value)
+			}
+			found[record.DatabaseId] = info
+			infos = append(infos, info)
+		}
+
+		value, err := record.valueJSON()
+		if err != nil {
+			return err
+		}
+
+		info.TTL = record.TTL
+		info.Size += int64(len(record.Value))
+		info.Values = append(info.Values, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(infos) == 0 {
+		return fmt.Errorf("key \"%s\" not found in %s", key, i.Path)
+	}
+
+	if i.Format == ReportJSON {
+		return writeJSON(i.Out, infos)
+	}
+
+	for n, info := range infos {
+
+		if n > 0 {
+			fmt.Fprintln(i.Out)
+		}
+
+		tw := tabwriter.NewWriter(i.Out, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "db\t%d\n", info.DatabaseId)
+		fmt.Fprintf(tw, "key\t%q\n", info.Key)
+		fmt.Fprintf(tw, "type\t%s\n", info.Type)
+		if info.Logical {
+			fmt.Fprintf(tw, "format\tlogical\n")
+		} else {
+			fmt.Fprintf(tw, "format\tDUMP payload, encoding %s, RDB version %d\n", info.Encoding, info.RDBVersion)
+		}
+		fmt.Fprintf(tw, "ttl\t%d\n", info.TTL)
+		fmt.Fprintf(tw, "size\t%s\n", formatBytes(info.Size))
+		fmt.Fprintf(tw, "parts\t%d\n", len(info.Values))
+		for part, value := range info.Values {
+
+			if len(info.Values) == 1 {
+				fmt.Fprintf(tw, "value\t%s\n", value)
+			} else {
+				fmt.Fprintf(tw, "value %d\t%s\n", part+1, value)
+			}
+		}
+		if err = tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Inspector) filter(next KeyVisitor) KeyVisitor {
+
+	return &keyFilter{Databases: i.Databases, Match: i.Match, next: next}
+}
+
+// payloadVersion is the RDB version a DUMP payload was serialized with, 0
+// for a payload too short to hold it.
+func payloadVersion(payload string) uint16 {
+
+	if len(payload) < 10 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint16([]byte(payload[len(payload)-10 : len(payload)-8]))
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+
+	jsonBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(jsonBytes, '\n'))
+	return err
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspector_Show(t *testing.T) {
+
+	dir, clean := tempDir(t)
+	defer clean()
+
+	first := logicalRecord(1, "list", []string{"a", "b"}, -1)
+	first.Part, first.More = 1, true
+	last := logicalRecord(1, "list", []string{"c"}, -1)
+	last.Part = 2
+
+	path := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, path, payloadRecord(0, "payload", TypeString, "value", 30), first, last)
+
+	tests := []struct {
+		key  string
+		text string
+		json RecordInfo
+	}{
+		{
+			"payload",
+			"db      0\n" +
+				"key     \"payload\"\n" +
+				"type    string\n" +
+				"format  DUMP payload, encoding raw, RDB version 8\n" +
+				"ttl     30\n" +
+				"size    17B\n" +
+				"parts   1\n" +
+				"value   \"AAV2YWx1ZQgAONsth2izEVY=\"\n",
+			RecordInfo{DatabaseId: 0, Key: "payload", Type: TypeString, Encoding: "raw", RDBVersion: 8, TTL: 30, Size: 17,
+				Values: []json.RawMessage{json.RawMessage(`"AAV2YWx1ZQgAONsth2izEVY="`)}},
+		},
+		{
+			"list",
+			"db       1\n" +
+				"key      \"list\"\n" +
+				"type     list\n" +
+				"format   logical\n" +
+				"ttl      -1\n" +
+				"size     14B\n" +
+				"parts    2\n" +
+				"value 1  [\"a\",\"b\"]\n" +
+				"value 2  [\"c\"]\n",
+			RecordInfo{DatabaseId: 1, Key: "list", Type: TypeList, Logical: true, TTL: -1, Size: 14,
+				Values: []json.RawMessage{json.RawMessage(`["a","b"]`), json.RawMessage(`["c"]`)}},
+		},
+	}
+	for _, test := range tests {
+
+		t.Run(test.key, func(t *testing.T) {
+
+			var out bytes.Buffer
+			assert.NoError(t, (&Inspector{Path: path, Out: &out}).Show(context.Background(), test.key))
+			assert.Equal(t, test.text, out.String())
+
+			out.Reset()
+			assert.NoError(t, (&Inspector{Path: path, Format: ReportJSON, Out: &out}).Show(context.Background(), test.key))
+			var infos []RecordInfo
+			if assert.NoError(t, json.Unmarshal(out.Bytes(), &infos)) && assert.Len(t, infos, 1) {
+
+				// compact the values indented by the report
+				for n, value := range infos[0].Values {
+
+					var compact bytes.Buffer
+					assert.NoError(t, json.Compact(&compact, value))
+					infos[0].Values[n] = compact.Bytes()
+				}
+				assert.Equal(t, test.json, infos[0])
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	inspector := &Inspector{Path: path, Databases: DatabaseSet{0: true}, Out: &out}
+	assert.EqualError(t, inspector.Show(context.Background(), "list"), `key "list" not found in `+path)
+	assert.NoError(t, inspector.Keys(context.Background()))
+	assert.Equal(t, "0\t\"payload\"\tstring\t30\t17\n", out.String())
+}
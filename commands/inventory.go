@@ -0,0 +1,193 @@
+package commands
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// KeyInfo describes a key without its value. MemoryUsage is -1 when MEMORY
+// USAGE is not available (redis before 4), ElementCount is the length in
+// bytes for a string. Only one of Frequency and IdleTime is known, the other
+// one is -1.
+type KeyInfo struct {
+	DatabaseId   uint64
+	Key          string
+	Type         string
+	TTL          int64
+	Encoding     string
+	MemoryUsage  int64
+	ElementCount int64
+	Frequency    int64
+	IdleTime     int64
+}
+
+// KeyVisitor receives every key scanned by a Dumper instead of dumping it,
+// Visit is called from several workers at once.
+type KeyVisitor interface {
+	Visit(info *KeyInfo) error
+}
+
+// inspectKey returns nil for a key deleted since it was scanned. The LFU
+// access counter of the key is read with isFrequency, its idle time else:
+// redis keeps only one of them, depending on its maxmemory-policy.
+func inspectKey(ctx context.Context, client *redis.Client, dbId uint64, key string, isFrequency bool, policy *ErrorPolicy, limits *RateLimits) (info *KeyInfo, err error) {
+
+	info = &KeyInfo{DatabaseId: dbId, Key: key, Frequency: -1, IdleTime: -1}
+	err = policy.Retry(ctx, "TYPE", func() (err error) {
+		if err = limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		info.Type, err = client.Type(key).Result()
+		return
+	})
+	if err != nil || info.Type == "none" {
+		return nil, err
+	}
+
+	var ttl, encoding, memory, count, access redis.Cmder
+	err = policy.Retry(ctx, "INSPECT", func() (err error) {
+		if err = limits.Read(ctx, 5, 0); err != nil {
+			return
+		}
+		pipeline := client.Pipeline()
+		defer pipeline.Close()
+
+		ttl = pipeline.PTTL(key)
+		encoding = pipeline.ObjectEncoding(key)
+		memory = pipeline.MemoryUsage(key)
+		count = elementCountCommand(pipeline, key, info.Type)
+		if isFrequency {
+			access = pipeline.Do("OBJECT", "FREQ", key)
+		} else {
+			access = pipeline.Do("OBJECT", "IDLETIME", key)
+		}
+		// MEMORY USAGE is unknown before redis 4, its error is ignored
+		_, _ = pipeline.Exec()
+		for _, command := range []redis.Cmder{ttl, encoding, count} {
+			if err = command.Err(); err != nil {
+				return
+			}
+		}
+		return
+	})
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.TTL = ttlSeconds(ttl.(*redis.DurationCmd).Val())
+	info.Encoding = encoding.(*redis.StringCmd).Val()
+	info.ElementCount = count.(*redis.IntCmd).Val()
+	info.MemoryUsage = -1
+	if memory.Err() == nil {
+		info.MemoryUsage = memory.(*redis.IntCmd).Val()
+	}
+	if access.Err() == nil {
+
+		value, _ := access.(*redis.Cmd).Int64()
+		if isFrequency {
+			info.Frequency = value
+		} else {
+			info.IdleTime = value
+		}
+	}
+
+	return
+}
+
+func elementCountCommand(pipeline redis.Pipeliner, key, keyType string) *redis.IntCmd {
+
+	switch keyType {
+	case TypeHash:
+		return pipeline.HLen(key)
+	case TypeList:
+		return pipeline.LLen(key)
+	case TypeSet:
+		return pipeline.SCard(key)
+	case TypeZSet:
+		return pipeline.ZCard(key)
+	case TypeStream:
+		return pipeline.XLen(key)
+	}
+
+	return pipeline.StrLen(key)
+}
+
+// Inventory writes one CSV line per key. The frequency column is filled with
+// an LFU maxmemory-policy, the idle_time one otherwise.
+type Inventory struct {
+	Path   string
+	lock   sync.Mutex
+	stream *os.File
+	writer *csv.Writer
+}
+
+var inventoryHeader = []string{"db", "key", "type", "ttl", "encoding", "memory_usage", "element_count", "frequency", "idle_time"}
+
+func NewInventory(path string) (*Inventory, error) {
+
+	stream, err := newStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &Inventory{Path: path, stream: stream, writer: csv.NewWriter(stream)}
+	if err = inventory.writer.Write(inventoryHeader); err != nil {
+
+		stream.Close()
+		return nil, &ConfigError{Err: fmt.Errorf("Write inventory file error, %s", err)}
+	}
+
+	return inventory, nil
+}
+
+func (inv *Inventory) Visit(info *KeyInfo) error {
+
+	memory, frequency, idle := "", "", ""
+	if info.MemoryUsage >= 0 {
+		memory = strconv.FormatInt(info.MemoryUsage, 10)
+	}
+	if info.Frequency >= 0 {
+		frequency = strconv.FormatInt(info.Frequency, 10)
+	}
+	if info.IdleTime >= 0 {
+		idle = strconv.FormatInt(info.IdleTime, 10)
+	}
+
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+
+	return inv.writer.Write([]string{
+		strconv.FormatUint(info.DatabaseId, 10),
+		info.Key,
+		info.Type,
+		strconv.FormatInt(info.TTL, 10),
+		info.Encoding,
+		memory,
+		strconv.FormatInt(info.ElementCount, 10),
+		frequency,
+		idle,
+	})
+}
+
+func (inv *Inventory) Close() error {
+
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+
+	inv.writer.Flush()
+	err := inv.writer.Error()
+	if closeErr := inv.stream.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
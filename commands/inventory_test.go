@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInventory_Visit(t *testing.T) {
+
+	dir, clean := tempDir(t)
+	defer clean()
+
+	tests := []struct {
+		name   string
+		policy string
+		access []string
+	}{
+		{"idle time", "noeviction", []string{"", "3"}},
+		{"lfu frequency", "allkeys-lfu", []string{"7", ""}},
+	}
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			fake := newFakeRedis(t,
+				logicalRecord(0, "list", []string{"a", "b"}, -1),
+				logicalRecord(0, `quoted "key", with comma`, "value", 60),
+			)
+			defer fake.Close()
+			fake.SetConfig("maxmemory-policy", test.policy)
+
+			path := filepath.Join(dir, test.policy+".csv")
+			inventory, err := NewInventory(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			result, err := (&DumpLauncher{}).SetHost(fake.Addr).SetDatabaseCount(1).SetThreadCount(1).
+				SetVisitor(inventory).SetFrequency(true).Launch(context.Background())
+			assert.NoError(t, inventory.Close())
+			assert.NoError(t, err)
+			assert.Equal(t, Result{Succeeded: 2}, result)
+
+			stream, err := os.Open(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer stream.Close()
+
+			rows, err := csv.NewReader(stream).ReadAll()
+			if !assert.NoError(t, err) || !assert.Len(t, rows, 3) {
+				return
+			}
+			assert.Equal(t, inventoryHeader, rows[0])
+
+			byKey := map[string][]string{}
+			for _, row := range rows[1:] {
+				byKey[row[1]] = row
+			}
+			assert.Equal(t, append([]string{"0", "list", TypeList, "-1", "raw", byKey["list"][5], "2"}, test.access...), byKey["list"])
+
+			row := byKey[`quoted "key", with comma`]
+			if assert.NotNil(t, row) {
+
+				assert.Equal(t, TypeString, row[2])
+				assert.Contains(t, []string{"59", "60"}, row[3])
+				assert.NotEmpty(t, row[5])
+				assert.Equal(t, "5", row[6])
+				assert.Equal(t, test.access, row[7:])
+			}
+		})
+	}
+
+	// the key is quoted in the file, a missing memory usage is empty
+	path := filepath.Join(dir, "quoted.csv")
+	inventory, err := NewInventory(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, inventory.Visit(&KeyInfo{DatabaseId: 1, Key: "a,\"b\"\nc", Type: TypeSet, TTL: -1, Encoding: "intset", MemoryUsage: -1, ElementCount: 3, Frequency: -1, IdleTime: 10}))
+	assert.NoError(t, inventory.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "db,key,type,ttl,encoding,memory_usage,element_count,frequency,idle_time\n1,\"a,\"\"b\"\"\nc\",set,-1,intset,,3,,10\n", string(content))
+}
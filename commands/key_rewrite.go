@@ -0,0 +1,225 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// KeyRewriter renames keys on their way to the destination: the strip prefix
+// is removed first, then the regex rename is applied, then the prefix added.
+// Every destination key is remembered with the source key it comes from, so
+// two source keys landing on the same destination key are reported instead
+// of silently overwriting each other. A claim lasts until its source key is
+// deleted or the next synchronization round of its source database.
+type KeyRewriter struct {
+	AddPrefix   string
+	StripPrefix string
+	Pattern     *regexp.Regexp
+	Replacement string
+	Collisions  atomic.Uint64
+	lock        sync.Mutex
+	claims      map[uint64]map[string]sourceKey
+}
+
+type sourceKey struct {
+	DatabaseId uint64
+	Key        string
+}
+
+type KeyCollisionError struct {
+	DatabaseId       uint64
+	Destination      string
+	First            string
+	FirstDatabaseId  uint64
+	Second           string
+	SecondDatabaseId uint64
+}
+
+func (e *KeyCollisionError) Error() string {
+
+	return fmt.Sprintf("key collision in destination database %d: %q of db %d and %q of db %d both map to %q",
+		e.DatabaseId, e.First, e.FirstDatabaseId, e.Second, e.SecondDatabaseId, e.Destination)
+}
+
+// NewKeyRewriter returns nil when no rule is given. rename has the form
+// "pattern=>replacement", the replacement may use $1 style group references.
+func NewKeyRewriter(addPrefix, stripPrefix, rename string) (*KeyRewriter, error) {
+
+	if addPrefix == "" && stripPrefix == "" && rename == "" {
+		return nil, nil
+	}
+
+	rewriter := &KeyRewriter{
+		AddPrefix:   addPrefix,
+		StripPrefix: stripPrefix,
+		claims:      make(map[uint64]map[string]sourceKey),
+	}
+
+	if rename != "" {
+
+		pair := strings.SplitN(rename, "=>", 2)
+		if len(pair) != 2 {
+
+			return nil, &ConfigError{Err: fmt.Errorf("invalid key-rename %q, use pattern=>replacement", rename)}
+		}
+
+		pattern, err := regexp.Compile(pair[0])
+		if err != nil {
+
+			return nil, &ConfigError{Err: fmt.Errorf("invalid key-rename pattern %q, %s", pair[0], err)}
+		}
+
+		rewriter.Pattern = pattern
+		rewriter.Replacement = pair[1]
+	}
+
+	return rewriter, nil
+}
+
+// NewKeyClaims returns a KeyRewriter without rules, which only reports the
+// keys of several source databases landing on the same destination key when
+// a db-map merges them.
+func NewKeyClaims() *KeyRewriter {
+
+	return &KeyRewriter{claims: make(map[uint64]map[string]sourceKey)}
+}
+
+// HasRules tells whether keys are renamed, rather than only claimed.
+func (kr *KeyRewriter) HasRules() bool {
+
+	return kr != nil && (kr.AddPrefix != "" || kr.StripPrefix != "" || kr.Pattern != nil)
+}
+
+func (kr *KeyRewriter) Rewrite(key string) string {
+
+	if kr == nil {
+		return key
+	}
+
+	if kr.StripPrefix != "" {
+		key = strings.TrimPrefix(key, kr.StripPrefix)
+	}
+
+	if kr.Pattern != nil {
+		key = kr.Pattern.ReplaceAllString(key, kr.Replacement)
+	}
+
+	return kr.AddPrefix + key
+}
+
+// Claim rewrites key and reserves the result in the destination database,
+// it fails when another source key already took the same destination key.
+func (kr *KeyRewriter) Claim(sourceDbId, destinationDbId uint64, key string) (string, error) {
+
+	if kr == nil {
+		return key, nil
+	}
+
+	destination := kr.Rewrite(key)
+	source := sourceKey{DatabaseId: sourceDbId, Key: key}
+
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	claims, isExist := kr.claims[destinationDbId]
+	if !isExist {
+
+		claims = make(map[string]sourceKey)
+		kr.claims[destinationDbId] = claims
+	}
+
+	if owner, isExist := claims[destination]; isExist && owner != source {
+
+		kr.Collisions.Inc()
+		return destination, &KeyCollisionError{
+			DatabaseId:       destinationDbId,
+			Destination:      destination,
+			First:            owner.Key,
+			FirstDatabaseId:  owner.DatabaseId,
+			Second:           key,
+			SecondDatabaseId: sourceDbId,
+		}
+	}
+
+	claims[destination] = source
+	return destination, nil
+}
+
+// Release rewrites key and frees its destination key when the source key
+// holds it, the source key being deleted.
+func (kr *KeyRewriter) Release(sourceDbId, destinationDbId uint64, key string) string {
+
+	if kr == nil {
+		return key
+	}
+
+	destination := kr.Rewrite(key)
+
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	if owner, isExist := kr.claims[destinationDbId][destination]; isExist && owner == (sourceKey{DatabaseId: sourceDbId, Key: key}) {
+		delete(kr.claims[destinationDbId], destination)
+	}
+
+	return destination
+}
+
+// Reset frees the destination keys claimed by the keys of a source database,
+// before a new round claims the keys it still holds.
+func (kr *KeyRewriter) Reset(sourceDbId, destinationDbId uint64) {
+
+	if kr == nil {
+		return
+	}
+
+	kr.lock.Lock()
+	defer kr.lock.Unlock()
+
+	for destination, owner := range kr.claims[destinationDbId] {
+
+		if owner.DatabaseId == sourceDbId {
+			delete(kr.claims[destinationDbId], destination)
+		}
+	}
+}
+
+// Reverse lists the source keys a destination key may come from. It returns
+// false when the key cannot be produced by the rules (it does not carry the
+// added prefix) or when the rules cannot be reversed (regex rename), such a
+// destination key must be left alone.
+func (kr *KeyRewriter) Reverse(destination string) ([]string, bool) {
+
+	if kr == nil {
+		return []string{destination}, true
+	}
+
+	if kr.Pattern != nil {
+		return nil, false
+	}
+
+	if !strings.HasPrefix(destination, kr.AddPrefix) {
+		return nil, false
+	}
+
+	key := strings.TrimPrefix(destination, kr.AddPrefix)
+	if kr.StripPrefix == "" {
+		return []string{key}, true
+	}
+
+	return []string{kr.StripPrefix + key, key}, true
+}
+
+func (kr *KeyRewriter) PrintReport() {
+
+	if kr == nil || kr.Collisions.Load() == 0 {
+		return
+	}
+
+	log.Printf("%d key collision(s) in the destination.\n", kr.Collisions.Load())
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRewriter_Claim(t *testing.T) {
+
+	rewriter, err := NewKeyRewriter("new:", "old:", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	destination, err := rewriter.Claim(0, 0, "old:a")
+	assert.NoError(t, err)
+	assert.Equal(t, "new:a", destination)
+
+	// the same source key claims again, another one collides
+	_, err = rewriter.Claim(0, 0, "old:a")
+	assert.NoError(t, err)
+	_, err = rewriter.Claim(0, 0, "a")
+	assert.IsType(t, &KeyCollisionError{}, err)
+	_, err = rewriter.Claim(1, 0, "old:a")
+	assert.IsType(t, &KeyCollisionError{}, err)
+	_, err = rewriter.Claim(1, 1, "old:a")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), rewriter.Collisions.Load())
+
+	// a deleted source key frees its destination key, another key does not
+	assert.Equal(t, "new:a", rewriter.Release(0, 0, "a"))
+	_, err = rewriter.Claim(0, 0, "a")
+	assert.Error(t, err)
+	rewriter.Release(0, 0, "old:a")
+	_, err = rewriter.Claim(0, 0, "a")
+	assert.NoError(t, err)
+
+	// a new round of a source database frees its claims only
+	rewriter.Reset(0, 0)
+	rewriter.Reset(0, 1)
+	_, err = rewriter.Claim(1, 0, "old:a")
+	assert.NoError(t, err)
+	_, err = rewriter.Claim(2, 1, "a")
+	assert.Error(t, err)
+
+	// without rules keys are only claimed
+	claims := NewKeyClaims()
+	assert.False(t, claims.HasRules())
+	assert.True(t, rewriter.HasRules())
+	destination, err = claims.Claim(0, 0, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", destination)
+	_, err = claims.Claim(1, 0, "a")
+	assert.EqualError(t, err, `key collision in destination database 0: "a" of db 0 and "a" of db 1 both map to "a"`)
+
+	var none *KeyRewriter
+	destination, err = none.Claim(0, 0, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", destination)
+	assert.Equal(t, "a", none.Release(0, 0, "a"))
+	none.Reset(0, 0)
+	assert.False(t, none.HasRules())
+}
@@ -0,0 +1,635 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keyspace holds keys in memory by database. Built by replaying the commands
+// of a RESP file or by loading the records of a dump file, it turns a RESP
+// file into records and answers the reads of serve.
+type Keyspace struct {
+	databases map[uint64]map[string]*keyEntry
+	dbId      uint64
+}
+
+// keyEntry is a key of a Keyspace, either a DUMP payload decoded when first
+// needed or a logical value (see DecodePayload). ExpireAt is in unix
+// milliseconds, 0 without expiration.
+type keyEntry struct {
+	Type     string
+	Payload  string
+	Data     interface{}
+	ExpireAt int64
+}
+
+func NewKeyspace() *Keyspace {
+
+	return &Keyspace{databases: make(map[uint64]map[string]*keyEntry)}
+}
+
+// Value is the logical value of the entry, its payload decoded once.
+func (e *keyEntry) Value() (interface{}, error) {
+
+	if e.Data == nil && e.Payload != "" {
+
+		_, data, err := DecodePayload(e.Payload)
+		if err != nil {
+			return nil, err
+		}
+		e.Data = data
+	}
+
+	return e.Data, nil
+}
+
+// DumpPayload is the DUMP payload of the entry, encoded from its logical
+// value when it has none.
+func (e *keyEntry) DumpPayload() (string, error) {
+
+	if e.Payload != "" {
+		return e.Payload, nil
+	}
+
+	return EncodePayload(e.Type, e.Data)
+}
+
+// TTL is the time to live of the entry in milliseconds, -1 without expiration.
+func (e *keyEntry) TTL(now time.Time) int64 {
+
+	if e.ExpireAt == 0 {
+		return -1
+	}
+
+	return e.ExpireAt - unixMillis(now)
+}
+
+func unixMillis(now time.Time) int64 {
+
+	return now.UnixNano() / int64(time.Millisecond)
+}
+
+func (k *Keyspace) database(dbId uint64) map[string]*keyEntry {
+
+	db, isExist := k.databases[dbId]
+	if !isExist {
+
+		db = make(map[string]*keyEntry)
+		k.databases[dbId] = db
+	}
+
+	return db
+}
+
+// Lookup returns the entry of key, nil when it does not exist or expired.
+func (k *Keyspace) Lookup(dbId uint64, key string, now time.Time) *keyEntry {
+
+	entry, isExist := k.databases[dbId][key]
+	if !isExist {
+		return nil
+	}
+
+	if entry.ExpireAt != 0 && entry.ExpireAt <= unixMillis(now) {
+
+		delete(k.databases[dbId], key)
+		return nil
+	}
+
+	return entry
+}
+
+// Keys returns the keys of a database which did not expire, sorted.
+func (k *Keyspace) Keys(dbId uint64, now time.Time) []string {
+
+	keys := make([]string, 0, len(k.databases[dbId]))
+	for key := range k.databases[dbId] {
+
+		if k.Lookup(dbId, key, now) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Databases returns the numbers of the databases holding keys, sorted.
+func (k *Keyspace) Databases() []uint64 {
+
+	var databases []uint64
+	for dbId, db := range k.databases {
+
+		if len(db) > 0 {
+			databases = append(databases, dbId)
+		}
+	}
+	sort.Slice(databases, func(i, j int) bool { return databases[i] < databases[j] })
+
+	return databases
+}
+
+// Load adds a record of a dump file, the parts of a big key being merged
+// into one entry. A Deleted record removes its key.
+func (k *Keyspace) Load(record *Record, now time.Time) error {
+
+	db := k.database(record.DatabaseId)
+	if record.Deleted {
+
+		delete(db, record.Key)
+		return nil
+	}
+
+	entry := &keyEntry{Type: record.Type, Payload: record.Value}
+	if record.Type == "" {
+
+		entry.Type, _ = PayloadType(record.Value)
+		if entry.Type == "" {
+			return fmt.Errorf("invalid DUMP payload of key \"%s\"", record.Key)
+		}
+	} else {
+
+		data, err := decodeLogical(record.Type, record.Value)
+		if err != nil {
+			return fmt.Errorf("decode key \"%s\" error, %s", record.Key, err)
+		}
+
+		entry.Payload = ""
+		entry.Data = data
+		if previous, isExist := db[record.Key]; isExist && record.Part > 1 {
+			entry.Data = mergeValue(previous.Data, data)
+		}
+	}
+
+	if record.TTL > 0 {
+		entry.ExpireAt = unixMillis(now.Add(time.Duration(record.TTL) * time.Second))
+	}
+
+	db[record.Key] = entry
+	return nil
+}
+
+// Each calls f with a record per key, by database then key, logical values
+// being kept logical and payloads as they are.
+func (k *Keyspace) Each(now time.Time, f func(record *Record) error) error {
+
+	for _, dbId := range k.Databases() {
+
+		for _, key := range k.Keys(dbId, now) {
+
+			entry := k.databases[dbId][key]
+			record := &Record{DatabaseId: dbId, Key: key, Value: entry.Payload, TTL: -1}
+			if entry.Payload == "" {
+
+				value, err := encodeLogical(entry.Data)
+				if err != nil {
+					return err
+				}
+				record.Type, record.Value = entry.Type, value
+			}
+
+			if ttl := entry.TTL(now); ttl > 0 {
+				record.TTL = (ttl + 999) / 1000
+			}
+
+			if err := f(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Apply replays a write command of a RESP or AOF file: SELECT, SET, SETEX,
+// PSETEX, APPEND, DEL, UNLINK, RESTORE, HSET, HMSET, RPUSH, LPUSH, SADD,
+// ZADD, XADD, the EXPIRE family, PERSIST, FLUSHDB and FLUSHALL. MULTI, EXEC
+// and the stream group commands are ignored.
+func (k *Keyspace) Apply(args []string, now time.Time) error {
+
+	command := strings.ToUpper(args[0])
+	arity := map[string]int{
+		"SELECT": 2, "SET": 3, "SETEX": 4, "PSETEX": 4, "APPEND": 3, "DEL": 2, "UNLINK": 2,
+		"RESTORE": 4, "HSET": 4, "HMSET": 4, "RPUSH": 3, "LPUSH": 3, "SADD": 3, "ZADD": 4,
+		"XADD": 5, "EXPIRE": 3, "PEXPIRE": 3, "EXPIREAT": 3, "PEXPIREAT": 3, "PERSIST": 2,
+	}
+	if len(args) < arity[command] {
+		return fmt.Errorf("wrong number of arguments for %s", command)
+	}
+
+	db := k.database(k.dbId)
+	switch command {
+	case "MULTI", "EXEC", "PING", "XGROUP", "XSETID", "XCLAIM":
+		return nil
+
+	case "SELECT":
+		dbId, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SELECT database %q", args[1])
+		}
+		k.dbId = dbId
+		return nil
+
+	case "FLUSHDB":
+		k.databases[k.dbId] = make(map[string]*keyEntry)
+		return nil
+
+	case "FLUSHALL":
+		k.databases = make(map[uint64]map[string]*keyEntry)
+		return nil
+
+	case "DEL", "UNLINK":
+		for _, key := range args[1:] {
+			delete(db, key)
+		}
+		return nil
+
+	case "SET":
+		return k.set(args, now)
+
+	case "SETEX", "PSETEX":
+		expireAt, err := expireTime(command, args[2], now)
+		if err != nil {
+			return err
+		}
+		db[args[1]] = &keyEntry{Type: TypeString, Data: args[3], ExpireAt: expireAt}
+		return nil
+
+	case "RESTORE":
+		return k.restore(args, now)
+
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		entry := k.Lookup(k.dbId, args[1], now)
+		if entry == nil {
+			return nil
+		}
+
+		expireAt, err := expireTime(command, args[2], now)
+		if err != nil {
+			return err
+		}
+		entry.ExpireAt = expireAt
+		if expireAt <= unixMillis(now) {
+			delete(db, args[1])
+		}
+		return nil
+
+	case "PERSIST":
+		if entry := k.Lookup(k.dbId, args[1], now); entry != nil {
+			entry.ExpireAt = 0
+		}
+		return nil
+	}
+
+	keyType := map[string]string{
+		"APPEND": TypeString, "HSET": TypeHash, "HMSET": TypeHash, "RPUSH": TypeList,
+		"LPUSH": TypeList, "SADD": TypeSet, "ZADD": TypeZSet, "XADD": TypeStream,
+	}[command]
+	if keyType == "" {
+		return fmt.Errorf("unsupported command %s", command)
+	}
+
+	entry := k.Lookup(k.dbId, args[1], now)
+	if entry == nil {
+
+		if command == "XADD" && strings.EqualFold(args[2], "NOMKSTREAM") {
+			return nil
+		}
+		entry = &keyEntry{Type: keyType}
+		db[args[1]] = entry
+	}
+
+	if entry.Type != keyType {
+		return fmt.Errorf("%s on key \"%s\" holding a %s", command, args[1], entry.Type)
+	}
+
+	data, err := entry.Value()
+	if err != nil {
+		return fmt.Errorf("decode key \"%s\" error, %s", args[1], err)
+	}
+	entry.Payload = ""
+
+	switch command {
+	case "APPEND":
+		entry.Data = mergeValue(data, args[2])
+
+	case "HSET", "HMSET":
+		if len(args)%2 != 0 {
+			return fmt.Errorf("wrong number of arguments for %s", command)
+		}
+		entry.Data = mergeValue(data, pairsToHash(args[2:]))
+
+	case "RPUSH":
+		entry.Data = mergeValue(data, args[2:])
+
+	case "LPUSH":
+		items := make([]string, 0, len(args)-2)
+		for i := len(args) - 1; i >= 2; i-- {
+			items = append(items, args[i])
+		}
+		entry.Data = mergeValue(items, data)
+
+	case "SADD":
+		members, _ := data.([]string)
+		entry.Data = addMembers(members, args[2:])
+
+	case "ZADD":
+		entry.Data, err = zadd(data, args[2:])
+
+	case "XADD":
+		entry.Data, err = xadd(data, args[2:], now)
+	}
+
+	return err
+}
+
+func (k *Keyspace) set(args []string, now time.Time) error {
+
+	db := k.database(k.dbId)
+	previous := k.Lookup(k.dbId, args[1], now)
+	entry := &keyEntry{Type: TypeString, Data: args[2]}
+	for i := 3; i < len(args); i++ {
+
+		switch option := strings.ToUpper(args[i]); option {
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(args) {
+				return fmt.Errorf("SET %s without a time", option)
+			}
+
+			expireAt, err := expireTime(option, args[i+1], now)
+			if err != nil {
+				return err
+			}
+			entry.ExpireAt = expireAt
+			i++
+
+		case "KEEPTTL":
+			if previous != nil {
+				entry.ExpireAt = previous.ExpireAt
+			}
+
+		case "NX":
+			if previous != nil {
+				return nil
+			}
+
+		case "XX":
+			if previous == nil {
+				return nil
+			}
+
+		case "GET":
+
+		default:
+			return fmt.Errorf("unsupported SET option %s", option)
+		}
+	}
+
+	db[args[1]] = entry
+	return nil
+}
+
+// restore handles RESTORE key ttl payload [REPLACE] [ABSTTL], the payload
+// being kept as is.
+func (k *Keyspace) restore(args []string, now time.Time) error {
+
+	keyType, _ := PayloadType(args[3])
+	if keyType == "" {
+		return fmt.Errorf("invalid DUMP payload of key \"%s\"", args[1])
+	}
+
+	entry := &keyEntry{Type: keyType, Payload: args[3]}
+	unit := "PEXPIRE"
+	for _, option := range args[4:] {
+
+		if strings.EqualFold(option, "ABSTTL") {
+			unit = "PEXPIREAT"
+		}
+	}
+
+	if args[2] != "0" {
+
+		expireAt, err := expireTime(unit, args[2], now)
+		if err != nil {
+			return err
+		}
+		entry.ExpireAt = expireAt
+	}
+
+	k.database(k.dbId)[args[1]] = entry
+	return nil
+}
+
+// expireTime is the expiration time in unix milliseconds of the time given
+// to a command or SET option, relative or absolute, in seconds or
+// milliseconds.
+func expireTime(unit, value string, now time.Time) (int64, error) {
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s time %q", unit, value)
+	}
+
+	switch unit {
+	case "EX", "EXPIRE", "SETEX":
+		return unixMillis(now) + n*1000, nil
+	case "PX", "PEXPIRE", "PSETEX":
+		return unixMillis(now) + n, nil
+	case "EXAT", "EXPIREAT":
+		return n * 1000, nil
+	}
+
+	return n, nil
+}
+
+func zadd(data interface{}, args []string) (interface{}, error) {
+
+	for len(args) > 0 {
+
+		option := strings.ToUpper(args[0])
+		if option != "NX" && option != "XX" && option != "CH" && option != "GT" && option != "LT" {
+			break
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, fmt.Errorf("wrong number of arguments for ZADD")
+	}
+
+	pairs := make([]string, 0, len(args))
+	for i := 0; i < len(args); i += 2 {
+		pairs = append(pairs, args[i+1], args[i])
+	}
+
+	scores, err := parseScores(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeValue(data, scores), nil
+}
+
+// xadd handles XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT
+// count]] id field value ..., an id of "*" being generated from now.
+func xadd(data interface{}, args []string, now time.Time) (interface{}, error) {
+
+	entries, _ := data.([]StreamEntry)
+	maxLength, minId := int64(-1), ""
+	for len(args) > 0 {
+
+		option := strings.ToUpper(args[0])
+		if option == "NOMKSTREAM" {
+
+			args = args[1:]
+			continue
+		}
+
+		if option != "MAXLEN" && option != "MINID" {
+			break
+		}
+
+		args = args[1:]
+		if len(args) > 0 && (args[0] == "=" || args[0] == "~") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("XADD %s without a threshold", option)
+		}
+
+		if option == "MINID" {
+			minId = args[0]
+		} else {
+
+			n, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid XADD MAXLEN %q", args[0])
+			}
+			maxLength = n
+		}
+		args = args[1:]
+
+		if len(args) > 1 && strings.EqualFold(args[0], "LIMIT") {
+			args = args[2:]
+		}
+	}
+
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, fmt.Errorf("wrong number of arguments for XADD")
+	}
+
+	id := args[0]
+	if id == "*" {
+
+		id = fmt.Sprintf("%d-0", unixMillis(now))
+		if len(entries) > 0 && compareStreamIds(id, entries[len(entries)-1].ID) <= 0 {
+
+			next, err := nextStreamId(entries[len(entries)-1].ID)
+			if err != nil {
+				return nil, err
+			}
+			id = next
+		}
+	}
+
+	entries = append(entries, StreamEntry{ID: id, Values: pairsToHash(args[1:])})
+	if minId != "" {
+
+		for len(entries) > 0 && compareStreamIds(entries[0].ID, minId) < 0 {
+			entries = entries[1:]
+		}
+	}
+	if maxLength >= 0 && int64(len(entries)) > maxLength {
+		entries = entries[int64(len(entries))-maxLength:]
+	}
+
+	return entries, nil
+}
+
+// compareStreamIds compares two stream ids "ms-seq", a missing seq being 0.
+func compareStreamIds(a, b string) int {
+
+	parse := func(id string) (ms, seq uint64) {
+
+		parts := strings.SplitN(id, "-", 2)
+		ms, _ = strconv.ParseUint(parts[0], 10, 64)
+		if len(parts) == 2 {
+			seq, _ = strconv.ParseUint(parts[1], 10, 64)
+		}
+		return
+	}
+
+	aMs, aSeq := parse(a)
+	bMs, bSeq := parse(b)
+	switch {
+	case aMs < bMs, aMs == bMs && aSeq < bSeq:
+		return -1
+	case aMs == bMs && aSeq == bSeq:
+		return 0
+	}
+
+	return 1
+}
+
+// addMembers adds to a set the members it does not hold yet.
+func addMembers(members, more []string) []string {
+
+	isMember := make(map[string]bool, len(members))
+	for _, member := range members {
+		isMember[member] = true
+	}
+
+	for _, member := range more {
+
+		if !isMember[member] {
+
+			isMember[member] = true
+			members = append(members, member)
+		}
+	}
+
+	return members
+}
+
+// mergeValue adds more to a logical value of the same type: appended to a
+// string, list or stream, added to a hash, set or sorted set.
+func mergeValue(data, more interface{}) interface{} {
+
+	switch more := more.(type) {
+	case string:
+		text, _ := data.(string)
+		return text + more
+
+	case map[string]string:
+		hash, isExist := data.(map[string]string)
+		if !isExist {
+			hash = make(map[string]string, len(more))
+		}
+		for field, value := range more {
+			hash[field] = value
+		}
+		return hash
+
+	case []string:
+		items, _ := data.([]string)
+		return append(items, more...)
+
+	case map[string]Score:
+		scores, isExist := data.(map[string]Score)
+		if !isExist {
+			scores = make(map[string]Score, len(more))
+		}
+		for member, score := range more {
+			scores[member] = score
+		}
+		return scores
+
+	case []StreamEntry:
+		entries, _ := data.([]StreamEntry)
+		return append(entries, more...)
+	}
+
+	return data
+}
@@ -0,0 +1,510 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	TransferDump    = "dump"
+	TransferLogical = "logical"
+)
+
+const (
+	TypeString = "string"
+	TypeHash   = "hash"
+	TypeList   = "list"
+	TypeSet    = "set"
+	TypeZSet   = "zset"
+	TypeStream = "stream"
+)
+
+// stringChunkSize is the number of bytes of a string read at once, the
+// element count of a chunk does not apply to strings.
+const stringChunkSize = 1 << 20
+
+// A logical value is the JSON encoding of a key decoded by type: a string,
+// an object for hashes, an array for lists and sets, an object of member to
+// score for sorted sets and an array of entries for streams. A chunk of a big
+// key uses the same encoding for the elements it holds.
+
+type StreamEntry struct {
+	ID     string            `json:"id"`
+	Values map[string]string `json:"values"`
+}
+
+// Score is a sorted set score, infinite scores are encoded as "inf" and
+// "-inf" since JSON numbers cannot hold them.
+type Score float64
+
+func (s Score) MarshalJSON() ([]byte, error) {
+
+	if math.IsInf(float64(s), 0) {
+		return json.Marshal(strconv.FormatFloat(float64(s), 'f', -1, 64))
+	}
+
+	return json.Marshal(float64(s))
+}
+
+func (s *Score) UnmarshalJSON(data []byte) error {
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+
+		f, err := strconv.ParseFloat(text, 64)
+		*s = Score(f)
+		return err
+	}
+
+	var f float64
+	err := json.Unmarshal(data, &f)
+	*s = Score(f)
+	return err
+}
+
+// ReadLogical reads a whole key by type with GET, HGETALL, LRANGE, SMEMBERS,
+// ZRANGE WITHSCORES or XRANGE, for destinations which cannot RESTORE the DUMP
+// payload of the source (older RDB version). A missing key gives redis.Nil.
+func ReadLogical(ctx context.Context, client *redis.Client, key string, policy *ErrorPolicy, limits *RateLimits) (keyType, value string, err error) {
+
+	err = policy.Retry(ctx, "TYPE", func() (err error) {
+		if err = limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		keyType, err = client.Type(key).Result()
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	if keyType == "none" {
+		return "", "", redis.Nil
+	}
+
+	var data interface{}
+	err = policy.Retry(ctx, strings.ToUpper(keyType)+" READ", func() (err error) {
+		if err = limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		data, err = readWhole(client, key, keyType)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	value, err = encodeLogical(data)
+	if err != nil {
+		return
+	}
+
+	err = limits.Read(ctx, 0, len(value))
+	return
+}
+
+func readWhole(client *redis.Client, key, keyType string) (interface{}, error) {
+
+	switch keyType {
+	case TypeString:
+		return client.Get(key).Result()
+
+	case TypeHash:
+		return client.HGetAll(key).Result()
+
+	case TypeList:
+		return client.LRange(key, 0, -1).Result()
+
+	case TypeSet:
+		return client.SMembers(key).Result()
+
+	case TypeZSet:
+		members, err := client.ZRangeWithScores(key, 0, -1).Result()
+		scores := make(map[string]Score, len(members))
+		for _, member := range members {
+			scores[fmt.Sprint(member.Member)] = Score(member.Score)
+		}
+		return scores, err
+
+	case TypeStream:
+		messages, err := client.XRange(key, "-", "+").Result()
+		return streamEntries(messages), err
+	}
+
+	return nil, fmt.Errorf("unsupported type %q of key \"%s\"", keyType, key)
+}
+
+// isBadPayload tells whether RESTORE rejected a DUMP payload, usually one of
+// a newer RDB version than the destination understands.
+func isBadPayload(err error) bool {
+
+	return err != nil && (strings.Contains(err.Error(), "Bad data format") ||
+		strings.Contains(err.Error(), "payload version or checksum are wrong"))
+}
+
+// KeyReader reads a key by type in chunks of Count elements, with HSCAN,
+// SSCAN, ZSCAN, LRANGE, XRANGE or GETRANGE, so a big key never has to be
+// serialized at once.
+type KeyReader struct {
+	Client *redis.Client
+	Key    string
+	Type   string
+	Count  int64
+	Policy *ErrorPolicy
+	Limits *RateLimits
+	cursor uint64
+	offset int64
+	nextId string
+	isDone bool
+}
+
+func (r *KeyReader) Done() bool {
+
+	return r.isDone
+}
+
+// Next returns the logical encoding of the next chunk.
+func (r *KeyReader) Next(ctx context.Context) (value string, err error) {
+
+	var data interface{}
+	err = r.Policy.Retry(ctx, strings.ToUpper(r.Type)+" READ", func() (err error) {
+		if err = r.Limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		data, err = r.next()
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	value, err = encodeLogical(data)
+	if err != nil {
+		return
+	}
+
+	err = r.Limits.Read(ctx, 0, len(value))
+	return
+}
+
+func (r *KeyReader) next() (data interface{}, err error) {
+
+	switch r.Type {
+	case TypeString:
+		var value string
+		value, err = r.Client.GetRange(r.Key, r.offset, r.offset+stringChunkSize-1).Result()
+		r.offset += int64(len(value))
+		r.isDone = len(value) < stringChunkSize
+		return value, err
+
+	case TypeHash:
+		var pairs []string
+		pairs, r.cursor, err = r.Client.HScan(r.Key, r.cursor, "", r.Count).Result()
+		r.isDone = r.cursor == 0
+		hash := make(map[string]string, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			hash[pairs[i]] = pairs[i+1]
+		}
+		return hash, err
+
+	case TypeSet:
+		var members []string
+		members, r.cursor, err = r.Client.SScan(r.Key, r.cursor, "", r.Count).Result()
+		r.isDone = r.cursor == 0
+		return members, err
+
+	case TypeZSet:
+		var pairs []string
+		pairs, r.cursor, err = r.Client.ZScan(r.Key, r.cursor, "", r.Count).Result()
+		r.isDone = r.cursor == 0
+		return parseScores(pairs)
+
+	case TypeList:
+		var items []string
+		items, err = r.Client.LRange(r.Key, r.offset, r.offset+r.Count-1).Result()
+		r.offset += int64(len(items))
+		r.isDone = int64(len(items)) < r.Count
+		return items, err
+
+	case TypeStream:
+		if r.nextId == "" {
+			r.nextId = "-"
+		}
+
+		var messages []redis.XMessage
+		messages, err = r.Client.XRangeN(r.Key, r.nextId, "+", r.Count).Result()
+		if err != nil {
+			return
+		}
+
+		r.isDone = int64(len(messages)) < r.Count
+		if len(messages) > 0 {
+			r.nextId, err = nextStreamId(messages[len(messages)-1].ID)
+		}
+		return streamEntries(messages), err
+	}
+
+	return nil, fmt.Errorf("unsupported type %q of key \"%s\"", r.Type, r.Key)
+}
+
+// writeLogical adds the elements of a logical value to key, with the native
+// command of its type. Commands which are not idempotent (APPEND, RPUSH,
+// XADD) are never retried, a retry could add the elements twice.
+func (d *Destination) writeLogical(ctx context.Context, key, keyType, value string) error {
+
+	data, err := decodeLogical(keyType, value)
+	if err != nil {
+		return err
+	}
+
+	if err = d.Limits.Write(ctx, 1, len(value)); err != nil {
+		return err
+	}
+
+	switch data := data.(type) {
+	case string:
+		// APPEND also creates an empty string key
+		return d.Client.Append(key, data).Err()
+
+	case map[string]string:
+		if len(data) == 0 {
+			return nil
+		}
+		fields := make(map[string]interface{}, len(data))
+		for field, value := range data {
+			fields[field] = value
+		}
+		return d.Policy.Retry(ctx, "HMSET", func() error {
+			return d.Client.HMSet(key, fields).Err()
+		})
+
+	case []string:
+		if len(data) == 0 {
+			return nil
+		}
+		members := make([]interface{}, len(data))
+		for i, member := range data {
+			members[i] = member
+		}
+		if keyType == TypeList {
+			return d.Client.RPush(key, members...).Err()
+		}
+		return d.Policy.Retry(ctx, "SADD", func() error {
+			return d.Client.SAdd(key, members...).Err()
+		})
+
+	case map[string]Score:
+		if len(data) == 0 {
+			return nil
+		}
+		members := make([]redis.Z, 0, len(data))
+		for member, score := range data {
+			members = append(members, redis.Z{Score: float64(score), Member: member})
+		}
+		return d.Policy.Retry(ctx, "ZADD", func() error {
+			return d.Client.ZAdd(key, members...).Err()
+		})
+
+	case []StreamEntry:
+		for _, entry := range data {
+
+			values := make(map[string]interface{}, len(entry.Values))
+			for field, value := range entry.Values {
+				values[field] = value
+			}
+			if err := d.Client.XAdd(&redis.XAddArgs{Stream: key, ID: entry.ID, Values: values}).Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported type %q of key \"%s\"", keyType, key)
+}
+
+func encodeLogical(data interface{}) (string, error) {
+
+	jsonBytes, err := json.Marshal(mapText(data, escapeText))
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func decodeLogical(keyType, value string) (data interface{}, err error) {
+
+	switch keyType {
+	case TypeString:
+		var text string
+		err = json.Unmarshal([]byte(value), &text)
+		data = text
+	case TypeHash:
+		hash := make(map[string]string)
+		err = json.Unmarshal([]byte(value), &hash)
+		data = hash
+	case TypeList, TypeSet:
+		var items []string
+		err = json.Unmarshal([]byte(value), &items)
+		data = items
+	case TypeZSet:
+		scores := make(map[string]Score)
+		err = json.Unmarshal([]byte(value), &scores)
+		data = scores
+	case TypeStream:
+		var entries []StreamEntry
+		err = json.Unmarshal([]byte(value), &entries)
+		data = entries
+	default:
+		return nil, fmt.Errorf("unsupported type %q", keyType)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("decode %s value error, %s", keyType, err)
+	}
+
+	var textErr error
+	data = mapText(data, func(text string) string {
+		text, err := unescapeText(text)
+		if err != nil && textErr == nil {
+			textErr = err
+		}
+		return text
+	})
+	if textErr != nil {
+		return nil, fmt.Errorf("decode %s value error, %s", keyType, textErr)
+	}
+
+	return
+}
+
+// textBase64Prefix marks a string kept in base64 in a logical value: redis
+// strings are binary, JSON strings are UTF-8. Strings which are not valid
+// UTF-8, or which start with the prefix themselves, are written that way.
+const textBase64Prefix = "base64:"
+
+func escapeText(text string) string {
+
+	if utf8.ValidString(text) && !strings.HasPrefix(text, textBase64Prefix) {
+		return text
+	}
+
+	return textBase64Prefix + base64.StdEncoding.EncodeToString([]byte(text))
+}
+
+func unescapeText(text string) (string, error) {
+
+	if !strings.HasPrefix(text, textBase64Prefix) {
+		return text, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, textBase64Prefix))
+	if err != nil {
+		return "", fmt.Errorf("base64 decode %q error, %s", text, err)
+	}
+
+	return string(b), nil
+}
+
+// mapText applies f to every string of a decoded value.
+func mapText(data interface{}, f func(string) string) interface{} {
+
+	switch data := data.(type) {
+	case string:
+		return f(data)
+
+	case map[string]string:
+		mapped := make(map[string]string, len(data))
+		for field, value := range data {
+			mapped[f(field)] = f(value)
+		}
+		return mapped
+
+	case []string:
+		mapped := make([]string, len(data))
+		for i, item := range data {
+			mapped[i] = f(item)
+		}
+		return mapped
+
+	case map[string]Score:
+		mapped := make(map[string]Score, len(data))
+		for member, score := range data {
+			mapped[f(member)] = score
+		}
+		return mapped
+
+	case []StreamEntry:
+		mapped := make([]StreamEntry, len(data))
+		for i, entry := range data {
+			mapped[i] = StreamEntry{ID: entry.ID, Values: mapText(entry.Values, f).(map[string]string)}
+		}
+		return mapped
+	}
+
+	return data
+}
+
+func parseScores(pairs []string) (map[string]Score, error) {
+
+	scores := make(map[string]Score, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse score %q error, %s", pairs[i+1], err)
+		}
+		scores[pairs[i]] = Score(score)
+	}
+
+	return scores, nil
+}
+
+func streamEntries(messages []redis.XMessage) []StreamEntry {
+
+	entries := make([]StreamEntry, len(messages))
+	for i, message := range messages {
+
+		values := make(map[string]string, len(message.Values))
+		for field, value := range message.Values {
+			values[field] = fmt.Sprint(value)
+		}
+		entries[i] = StreamEntry{ID: message.ID, Values: values}
+	}
+
+	return entries
+}
+
+// nextStreamId returns the smallest stream ID after id, XRANGE start being
+// inclusive.
+func nextStreamId(id string) (string, error) {
+
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid stream id %q", id)
+	}
+
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream id %q, %s", id, err)
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream id %q, %s", id, err)
+	}
+
+	if seq == math.MaxUint64 {
+		return fmt.Sprintf("%d-0", ms+1), nil
+	}
+
+	return fmt.Sprintf("%d-%d", ms, seq+1), nil
+}
@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseDatabaseMaps parses one db-map per input, separated by ";" in the
+// order of the inputs, e.g. "0:3;*:1". An empty map keeps the databases of
+// its input.
+func ParseDatabaseMaps(mapsString string, count int) ([]*DatabaseMap, error) {
+
+	dbMaps := make([]*DatabaseMap, count)
+	if strings.TrimSpace(mapsString) == "" {
+		return dbMaps, nil
+	}
+
+	parts := strings.Split(mapsString, ";")
+	if len(parts) != count {
+
+		return nil, &ConfigError{Err: fmt.Errorf("%d db-map(s) for %d input(s)", len(parts), count)}
+	}
+
+	for i, part := range parts {
+
+		dbMap, err := ParseDatabaseMap(part)
+		if err != nil {
+			return nil, err
+		}
+		dbMaps[i] = dbMap
+	}
+
+	return dbMaps, nil
+}
+
+// Merger combines several dump files into one. DatabaseMaps[i] renumbers the
+// databases of Inputs[i], Conflict decides which input keeps a key found in
+// several ones, or in several databases of an input mapped to one: the last
+// one without policy, the first one with skip, the one with the longest TTL
+// with keep-newer-ttl, none with fail.
+type Merger struct {
+	Inputs       []string
+	Output       string
+	DatabaseMaps []*DatabaseMap
+	Conflict     *ConflictPolicy
+}
+
+type mergeEntry struct {
+	input      int
+	sourceDbId uint64
+	ttl        int64
+}
+
+// Merge reads the inputs twice: once to choose the input of every key, then
+// to copy the chosen records in input order. Skipped counts the records left
+// out by the conflict policy.
+func (m *Merger) Merge(ctx context.Context) (result Result, err error) {
+
+	if err = checkOutput(m.Output, m.Inputs...); err != nil {
+		return
+	}
+
+	entries := make(map[string]*mergeEntry)
+	for i, input := range m.Inputs {
+
+		i, input := i, input
+		seen := make(map[string]bool)
+		err = m.read(ctx, i, func(id string, sourceDbId uint64, record *Record) error {
+
+			// the parts of a big key are one key, in the database it is read from
+			source := fmt.Sprintf("%d:%s", sourceDbId, record.Key)
+			if seen[source] {
+				return nil
+			}
+			seen[source] = true
+
+			entry, isExist := entries[id]
+			if !isExist {
+
+				entries[id] = &mergeEntry{input: i, sourceDbId: sourceDbId, ttl: record.TTL}
+				return nil
+			}
+
+			result.Skipped++
+			if m.choose(entry, i, record.TTL) {
+
+				entry.input = i
+				entry.sourceDbId = sourceDbId
+				entry.ttl = record.TTL
+				return nil
+			}
+
+			if m.Conflict != nil && m.Conflict.OnConflict == OnConflictFail {
+
+				return fmt.Errorf("key \"%s\" of db %d is in both %s (db %d) and %s (db %d)", record.Key, record.DatabaseId, m.Inputs[entry.input], entry.sourceDbId, input, sourceDbId)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	writer, err := NewDumpFileWriter(m.Output)
+	if err != nil {
+		return
+	}
+
+	for i := range m.Inputs {
+
+		i := i
+		err = m.read(ctx, i, func(id string, sourceDbId uint64, record *Record) error {
+
+			if entry := entries[id]; entry.input != i || entry.sourceDbId != sourceDbId {
+				return nil
+			}
+
+			if !record.More {
+				result.Succeeded++
+			}
+			return writer.Write(record)
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	return
+}
+
+// choose tells whether input replaces the input holding the key so far,
+// counting the decision.
+func (m *Merger) choose(entry *mergeEntry, input int, ttl int64) bool {
+
+	if m.Conflict == nil {
+		return true
+	}
+
+	switch m.Conflict.OnConflict {
+	case OnConflictSkip:
+		m.Conflict.Skipped.Inc()
+		return false
+
+	case OnConflictFail:
+		m.Conflict.Failed.Inc()
+		return false
+
+	case OnConflictKeepNewerTTL:
+		if isNewerTTL(time.Duration(ttl)*time.Second, time.Duration(entry.ttl)*time.Second) {
+
+			m.Conflict.Replaced.Inc()
+			return true
+		}
+		m.Conflict.Skipped.Inc()
+		return false
+	}
+
+	m.Conflict.Replaced.Inc()
+	return true
+}
+
+// read calls f with every record of input i, renumbered by its db-map, its
+// "db:key" id and the database it is read from.
+func (m *Merger) read(ctx context.Context, i int, f func(id string, sourceDbId uint64, record *Record) error) error {
+
+	dbMap := m.DatabaseMaps[i]
+	return ReadDumpFile(ctx, m.Inputs[i], func(record *Record) error {
+
+		sourceDbId := record.DatabaseId
+		record.DatabaseId = dbMap.Map(sourceDbId)
+		return f(fmt.Sprintf("%d:%s", record.DatabaseId, record.Key), sourceDbId, record)
+	})
+}
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerger_Merge(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	first := filepath.Join(dir, "first.json")
+	writeDumpFile(t, first,
+		logicalRecord(0, "only first", "1", -1),
+		logicalRecord(0, "both", "1", 100),
+		logicalRecord(0, "persistent", "1", -1),
+		&Record{Key: "big", Type: TypeList, Value: `["a"]`, TTL: 10, Part: 1, More: true},
+		&Record{Key: "big", Type: TypeList, Value: `["b"]`, TTL: 10, Part: 2},
+	)
+
+	second := filepath.Join(dir, "second.json")
+	writeDumpFile(t, second,
+		logicalRecord(0, "both", "2", 50),
+		logicalRecord(0, "persistent", "2", 1000),
+		logicalRecord(0, "big", "2", 20),
+		logicalRecord(1, "only first", "2", -1),
+	)
+
+	cases := []struct {
+		onConflict string
+		dbMaps     string
+		values     map[string]interface{}
+		skipped    uint64
+	}{
+		{OnConflictReplace, "", map[string]interface{}{"0:only first": "1", "0:both": "2", "0:persistent": "2", "0:big": "2", "1:only first": "2"}, 3},
+		{OnConflictSkip, "", map[string]interface{}{"0:only first": "1", "0:both": "1", "0:persistent": "1", "0:big": []string{"a", "b"}, "1:only first": "2"}, 3},
+		{OnConflictKeepNewerTTL, "", map[string]interface{}{"0:only first": "1", "0:both": "1", "0:persistent": "1", "0:big": "2", "1:only first": "2"}, 3},
+		// the second input written to db 0 as well
+		{OnConflictReplace, ";*:0", map[string]interface{}{"0:only first": "2", "0:both": "2", "0:persistent": "2", "0:big": "2"}, 4},
+		{OnConflictSkip, "*:1;", map[string]interface{}{"1:only first": "1", "1:both": "1", "1:persistent": "1", "1:big": []string{"a", "b"}, "0:both": "2", "0:persistent": "2", "0:big": "2"}, 1},
+	}
+
+	for _, c := range cases {
+
+		name := c.onConflict + " " + c.dbMaps
+		conflict, err := NewConflictPolicy(c.onConflict)
+		assert.NoError(t, err)
+		dbMaps, err := ParseDatabaseMaps(c.dbMaps, 2)
+		assert.NoError(t, err)
+
+		output := filepath.Join(dir, "merged.json")
+		merger := &Merger{Inputs: []string{first, second}, Output: output, DatabaseMaps: dbMaps, Conflict: conflict}
+		result, err := merger.Merge(context.Background())
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.Equal(t, uint64(len(c.values)), result.Succeeded, name)
+		assert.Equal(t, c.skipped, result.Skipped, name)
+
+		values := make(map[string]interface{})
+		now := time.Now()
+		keyspace := keyspaceOf(t, now, output)
+		for _, dbId := range keyspace.Databases() {
+
+			for _, key := range keyspace.Keys(dbId, now) {
+				values[fmt.Sprintf("%d:%s", dbId, key)], _ = keyspace.Lookup(dbId, key, now).Value()
+			}
+		}
+		assert.Equal(t, c.values, values, name)
+	}
+
+	conflict, _ := NewConflictPolicy(OnConflictFail)
+	merger := &Merger{Inputs: []string{first, second}, Output: filepath.Join(dir, "failed.json"), DatabaseMaps: make([]*DatabaseMap, 2), Conflict: conflict}
+	_, err := merger.Merge(context.Background())
+	assert.Error(t, err)
+
+	_, err = ParseDatabaseMaps("0:1", 2)
+	assert.Error(t, err)
+}
+
+// Databases of one input mapped to the same database hold conflicting keys
+// too, the parts of a big key being one of them.
+func TestMerger_MergeDatabases(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	input := filepath.Join(dir, "input.json")
+	writeDumpFile(t, input,
+		logicalRecord(0, "k", "db 0", -1),
+		&Record{DatabaseId: 1, Key: "k", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{DatabaseId: 1, Key: "k", Type: TypeList, Value: `["b"]`, TTL: -1, Part: 2},
+		logicalRecord(1, "other", "db 1", -1),
+	)
+
+	cases := []struct {
+		onConflict string
+		value      interface{}
+		records    int
+		isFailed   bool
+	}{
+		{OnConflictReplace, []string{"a", "b"}, 3, false},
+		{OnConflictSkip, "db 0", 2, false},
+		{OnConflictFail, nil, 0, true},
+	}
+
+	for _, c := range cases {
+
+		conflict, err := NewConflictPolicy(c.onConflict)
+		assert.NoError(t, err)
+		dbMaps, err := ParseDatabaseMaps("*:0", 1)
+		assert.NoError(t, err)
+
+		output := filepath.Join(dir, c.onConflict+".json")
+		merger := &Merger{Inputs: []string{input}, Output: output, DatabaseMaps: dbMaps, Conflict: conflict}
+		result, err := merger.Merge(context.Background())
+		if c.isFailed {
+
+			assert.Error(t, err, c.onConflict)
+			continue
+		}
+		if !assert.NoError(t, err, c.onConflict) {
+			continue
+		}
+		assert.Equal(t, Result{Succeeded: 2, Skipped: 1}, result, c.onConflict)
+		assert.Len(t, readDumpFile(t, output), c.records, c.onConflict)
+
+		now := time.Now()
+		value, err := keyspaceOf(t, now, output).Lookup(0, "k", now).Value()
+		assert.NoError(t, err)
+		assert.Equal(t, c.value, value, c.onConflict)
+	}
+}
@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	PlanCreate  = "create"
+	PlanReplace = "replace"
+	PlanSkip    = "skip"
+	PlanFail    = "fail"
+	PlanDelete  = "delete"
+)
+
+// Plan replaces every write of a dry run: restore and sync still read and
+// decide, but each key is only counted and, when Path is set, written to the
+// plan file as one JSON line per key.
+type Plan struct {
+	Path     string
+	Created  atomic.Uint64
+	Replaced atomic.Uint64
+	Skipped  atomic.Uint64
+	Failed   atomic.Uint64
+	Deleted  atomic.Uint64
+	lock     sync.Mutex
+	stream   *os.File
+}
+
+type PlanEntry struct {
+	DatabaseId uint64 `json:"db"`
+	Key        string `json:"key"`
+	Action     string `json:"action"`
+}
+
+func NewPlan(path string) *Plan {
+
+	return &Plan{Path: path}
+}
+
+func (p *Plan) Add(dbId uint64, key, action string) (err error) {
+
+	switch action {
+	case PlanCreate:
+		p.Created.Inc()
+	case PlanReplace:
+		p.Replaced.Inc()
+	case PlanSkip:
+		p.Skipped.Inc()
+	case PlanFail:
+		p.Failed.Inc()
+	case PlanDelete:
+		p.Deleted.Inc()
+	}
+
+	if p.Path == "" {
+		return
+	}
+
+	jsonBytes, err := json.Marshal(&PlanEntry{DatabaseId: dbId, Key: key, Action: action})
+	if err != nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stream == nil {
+
+		p.stream, err = os.Create(p.Path)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = p.stream.Write(append(jsonBytes, '\n'))
+	return
+}
+
+func (p *Plan) PrintReport() {
+
+	if p == nil {
+		return
+	}
+
+	log.Printf("Dry run plan: %d to create, %d to replace, %d to skip, %d to fail (existing key), %d to delete.\n",
+		p.Created.Load(), p.Replaced.Load(), p.Skipped.Load(), p.Failed.Load(), p.Deleted.Load())
+}
+
+func (p *Plan) Close() {
+
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stream == nil {
+		return
+	}
+
+	p.stream.Close()
+	p.stream = nil
+	log.Printf("Dry run plan written to %s.\n", p.Path)
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A dry-run restore writes nothing, every key gets its action in the plan
+// file and the counts.
+func TestPlan_Restore(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	path := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, path,
+		logicalRecord(0, "new", "1", -1),
+		payloadRecord(0, "existing", TypeString, "1", 100),
+		&Record{Key: "big", Type: TypeList, Value: `["a"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "big", Type: TypeList, Value: `["b"]`, TTL: -1, Part: 2},
+		&Record{DatabaseId: 1, Key: "removed", Deleted: true},
+	)
+
+	cases := []struct {
+		onConflict string
+		existing   string
+		counts     []uint64
+	}{
+		{OnConflictReplace, PlanReplace, []uint64{2, 1, 0, 0, 1}},
+		{OnConflictSkip, PlanSkip, []uint64{2, 0, 1, 0, 1}},
+		{OnConflictFail, PlanFail, []uint64{2, 0, 0, 1, 1}},
+		{OnConflictKeepNewerTTL, PlanReplace, []uint64{2, 1, 0, 0, 1}},
+	}
+
+	for _, c := range cases {
+
+		destination := newFakeRedis(t,
+			logicalRecord(0, "existing", "old", 50),
+			logicalRecord(1, "removed", "old", -1),
+		)
+
+		conflict, err := NewConflictPolicy(c.onConflict)
+		assert.NoError(t, err)
+		planPath := filepath.Join(dir, c.onConflict+".plan")
+		plan := NewPlan(planPath)
+		_, err = (&RestoreLauncher{}).SetHost(destination.Addr).SetPath(path).SetThreadCount(1).
+			SetConflictPolicy(conflict).SetPlan(plan).Launch(context.Background())
+		plan.Close()
+		assert.NoError(t, err, c.onConflict)
+
+		counts := []uint64{plan.Created.Load(), plan.Replaced.Load(), plan.Skipped.Load(), plan.Failed.Load(), plan.Deleted.Load()}
+		assert.Equal(t, c.counts, counts, c.onConflict)
+		assert.Equal(t, map[string]string{"0:new": PlanCreate, "0:existing": c.existing, "0:big": PlanCreate, "1:removed": PlanDelete}, readPlanFile(t, planPath), c.onConflict)
+
+		assert.Empty(t, destination.Commands("SET", "RESTORE", "DEL", "APPEND", "RPUSH", "PEXPIRE"), c.onConflict)
+		assert.Equal(t, "old", destination.Value(t, 0, "existing"), c.onConflict)
+		assert.Equal(t, []string{"removed"}, destination.Keys(1), c.onConflict)
+		destination.Close()
+	}
+
+	// without a path only the counts are kept
+	plan := NewPlan("")
+	assert.NoError(t, plan.Add(0, "k", PlanCreate))
+	assert.Equal(t, uint64(1), plan.Created.Load())
+	plan.Close()
+
+	var none *Plan
+	none.PrintReport()
+	none.Close()
+}
+
+// readPlanFile returns the actions of a plan file by "db:key".
+func readPlanFile(t *testing.T, path string) map[string]string {
+
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	actions := make(map[string]string)
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+
+		entry := &PlanEntry{}
+		if !assert.NoError(t, json.Unmarshal(scanner.Bytes(), entry)) {
+			continue
+		}
+		actions[fmt.Sprintf("%d:%s", entry.DatabaseId, entry.Key)] = entry.Action
+	}
+
+	return actions
+}
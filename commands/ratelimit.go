@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+// RateLimits caps the requests sent to the source (reads) and to the
+// destination (writes), in commands and in payload bytes per second.
+type RateLimits struct {
+	ReadOps    *lib.RateLimiter
+	ReadBytes  *lib.RateLimiter
+	WriteOps   *lib.RateLimiter
+	WriteBytes *lib.RateLimiter
+}
+
+func NewRateLimits(readOps, readBytes, writeOps, writeBytes float64) *RateLimits {
+
+	return &RateLimits{
+		ReadOps:    lib.NewRateLimiter(readOps),
+		ReadBytes:  lib.NewRateLimiter(readBytes),
+		WriteOps:   lib.NewRateLimiter(writeOps),
+		WriteBytes: lib.NewRateLimiter(writeBytes),
+	}
+}
+
+func (l *RateLimits) Read(ctx context.Context, ops, bytes int) error {
+
+	if l == nil {
+		return nil
+	}
+
+	if err := l.ReadOps.Wait(ctx, ops); err != nil {
+		return err
+	}
+
+	return l.ReadBytes.Wait(ctx, bytes)
+}
+
+func (l *RateLimits) Write(ctx context.Context, ops, bytes int) error {
+
+	if l == nil {
+		return nil
+	}
+
+	if err := l.WriteOps.Wait(ctx, ops); err != nil {
+		return err
+	}
+
+	return l.WriteBytes.Wait(ctx, bytes)
+}
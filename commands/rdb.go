@@ -0,0 +1,1200 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+// RDB value types, see rdbTypes for the key type of each.
+const (
+	rdbTypeString          = 0
+	rdbTypeList            = 1
+	rdbTypeSet             = 2
+	rdbTypeZSet            = 3
+	rdbTypeHash            = 4
+	rdbTypeZSet2           = 5
+	rdbTypeHashZipmap      = 9
+	rdbTypeListZiplist     = 10
+	rdbTypeSetIntset       = 11
+	rdbTypeZSetZiplist     = 12
+	rdbTypeHashZiplist     = 13
+	rdbTypeListQuicklist   = 14
+	rdbTypeStream          = 15
+	rdbTypeHashListpack    = 16
+	rdbTypeZSetListpack    = 17
+	rdbTypeListQuicklist2  = 18
+	rdbTypeStream2         = 19
+	rdbTypeSetListpack     = 20
+	rdbTypeStream3         = 21
+	rdbTypeHashMetadataPre = 22
+	rdbTypeHashListpackPre = 23
+	rdbTypeHashMetadata    = 24
+	rdbTypeHashListpackEx  = 25
+)
+
+// rdbPayloadVersion is the RDB version of the DUMP payloads encoded from
+// logical values: 8 (redis 4), the oldest one with binary sorted set scores,
+// so that any later redis restores them.
+const rdbPayloadVersion = 8
+
+// maxPreallocation bounds the room reserved up front for the items or bytes
+// an RDB input claims to hold, which may be any count: the rest grows as they
+// are read, so that a corrupt count ends in a format error.
+const maxPreallocation = 64 * 1024
+
+func preallocation(count int) int {
+
+	if count > maxPreallocation {
+		return maxPreallocation
+	}
+	return count
+}
+
+// rdbSource is read by an rdbDecoder, a bufio.Reader or a bytes.Reader.
+type rdbSource interface {
+	io.Reader
+	io.ByteReader
+}
+
+// rdbDecoder reads RDB encoded values. While capture is set, the bytes read
+// are copied to it, which rebuilds the DUMP payload of a key of an RDB file.
+type rdbDecoder struct {
+	source  rdbSource
+	capture *bytes.Buffer
+}
+
+// DecodePayload decodes a DUMP payload into the logical value of its key,
+// after checking its checksum: a string, map[string]string, []string,
+// map[string]Score or []StreamEntry.
+func DecodePayload(payload string) (keyType string, data interface{}, err error) {
+
+	if len(payload) < 11 {
+		return "", nil, fmt.Errorf("DUMP payload of %d bytes too short", len(payload))
+	}
+
+	body := []byte(payload[:len(payload)-8])
+	if binary.LittleEndian.Uint64([]byte(payload[len(payload)-8:])) != lib.CRC64(0, body) {
+		return "", nil, fmt.Errorf("DUMP payload checksum mismatch")
+	}
+
+	decoder := &rdbDecoder{source: bytes.NewReader(body[1 : len(body)-2])}
+	return decoder.readValue(payload[0])
+}
+
+// EncodePayload encodes a logical value as a DUMP payload, with the plain RDB
+// encodings that every redis loads. Streams are not supported.
+func EncodePayload(keyType string, data interface{}) (string, error) {
+
+	var buffer bytes.Buffer
+	switch data := data.(type) {
+	case string:
+		buffer.WriteByte(rdbTypeString)
+		writeRDBString(&buffer, data)
+
+	case map[string]string:
+		buffer.WriteByte(rdbTypeHash)
+		writeRDBLength(&buffer, uint64(len(data)))
+		for _, field := range sortedKeys(data) {
+			writeRDBString(&buffer, field)
+			writeRDBString(&buffer, data[field])
+		}
+
+	case []string:
+		if keyType == TypeList {
+			buffer.WriteByte(rdbTypeList)
+		} else {
+			buffer.WriteByte(rdbTypeSet)
+		}
+		writeRDBLength(&buffer, uint64(len(data)))
+		for _, item := range data {
+			writeRDBString(&buffer, item)
+		}
+
+	case map[string]Score:
+		members := make([]string, 0, len(data))
+		for member := range data {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+
+		buffer.WriteByte(rdbTypeZSet2)
+		writeRDBLength(&buffer, uint64(len(data)))
+		for _, member := range members {
+			writeRDBString(&buffer, member)
+			binary.Write(&buffer, binary.LittleEndian, math.Float64bits(float64(data[member])))
+		}
+
+	default:
+		return "", fmt.Errorf("%s values cannot be encoded as a DUMP payload", keyType)
+	}
+
+	return sealPayload(buffer.Bytes(), rdbPayloadVersion), nil
+}
+
+// sealPayload appends the RDB version and the checksum to a type byte and its
+// encoded value.
+func sealPayload(value []byte, version uint16) string {
+
+	payload := make([]byte, 0, len(value)+10)
+	payload = append(payload, value...)
+	payload = append(payload, byte(version), byte(version>>8))
+
+	crc := make([]byte, 8)
+	binary.LittleEndian.PutUint64(crc, lib.CRC64(0, payload))
+	return string(append(payload, crc...))
+}
+
+// payloadToLogical turns a DUMP payload record value into a logical one.
+func payloadToLogical(payload string) (keyType, value string, err error) {
+
+	keyType, data, err := DecodePayload(payload)
+	if err != nil {
+		return
+	}
+
+	value, err = encodeLogical(data)
+	return
+}
+
+// logicalToPayload turns a logical record value into a DUMP payload.
+func logicalToPayload(keyType, value string) (string, error) {
+
+	data, err := decodeLogical(keyType, value)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodePayload(keyType, data)
+}
+
+func sortedKeys(m map[string]string) []string {
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func writeRDBLength(buffer *bytes.Buffer, length uint64) {
+
+	switch {
+	case length < 1<<6:
+		buffer.WriteByte(byte(length))
+	case length < 1<<14:
+		buffer.WriteByte(byte(length>>8) | 0x40)
+		buffer.WriteByte(byte(length))
+	case length <= math.MaxUint32:
+		buffer.WriteByte(0x80)
+		binary.Write(buffer, binary.BigEndian, uint32(length))
+	default:
+		buffer.WriteByte(0x81)
+		binary.Write(buffer, binary.BigEndian, length)
+	}
+}
+
+func writeRDBString(buffer *bytes.Buffer, text string) {
+
+	writeRDBLength(buffer, uint64(len(text)))
+	buffer.WriteString(text)
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+
+	b, err := d.source.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+
+	if d.capture != nil {
+		d.capture.WriteByte(b)
+	}
+
+	return b, nil
+}
+
+func (d *rdbDecoder) readFull(n uint64) ([]byte, error) {
+
+	if n > maxRecordLength {
+		return nil, fmt.Errorf("RDB string of %d bytes too long", n)
+	}
+
+	var data []byte
+	if n <= maxPreallocation {
+
+		data = make([]byte, n)
+		if _, err := io.ReadFull(d.source, data); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	} else {
+
+		buffer := bytes.NewBuffer(make([]byte, 0, maxPreallocation))
+		if _, err := io.CopyN(buffer, d.source, int64(n)); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		data = buffer.Bytes()
+	}
+
+	if d.capture != nil {
+		d.capture.Write(data)
+	}
+
+	return data, nil
+}
+
+// readLength reads a length, or with isEncoded the kind of a specially
+// encoded string.
+func (d *rdbDecoder) readLength() (length uint64, isEncoded bool, err error) {
+
+	b, err := d.readByte()
+	if err != nil {
+		return
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+
+	case 1:
+		next, err := d.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+
+	case 2:
+		switch b {
+		case 0x80:
+			data, err := d.readFull(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(data)), false, nil
+
+		case 0x81:
+			data, err := d.readFull(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(data), false, nil
+		}
+		return 0, false, fmt.Errorf("unknown RDB length encoding 0x%x", b)
+	}
+
+	return uint64(b & 0x3f), true, nil
+}
+
+func (d *rdbDecoder) readCount() (int, error) {
+
+	length, isEncoded, err := d.readLength()
+	if err == nil && isEncoded {
+		err = fmt.Errorf("RDB length expected, got an encoded string")
+	}
+	if err == nil && length > math.MaxInt32 {
+		err = fmt.Errorf("RDB count %d out of range", length)
+	}
+
+	return int(length), err
+}
+
+func (d *rdbDecoder) readString() (string, error) {
+
+	length, isEncoded, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	if !isEncoded {
+
+		data, err := d.readFull(length)
+		return string(data), err
+	}
+
+	switch length {
+	case 0, 1, 2:
+		data, err := d.readFull(1 << length)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(littleEndianInt(data), 10), nil
+
+	case 3:
+		compressedLength, err := d.readCount()
+		if err != nil {
+			return "", err
+		}
+
+		length, err := d.readCount()
+		if err != nil {
+			return "", err
+		}
+
+		compressed, err := d.readFull(uint64(compressedLength))
+		if err != nil {
+			return "", err
+		}
+
+		data, err := lzfDecompress(compressed, length)
+		return string(data), err
+	}
+
+	return "", fmt.Errorf("unknown RDB string encoding %d", length)
+}
+
+// readDouble reads a score of the old sorted set encoding, as text.
+func (d *rdbDecoder) readDouble() (float64, error) {
+
+	length, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	data, err := d.readFull(uint64(length))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(string(data), 64)
+}
+
+func (d *rdbDecoder) readUint64() (uint64, error) {
+
+	data, err := d.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(data), nil
+}
+
+func (d *rdbDecoder) readStrings(count int) ([]string, error) {
+
+	items := make([]string, 0, preallocation(count))
+	for i := 0; i < count; i++ {
+
+		item, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// readValue reads the value of rdbType, see DecodePayload.
+func (d *rdbDecoder) readValue(rdbType byte) (keyType string, data interface{}, err error) {
+
+	keyType, _ = PayloadType(string([]byte{rdbType}) + "          ")
+	if keyType == "" || keyType == "module" {
+		return "", nil, fmt.Errorf("unsupported RDB type %d", rdbType)
+	}
+
+	switch rdbType {
+	case rdbTypeString:
+		data, err = d.readString()
+
+	case rdbTypeList, rdbTypeSet:
+		var count int
+		if count, err = d.readCount(); err == nil {
+			data, err = d.readStrings(count)
+		}
+
+	case rdbTypeZSet, rdbTypeZSet2:
+		data, err = d.readZSet(rdbType)
+
+	case rdbTypeHash:
+		var count int
+		var pairs []string
+		if count, err = d.readCount(); err == nil {
+			if pairs, err = d.readStrings(count * 2); err == nil {
+				data = pairsToHash(pairs)
+			}
+		}
+
+	case rdbTypeHashMetadataPre, rdbTypeHashMetadata:
+		data, err = d.readHashMetadata(rdbType)
+
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		data, err = d.readQuicklist(rdbType)
+
+	case rdbTypeStream, rdbTypeStream2, rdbTypeStream3:
+		data, err = d.readStream(rdbType)
+
+	default:
+		data, err = d.readEncoded(rdbType)
+	}
+
+	return
+}
+
+func (d *rdbDecoder) readZSet(rdbType byte) (map[string]Score, error) {
+
+	count, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]Score, preallocation(count))
+	for i := 0; i < count; i++ {
+
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var score float64
+		if rdbType == rdbTypeZSet2 {
+
+			var bits uint64
+			bits, err = d.readUint64()
+			score = math.Float64frombits(bits)
+		} else {
+			score, err = d.readDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scores[member] = Score(score)
+	}
+
+	return scores, nil
+}
+
+// readHashMetadata reads a hash with field expirations (redis 7.4), which are
+// dropped.
+func (d *rdbDecoder) readHashMetadata(rdbType byte) (map[string]string, error) {
+
+	if rdbType == rdbTypeHashMetadata {
+
+		if _, err := d.readUint64(); err != nil {
+			return nil, err
+		}
+	}
+
+	count, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := make(map[string]string, preallocation(count))
+	for i := 0; i < count; i++ {
+
+		if rdbType == rdbTypeHashMetadata {
+			_, err = d.readCount()
+		} else {
+			_, err = d.readUint64()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pair, err := d.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		hash[pair[0]] = pair[1]
+	}
+
+	return hash, nil
+}
+
+func (d *rdbDecoder) readQuicklist(rdbType byte) ([]string, error) {
+
+	count, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	for i := 0; i < count; i++ {
+
+		// a quicklist 2 node is either a plain element (1) or a listpack (2)
+		container := 2
+		if rdbType == rdbTypeListQuicklist2 {
+
+			if container, err = d.readCount(); err != nil {
+				return nil, err
+			}
+		}
+
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []string
+		switch {
+		case container == 1:
+			entries = []string{blob}
+		case rdbType == rdbTypeListQuicklist:
+			entries, err = ziplistEntries([]byte(blob))
+		default:
+			entries, err = listpackEntries([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, entries...)
+	}
+
+	return items, nil
+}
+
+// readEncoded reads the types held in a single ziplist, listpack, intset or
+// zipmap blob.
+func (d *rdbDecoder) readEncoded(rdbType byte) (data interface{}, err error) {
+
+	if rdbType == rdbTypeHashListpackEx {
+
+		if _, err = d.readUint64(); err != nil {
+			return
+		}
+	}
+
+	text, err := d.readString()
+	if err != nil {
+		return
+	}
+
+	blob := []byte(text)
+	var entries []string
+	switch rdbType {
+	case rdbTypeHashZipmap:
+		entries, err = zipmapEntries(blob)
+	case rdbTypeSetIntset:
+		entries, err = intsetEntries(blob)
+	case rdbTypeListZiplist, rdbTypeZSetZiplist, rdbTypeHashZiplist:
+		entries, err = ziplistEntries(blob)
+	default:
+		entries, err = listpackEntries(blob)
+	}
+	if err != nil {
+		return
+	}
+
+	switch rdbType {
+	case rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeSetListpack:
+		return entries, nil
+
+	case rdbTypeZSetZiplist, rdbTypeZSetListpack:
+		return parseScores(entries)
+
+	case rdbTypeHashListpackPre, rdbTypeHashListpackEx:
+		// field, value and expiration triplets
+		pairs := make([]string, 0, len(entries)/3*2)
+		for i := 0; i+2 < len(entries); i += 3 {
+			pairs = append(pairs, entries[i], entries[i+1])
+		}
+		return pairsToHash(pairs), nil
+	}
+
+	return pairsToHash(entries), nil
+}
+
+// Stream listpack entry flags.
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// readStream reads the entries of a stream, its consumer groups are skipped.
+func (d *rdbDecoder) readStream(rdbType byte) ([]StreamEntry, error) {
+
+	count, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []StreamEntry
+	for i := 0; i < count; i++ {
+
+		nodeKey, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(nodeKey) != 16 {
+			return nil, fmt.Errorf("stream node key of %d bytes", len(nodeKey))
+		}
+
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		items, err := listpackEntries([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+
+		nodeEntries, err := streamNodeEntries(binary.BigEndian.Uint64([]byte(nodeKey[:8])), binary.BigEndian.Uint64([]byte(nodeKey[8:])), items)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, nodeEntries...)
+	}
+
+	// length and last id, then first id, max deleted id and entries added
+	lengths := 3
+	if rdbType != rdbTypeStream {
+		lengths += 5
+	}
+	if err = d.skipCounts(lengths); err != nil {
+		return nil, err
+	}
+
+	groups, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < groups; i++ {
+
+		if _, err = d.readString(); err != nil {
+			return nil, err
+		}
+
+		// last id, then entries read
+		lengths := 2
+		if rdbType != rdbTypeStream {
+			lengths++
+		}
+		if err = d.skipCounts(lengths); err != nil {
+			return nil, err
+		}
+
+		pending, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < pending; j++ {
+
+			// raw id and delivery time, then delivery count
+			if _, err = d.readFull(16 + 8); err != nil {
+				return nil, err
+			}
+			if _, _, err = d.readLength(); err != nil {
+				return nil, err
+			}
+		}
+
+		consumers, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < consumers; j++ {
+
+			if _, err = d.readString(); err != nil {
+				return nil, err
+			}
+
+			// seen time, then active time
+			times := uint64(8)
+			if rdbType == rdbTypeStream3 {
+				times += 8
+			}
+			if _, err = d.readFull(times); err != nil {
+				return nil, err
+			}
+
+			pending, err := d.readCount()
+			if err != nil {
+				return nil, err
+			}
+			if _, err = d.readFull(uint64(pending) * 16); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// skipCounts skips lengths, such as 64 bits stream ids.
+func (d *rdbDecoder) skipCounts(count int) error {
+
+	for i := 0; i < count; i++ {
+
+		if _, _, err := d.readLength(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// streamNodeEntries decodes the listpack of a stream node: the master entry
+// (count, deleted count, fields and a 0 terminator) then the entries, each
+// made of flags, id deltas, the fields and values, and its element count.
+func streamNodeEntries(masterMs, masterSeq uint64, items []string) (entries []StreamEntry, err error) {
+
+	position := 0
+	next := func() string {
+
+		if position >= len(items) {
+
+			if err == nil {
+				err = fmt.Errorf("stream listpack truncated")
+			}
+			return "0"
+		}
+		position++
+		return items[position-1]
+	}
+	nextInt := func() int64 {
+
+		value, parseErr := strconv.ParseInt(next(), 10, 64)
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("stream listpack integer expected, %s", parseErr)
+		}
+		return value
+	}
+
+	count := nextInt() + nextInt()
+	fields := nextInt()
+	if fields < 0 || fields > int64(len(items)) {
+		return nil, fmt.Errorf("stream listpack of %d master fields out of range", fields)
+	}
+
+	masterFields := make([]string, fields)
+	for i := range masterFields {
+		masterFields[i] = next()
+	}
+	next()
+
+	for i := int64(0); i < count && err == nil; i++ {
+
+		flags := nextInt()
+		ms := masterMs + uint64(nextInt())
+		seq := masterSeq + uint64(nextInt())
+
+		values := make(map[string]string)
+		if flags&streamItemSameFields != 0 {
+
+			for _, field := range masterFields {
+				values[field] = next()
+			}
+		} else {
+
+			fields := nextInt()
+			for j := int64(0); j < fields; j++ {
+				field := next()
+				values[field] = next()
+			}
+		}
+		next()
+
+		if flags&streamItemDeleted == 0 {
+			entries = append(entries, StreamEntry{ID: fmt.Sprintf("%d-%d", ms, seq), Values: values})
+		}
+	}
+
+	return
+}
+
+func pairsToHash(pairs []string) map[string]string {
+
+	hash := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		hash[pairs[i]] = pairs[i+1]
+	}
+
+	return hash
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(data []byte) int64 {
+
+	var value uint64
+	for i := len(data) - 1; i >= 0; i-- {
+		value = value<<8 | uint64(data[i])
+	}
+
+	shift := uint(64 - 8*len(data))
+	return int64(value<<shift) >> shift
+}
+
+// blobReader walks the bytes of a ziplist, listpack, intset or zipmap.
+type blobReader struct {
+	data     []byte
+	position int
+}
+
+func (r *blobReader) take(n int) ([]byte, error) {
+
+	if n < 0 || r.position+n > len(r.data) {
+		return nil, fmt.Errorf("encoded value truncated")
+	}
+
+	r.position += n
+	return r.data[r.position-n : r.position], nil
+}
+
+func (r *blobReader) peek() (byte, error) {
+
+	if r.position >= len(r.data) {
+		return 0, fmt.Errorf("encoded value truncated")
+	}
+
+	return r.data[r.position], nil
+}
+
+// ziplistEntries decodes a ziplist: a 10 bytes header, then entries made of
+// the previous entry length, an encoding and the data, and 0xff.
+func ziplistEntries(blob []byte) ([]string, error) {
+
+	reader := &blobReader{data: blob}
+	if _, err := reader.take(10); err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	for {
+
+		b, err := reader.peek()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0xff {
+			return entries, nil
+		}
+
+		previousLength := 1
+		if b == 0xfe {
+			previousLength = 5
+		}
+		if _, err = reader.take(previousLength); err != nil {
+			return nil, err
+		}
+
+		header, err := reader.take(1)
+		if err != nil {
+			return nil, err
+		}
+
+		encoding := header[0]
+		length, size := 0, 0
+		switch {
+		case encoding>>6 == 0:
+			length = int(encoding & 0x3f)
+		case encoding>>6 == 1:
+			next, err := reader.take(1)
+			if err != nil {
+				return nil, err
+			}
+			length = int(encoding&0x3f)<<8 | int(next[0])
+		case encoding>>6 == 2:
+			next, err := reader.take(4)
+			if err != nil {
+				return nil, err
+			}
+			length = int(binary.BigEndian.Uint32(next))
+		case encoding == 0xc0:
+			size = 2
+		case encoding == 0xd0:
+			size = 4
+		case encoding == 0xe0:
+			size = 8
+		case encoding == 0xf0:
+			size = 3
+		case encoding == 0xfe:
+			size = 1
+		case encoding >= 0xf1 && encoding <= 0xfd:
+			entries = append(entries, strconv.Itoa(int(encoding&0x0f)-1))
+			continue
+		default:
+			return nil, fmt.Errorf("unknown ziplist encoding 0x%x", encoding)
+		}
+
+		if size > 0 {
+
+			data, err := reader.take(size)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, strconv.FormatInt(littleEndianInt(data), 10))
+			continue
+		}
+
+		data, err := reader.take(length)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, string(data))
+	}
+}
+
+// listpackEntries decodes a listpack: a 6 bytes header, then entries made of
+// an encoding, the data and the entry length backwards, and 0xff.
+func listpackEntries(blob []byte) ([]string, error) {
+
+	reader := &blobReader{data: blob}
+	if _, err := reader.take(6); err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	for {
+
+		header, err := reader.take(1)
+		if err != nil {
+			return nil, err
+		}
+
+		encoding := header[0]
+		if encoding == 0xff {
+			return entries, nil
+		}
+
+		var (
+			entry string
+			size  int
+		)
+		switch {
+		case encoding&0x80 == 0:
+			entry, size = strconv.Itoa(int(encoding)), 1
+
+		case encoding&0xc0 == 0x80:
+			length := int(encoding & 0x3f)
+			data, err := reader.take(length)
+			if err != nil {
+				return nil, err
+			}
+			entry, size = string(data), 1+length
+
+		case encoding&0xe0 == 0xc0:
+			next, err := reader.take(1)
+			if err != nil {
+				return nil, err
+			}
+			value := int(encoding&0x1f)<<8 | int(next[0])
+			if value >= 1<<12 {
+				value -= 1 << 13
+			}
+			entry, size = strconv.Itoa(value), 2
+
+		case encoding&0xf0 == 0xe0:
+			next, err := reader.take(1)
+			if err != nil {
+				return nil, err
+			}
+			length := int(encoding&0x0f)<<8 | int(next[0])
+			data, err := reader.take(length)
+			if err != nil {
+				return nil, err
+			}
+			entry, size = string(data), 2+length
+
+		case encoding == 0xf0:
+			next, err := reader.take(4)
+			if err != nil {
+				return nil, err
+			}
+			length := int(binary.LittleEndian.Uint32(next))
+			data, err := reader.take(length)
+			if err != nil {
+				return nil, err
+			}
+			entry, size = string(data), 5+length
+
+		case encoding >= 0xf1 && encoding <= 0xf4:
+			length := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[encoding]
+			data, err := reader.take(length)
+			if err != nil {
+				return nil, err
+			}
+			entry, size = strconv.FormatInt(littleEndianInt(data), 10), 1+length
+
+		default:
+			return nil, fmt.Errorf("unknown listpack encoding 0x%x", encoding)
+		}
+
+		if _, err = reader.take(listpackBacklenSize(size)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func listpackBacklenSize(size int) int {
+
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+
+	return 5
+}
+
+// intsetEntries decodes an intset: the integer size, the count, then the
+// little endian integers.
+func intsetEntries(blob []byte) ([]string, error) {
+
+	reader := &blobReader{data: blob}
+	header, err := reader.take(8)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(binary.LittleEndian.Uint32(header[:4]))
+	count := int(binary.LittleEndian.Uint32(header[4:]))
+	if size != 2 && size != 4 && size != 8 {
+		return nil, fmt.Errorf("unknown intset encoding %d", size)
+	}
+
+	if count > (len(blob)-8)/size {
+		return nil, fmt.Errorf("intset of %d integers longer than its %d bytes", count, len(blob))
+	}
+
+	entries := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+
+		data, err := reader.take(size)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, strconv.FormatInt(littleEndianInt(data), 10))
+	}
+
+	return entries, nil
+}
+
+// zipmapEntries decodes a zipmap (redis before 2.6) into field value pairs.
+func zipmapEntries(blob []byte) ([]string, error) {
+
+	reader := &blobReader{data: blob}
+	if _, err := reader.take(1); err != nil {
+		return nil, err
+	}
+
+	readLength := func() (int, error) {
+
+		header, err := reader.take(1)
+		if err != nil {
+			return 0, err
+		}
+		if header[0] < 254 {
+			return int(header[0]), nil
+		}
+
+		data, err := reader.take(4)
+		if err != nil {
+			return 0, err
+		}
+		return int(binary.LittleEndian.Uint32(data)), nil
+	}
+
+	var entries []string
+	for {
+
+		b, err := reader.peek()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0xff {
+			return entries, nil
+		}
+
+		length, err := readLength()
+		if err != nil {
+			return nil, err
+		}
+		field, err := reader.take(length)
+		if err != nil {
+			return nil, err
+		}
+
+		if length, err = readLength(); err != nil {
+			return nil, err
+		}
+		free, err := reader.take(1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := reader.take(length)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = reader.take(int(free[0])); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, string(field), string(value))
+	}
+}
+
+// lzfDecompress expands an LZF compressed string of length bytes.
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+
+	out := make([]byte, 0, preallocation(length))
+	for position := 0; position < len(in) && len(out) <= length; {
+
+		control := int(in[position])
+		position++
+
+		if control < 1<<5 {
+
+			literal := control + 1
+			if position+literal > len(in) {
+				return nil, fmt.Errorf("LZF literal truncated")
+			}
+			out = append(out, in[position:position+literal]...)
+			position += literal
+			continue
+		}
+
+		backLength := control >> 5
+		if backLength == 7 {
+
+			if position >= len(in) {
+				return nil, fmt.Errorf("LZF back reference truncated")
+			}
+			backLength += int(in[position])
+			position++
+		}
+
+		if position >= len(in) {
+			return nil, fmt.Errorf("LZF back reference truncated")
+		}
+		reference := len(out) - (control&0x1f)<<8 - int(in[position]) - 1
+		position++
+		if reference < 0 {
+			return nil, fmt.Errorf("LZF back reference out of range")
+		}
+
+		for i := 0; i < backLength+2; i++ {
+			out = append(out, out[reference+i])
+		}
+	}
+
+	if len(out) != length {
+		return nil, fmt.Errorf("LZF data of %d bytes, %d expected", len(out), length)
+	}
+
+	return out, nil
+}
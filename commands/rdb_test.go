@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPayload seals a type byte and its encoded value as a DUMP payload.
+func testPayload(rdbType byte, value ...[]byte) string {
+
+	buffer := bytes.NewBuffer([]byte{rdbType})
+	for _, part := range value {
+		buffer.Write(part)
+	}
+
+	return sealPayload(buffer.Bytes(), 9)
+}
+
+// rdbString encodes a blob as an RDB string.
+func rdbString(blob []byte) []byte {
+
+	var buffer bytes.Buffer
+	writeRDBString(&buffer, string(blob))
+	return buffer.Bytes()
+}
+
+// testListpack builds a listpack of short strings.
+func testListpack(items ...string) []byte {
+
+	blob := make([]byte, 6)
+	for _, item := range items {
+
+		blob = append(blob, 0x80|byte(len(item)))
+		blob = append(blob, item...)
+		blob = append(blob, byte(1+len(item)))
+	}
+	blob = append(blob, 0xff)
+
+	binary.LittleEndian.PutUint32(blob, uint32(len(blob)))
+	binary.LittleEndian.PutUint16(blob[4:], uint16(len(items)))
+	return blob
+}
+
+func TestEncodePayload(t *testing.T) {
+
+	cases := []struct {
+		keyType string
+		data    interface{}
+	}{
+		{TypeString, "value"},
+		{TypeString, ""},
+		{TypeString, "\x00\xff binary"},
+		{TypeHash, map[string]string{"field": "value", "empty": ""}},
+		{TypeList, []string{"b", "a", "b"}},
+		{TypeSet, []string{"a", "b"}},
+		{TypeZSet, map[string]Score{"a": 1.5, "b": -2, "min": Score(math.Inf(-1)), "max": Score(math.Inf(1))}},
+	}
+
+	for _, c := range cases {
+
+		payload, err := EncodePayload(c.keyType, c.data)
+		assert.NoError(t, err, c.keyType)
+
+		keyType, data, err := DecodePayload(payload)
+		assert.NoError(t, err, c.keyType)
+		assert.Equal(t, c.keyType, keyType)
+		assert.Equal(t, c.data, data)
+	}
+
+	_, err := EncodePayload(TypeStream, []StreamEntry{{ID: "1-0", Values: map[string]string{"f": "v"}}})
+	assert.Error(t, err)
+}
+
+func TestDecodePayload(t *testing.T) {
+
+	// the DUMP sample of the redis documentation
+	keyType, data, err := DecodePayload("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n")
+	assert.NoError(t, err)
+	assert.Equal(t, TypeString, keyType)
+	assert.Equal(t, "10", data)
+
+	ziplist := append(make([]byte, 10), 0x00, 0x02, 'h', 'i', 0x04, 0xfe, 0xfb, 0x03, 0xf3, 0xff)
+	listpack := []byte{0, 0, 0, 0, 0, 0, 0x05, 0x01, 0x82, 'a', 'b', 0x03, 0xdf, 0xfe, 0x02, 0xff}
+	intset := []byte{2, 0, 0, 0, 3, 0, 0, 0, 0x01, 0x00, 0xfe, 0xff, 0x2c, 0x01}
+
+	streamNode := make([]byte, 16)
+	binary.BigEndian.PutUint64(streamNode, 1000)
+	stream := testListpack(
+		"2", "1", "1", "f", "0",
+		"2", "0", "0", "v1", "4",
+		"0", "5", "1", "2", "a", "1", "b", "2", "9",
+		"3", "6", "0", "v2", "4",
+	)
+
+	cases := []struct {
+		name    string
+		payload string
+		keyType string
+		data    interface{}
+	}{
+		{"LZF", testPayload(rdbTypeString, []byte{0xc3, 0x06, 0x08, 0x02, 'a', 'b', 'c', 0x60, 0x02}), TypeString, "abcabcab"},
+		{"int16", testPayload(rdbTypeString, []byte{0xc1, 0x2c, 0x01}), TypeString, "300"},
+		{"ziplist", testPayload(rdbTypeListZiplist, rdbString(ziplist)), TypeList, []string{"hi", "-5", "2"}},
+		{"listpack", testPayload(rdbTypeSetListpack, rdbString(listpack)), TypeSet, []string{"5", "ab", "-2"}},
+		{"intset", testPayload(rdbTypeSetIntset, rdbString(intset)), TypeSet, []string{"1", "-2", "300"}},
+		{"hash listpack", testPayload(rdbTypeHashListpack, rdbString(testListpack("f", "v", "g", "w"))), TypeHash, map[string]string{"f": "v", "g": "w"}},
+		{"zset listpack", testPayload(rdbTypeZSetListpack, rdbString(testListpack("a", "1", "b", "2.5"))), TypeZSet, map[string]Score{"a": 1, "b": 2.5}},
+		{"quicklist2", testPayload(rdbTypeListQuicklist2, []byte{0x02, 0x02}, rdbString(listpack), []byte{0x01}, rdbString([]byte("plain"))), TypeList, []string{"5", "ab", "-2", "plain"}},
+		{"stream", testPayload(rdbTypeStream, []byte{0x01}, rdbString(streamNode), rdbString(stream), []byte{0x02, 0x00, 0x00, 0x00}), TypeStream, []StreamEntry{
+			{ID: "1000-0", Values: map[string]string{"f": "v1"}},
+			{ID: "1005-1", Values: map[string]string{"a": "1", "b": "2"}},
+		}},
+	}
+
+	for _, c := range cases {
+
+		keyType, data, err := DecodePayload(c.payload)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.keyType, keyType, c.name)
+		assert.Equal(t, c.data, data, c.name)
+	}
+}
+
+func TestDecodePayload_Malformed(t *testing.T) {
+
+	valid, _ := EncodePayload(TypeString, "value")
+	corrupt := []byte(valid)
+	corrupt[3] ^= 0xff
+
+	cases := []struct {
+		name    string
+		payload string
+	}{
+		{"short", "\x00\x01"},
+		{"checksum", string(corrupt)},
+		{"truncated string", testPayload(rdbTypeString, []byte{0x05, 'a'})},
+		{"64 bits count", testPayload(rdbTypeList, []byte{0x81, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})},
+		{"32 bits count", testPayload(rdbTypeSet, []byte{0x80, 0x7f, 0xff, 0xff, 0xff, 0x01, 'a'})},
+		{"32 bits zset count", testPayload(rdbTypeZSet2, []byte{0x80, 0x7f, 0xff, 0xff, 0xff})},
+		{"32 bits hash count", testPayload(rdbTypeHash, []byte{0x80, 0x7f, 0xff, 0xff, 0xff})},
+		{"32 bits string length", testPayload(rdbTypeString, []byte{0x80, 0x3f, 0xff, 0xff, 0xff, 'a'})},
+		{"intset count", testPayload(rdbTypeSetIntset, rdbString([]byte{2, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f, 0x01, 0x00}))},
+		{"LZF length", testPayload(rdbTypeString, []byte{0xc3, 0x06, 0x80, 0x3f, 0xff, 0xff, 0xff, 0x02, 'a', 'b', 'c', 0x60, 0x02})},
+		{"LZF reference", testPayload(rdbTypeString, []byte{0xc3, 0x02, 0x08, 0x60, 0x02})},
+		{"stream master fields", testPayload(rdbTypeStream, []byte{0x01}, rdbString(make([]byte, 16)), rdbString(testListpack("1", "0", "-1")))},
+		{"unknown type", testPayload(0x30)},
+	}
+
+	for _, c := range cases {
+
+		assert.NotPanics(t, func() {
+			_, _, err := DecodePayload(c.payload)
+			assert.Error(t, err, c.name)
+		}, c.name)
+	}
+}
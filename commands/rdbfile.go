@@ -0,0 +1,330 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+const FormatRDB = "rdb"
+
+// RDB file opcodes, the other bytes opening an entry are value types.
+const (
+	rdbOpSlotInfo     = 0xf4
+	rdbOpFunction2    = 0xf5
+	rdbOpFunctionPre  = 0xf6
+	rdbOpModuleAux    = 0xf7
+	rdbOpIdle         = 0xf8
+	rdbOpFreq         = 0xf9
+	rdbOpAux          = 0xfa
+	rdbOpResizeDB     = 0xfb
+	rdbOpExpireTimeMs = 0xfc
+	rdbOpExpireTime   = 0xfd
+	rdbOpSelectDB     = 0xfe
+	rdbOpEOF          = 0xff
+)
+
+// rdbFileVersion is the lowest RDB version of the files written, the first
+// one where the DUMP payloads of redis 4 to 7 may be used as is.
+const rdbFileVersion = 9
+
+// ReadRDBFile calls read with a record per key of an RDB file, in file order,
+// the DUMP payload of each key being rebuilt from its RDB encoding. Keys
+// already expired are left out, functions and module auxiliary data are not
+// supported. An AOF with an RDB preamble is refused, it is read as RESP.
+func ReadRDBFile(ctx context.Context, path string, read func(record *Record) error) error {
+
+	fp, err := os.Open(path)
+	if err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("Open data file error, %s", err)}
+	}
+	defer fp.Close()
+
+	reader := bufio.NewReaderSize(fp, 1<<20)
+	if err = readRDB(ctx, path, reader, read); err != nil {
+		return err
+	}
+
+	_, err = reader.Peek(1)
+	switch err {
+	case io.EOF:
+		return nil
+	case nil:
+		return &ConfigError{Err: fmt.Errorf("%s holds commands after its RDB data, an AOF with an RDB preamble: read it with -input-format=resp", path)}
+	}
+
+	return &ConfigError{Err: fmt.Errorf("Read RDB file %s error, %s", path, err)}
+}
+
+// readRDB reads RDB data up to its checksum, which reader is left after.
+func readRDB(ctx context.Context, path string, reader *bufio.Reader, read func(record *Record) error) error {
+
+	decoder := &rdbDecoder{source: reader}
+	version, err := readRDBHeader(decoder)
+	if err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("%s, %s", path, err)}
+	}
+
+	var dbId uint64
+	var expireAt int64
+	now := unixMillis(time.Now())
+	for {
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		opcode, err := decoder.readByte()
+		if err != nil {
+
+			return &ConfigError{Err: fmt.Errorf("Read RDB file %s error, %s", path, err)}
+		}
+
+		switch opcode {
+		case rdbOpEOF:
+			// the checksum, since RDB version 5
+			if version >= 5 {
+				_, err = decoder.readFull(8)
+			}
+
+		case rdbOpSelectDB:
+			var length uint64
+			length, _, err = decoder.readLength()
+			dbId = length
+
+		case rdbOpExpireTime:
+			var data []byte
+			if data, err = decoder.readFull(4); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint32(data)) * 1000
+			}
+
+		case rdbOpExpireTimeMs:
+			var millis uint64
+			millis, err = decoder.readUint64()
+			expireAt = int64(millis)
+
+		case rdbOpResizeDB:
+			err = decoder.skipCounts(2)
+
+		case rdbOpSlotInfo:
+			err = decoder.skipCounts(3)
+
+		case rdbOpAux:
+			_, err = decoder.readStrings(2)
+
+		case rdbOpFreq:
+			_, err = decoder.readByte()
+
+		case rdbOpIdle:
+			_, _, err = decoder.readLength()
+
+		case rdbOpFunction2:
+			_, err = decoder.readString()
+
+		case rdbOpFunctionPre, rdbOpModuleAux:
+			return &ConfigError{Err: fmt.Errorf("%s holds module or function data, which cannot be read", path)}
+
+		default:
+			record, err := readRDBKey(decoder, opcode, version)
+			if err != nil {
+
+				return &ConfigError{Err: fmt.Errorf("Read RDB file %s error, %s", path, err)}
+			}
+
+			if expireAt == 0 || expireAt > now {
+
+				record.DatabaseId = dbId
+				if expireAt != 0 {
+					record.TTL = (expireAt - now + 999) / 1000
+				}
+				if err = read(record); err != nil {
+					return err
+				}
+			}
+			expireAt = 0
+			continue
+		}
+
+		if err != nil {
+
+			return &ConfigError{Err: fmt.Errorf("Read RDB file %s error, %s", path, err)}
+		}
+
+		if opcode == rdbOpEOF {
+			return nil
+		}
+	}
+}
+
+func readRDBHeader(decoder *rdbDecoder) (uint16, error) {
+
+	header, err := decoder.readFull(9)
+	if err != nil || string(header[:5]) != "REDIS" {
+		return 0, fmt.Errorf("not an RDB file")
+	}
+
+	version, err := strconv.ParseUint(string(header[5:]), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid RDB version %q", header[5:])
+	}
+
+	return uint16(version), nil
+}
+
+// readRDBKey reads a key and its value, the bytes of the value making up its
+// DUMP payload with the version of the file.
+func readRDBKey(decoder *rdbDecoder, rdbType byte, version uint16) (*Record, error) {
+
+	key, err := decoder.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	decoder.capture = bytes.NewBuffer([]byte{rdbType})
+	_, _, err = decoder.readValue(rdbType)
+	value := decoder.capture.Bytes()
+	decoder.capture = nil
+	if err != nil {
+		return nil, fmt.Errorf("key \"%s\", %s", key, err)
+	}
+
+	return &Record{Key: key, Value: sealPayload(value, version), TTL: -1}, nil
+}
+
+// RDBWriter writes records to an RDB file, their DUMP payloads holding the
+// RDB encoding of the values. Version is written in the header, it must be
+// at least the version of every payload.
+type RDBWriter struct {
+	Path    string
+	Version uint16
+	Count   uint64
+	stream  *os.File
+	writer  *bufio.Writer
+	crc     uint64
+	dbId    int64
+	err     error
+}
+
+func NewRDBWriter(path string, version uint16) (*RDBWriter, error) {
+
+	stream, err := newStream(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < rdbFileVersion {
+		version = rdbFileVersion
+	}
+
+	w := &RDBWriter{Path: path, Version: version, stream: stream, writer: bufio.NewWriterSize(stream, 64*1024), dbId: -1}
+	w.write([]byte(fmt.Sprintf("REDIS%04d", version)))
+	return w, w.err
+}
+
+func (w *RDBWriter) write(data []byte) {
+
+	if w.err != nil {
+		return
+	}
+
+	w.crc = lib.CRC64(w.crc, data)
+	if _, err := w.writer.Write(data); err != nil {
+
+		w.err = fmt.Errorf("Write file %s error, %s", w.Path, err)
+	}
+}
+
+// Write writes a DUMP payload record, multi-part and deleted records cannot
+// be written.
+func (w *RDBWriter) Write(record *Record) error {
+
+	keyType, _ := PayloadType(record.Value)
+	switch {
+	case record.Deleted:
+		return fmt.Errorf("deleted key \"%s\" cannot be written to an RDB file", record.Key)
+	case record.Type != "" || record.More:
+		return fmt.Errorf("key \"%s\" has no DUMP payload to write to an RDB file", record.Key)
+	case keyType == "":
+		return fmt.Errorf("invalid DUMP payload of key \"%s\"", record.Key)
+	}
+
+	if version := payloadVersion(record.Value); version > w.Version {
+		return fmt.Errorf("key \"%s\" has an RDB version %d payload, newer than the file version %d", record.Key, version, w.Version)
+	}
+
+	var buffer bytes.Buffer
+	if int64(record.DatabaseId) != w.dbId {
+
+		buffer.WriteByte(rdbOpSelectDB)
+		writeRDBLength(&buffer, record.DatabaseId)
+		w.dbId = int64(record.DatabaseId)
+	}
+
+	if record.TTL > 0 {
+
+		buffer.WriteByte(rdbOpExpireTimeMs)
+		binary.Write(&buffer, binary.LittleEndian, uint64(unixMillis(time.Now().Add(time.Duration(record.TTL)*time.Second))))
+	}
+
+	buffer.WriteByte(record.Value[0])
+	writeRDBString(&buffer, record.Key)
+	buffer.WriteString(record.Value[1 : len(record.Value)-10])
+
+	w.write(buffer.Bytes())
+	if w.err == nil {
+		w.Count++
+	}
+
+	return w.err
+}
+
+// Close writes the end of file and the checksum of the whole file.
+func (w *RDBWriter) Close() error {
+
+	w.write([]byte{rdbOpEOF})
+	crc := make([]byte, 8)
+	binary.LittleEndian.PutUint64(crc, w.crc)
+	if w.err == nil {
+
+		if _, err := w.writer.Write(crc); err != nil {
+			w.err = err
+		}
+	}
+
+	err := w.writer.Flush()
+	if closeErr := w.stream.Close(); err == nil {
+		err = closeErr
+	}
+	if w.err != nil {
+		err = w.err
+	}
+
+	return err
+}
+
+// isRDBFile tells whether the file is an RDB file from its header, and
+// rewinds it.
+func isRDBFile(file *os.File) (bool, error) {
+
+	header := make([]byte, 5)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	return n == 5 && string(header) == "REDIS", nil
+}
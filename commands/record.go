@@ -1,8 +1,136 @@
 package commands
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	FormatJSON        = "json"
+	FormatJSONLogical = "json-logical"
+)
+
+// Record is one line of a dump file. Value is a DUMP payload, or when Type is
+// set the logical value of the key (see logical.go). A big key is split into
+// several records numbered by Part from 1, all but the last one having More.
+// A Deleted record, written by diff, removes Key and has no value. Hash is the
+// payload hash of the key, on its last record, used by incremental dumps.
 type Record struct {
 	DatabaseId uint64 `json:"db"`
 	Key        string `json:"key"`
 	Value      string `json:"value"`
 	TTL        int64  `json:"ttl"`
+	Type       string `json:"type,omitempty"`
+	Part       uint64 `json:"part,omitempty"`
+	More       bool   `json:"more,omitempty"`
+	Deleted    bool   `json:"deleted,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// encodedRecord is the file form of a Record: a DUMP payload is kept as a
+// base64 string, a logical value as plain JSON so it can be read and edited.
+type encodedRecord struct {
+	DatabaseId uint64          `json:"db"`
+	Key        string          `json:"key"`
+	Value      json.RawMessage `json:"value"`
+	TTL        int64           `json:"ttl"`
+	Type       string          `json:"type,omitempty"`
+	Part       uint64          `json:"part,omitempty"`
+	More       bool            `json:"more,omitempty"`
+	Deleted    bool            `json:"deleted,omitempty"`
+	Hash       string          `json:"hash,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// IsValueless tells whether the record carries no value to restore, a key
+// which failed to dump as written in the dead-letter file.
+func (record *Record) IsValueless() bool {
+
+	return !record.Deleted && record.Value == ""
+}
+
+func (record *Record) Marshal() ([]byte, error) {
+
+	encoded := encodedRecord{
+		DatabaseId: record.DatabaseId,
+		Key:        record.Key,
+		TTL:        record.TTL,
+		Type:       record.Type,
+		Part:       record.Part,
+		More:       record.More,
+		Deleted:    record.Deleted,
+		Hash:       record.Hash,
+		Error:      record.Error,
+	}
+
+	value, err := record.valueJSON()
+	if err != nil {
+		return nil, err
+	}
+	encoded.Value = value
+
+	return json.Marshal(&encoded)
+}
+
+// valueJSON is the value as written in the file.
+func (record *Record) valueJSON() (json.RawMessage, error) {
+
+	switch {
+	case record.Type == "":
+		return json.Marshal(base64.StdEncoding.EncodeToString([]byte(record.Value)))
+	case record.Value == "":
+		return json.RawMessage("null"), nil
+	}
+
+	return json.RawMessage(record.Value), nil
+}
+
+func UnmarshalRecord(jsonString string) (record *Record, err error) {
+
+	encoded := encodedRecord{}
+	err = json.Unmarshal([]byte(jsonString), &encoded)
+	if err != nil {
+
+		return nil, fmt.Errorf("Unmarshal %s error , %s", jsonString, err)
+	}
+
+	record = &Record{
+		DatabaseId: encoded.DatabaseId,
+		Key:        encoded.Key,
+		TTL:        encoded.TTL,
+		Type:       encoded.Type,
+		Part:       encoded.Part,
+		More:       encoded.More,
+		Deleted:    encoded.Deleted,
+		Hash:       encoded.Hash,
+		Error:      encoded.Error,
+	}
+
+	if record.Type != "" {
+
+		if string(encoded.Value) != "null" {
+			record.Value = string(encoded.Value)
+		}
+		return
+	}
+
+	var value string
+	if len(encoded.Value) > 0 {
+
+		if err = json.Unmarshal(encoded.Value, &value); err != nil {
+
+			return nil, fmt.Errorf("Unmarshal %s error , %s", jsonString, err)
+		}
+	}
+
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+
+		return nil, fmt.Errorf("base64 decode %s error , %s", value, err)
+	}
+
+	record.Value = string(b)
+	return
 }
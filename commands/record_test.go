@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord_Marshal(t *testing.T) {
+
+	payload, _ := EncodePayload(TypeString, "value")
+	cases := []struct {
+		record *Record
+		json   string
+	}{
+		{
+			&Record{DatabaseId: 1, Key: "k", Value: "\x00\x01", TTL: -1},
+			`{"db":1,"key":"k","value":"AAE=","ttl":-1}`,
+		},
+		{
+			&Record{Key: "k", Value: payload, TTL: 60, Hash: "abc"},
+			`{"db":0,"key":"k","value":"AAV2YWx1ZQgAONsth2izEVY=","ttl":60,"hash":"abc"}`,
+		},
+		{
+			&Record{Key: "h", Type: TypeHash, Value: `{"f":"v"}`, TTL: -1, Part: 1, More: true},
+			`{"db":0,"key":"h","value":{"f":"v"},"ttl":-1,"type":"hash","part":1,"more":true}`,
+		},
+		{
+			&Record{DatabaseId: 2, Key: "gone", Deleted: true},
+			`{"db":2,"key":"gone","value":"","ttl":0,"deleted":true}`,
+		},
+		{
+			&Record{Key: "failed", Type: TypeString, Error: "timeout"},
+			`{"db":0,"key":"failed","value":null,"ttl":0,"type":"string","error":"timeout"}`,
+		},
+	}
+
+	for _, c := range cases {
+
+		data, err := c.record.Marshal()
+		assert.NoError(t, err)
+		assert.Equal(t, c.json, string(data))
+
+		record, err := UnmarshalRecord(string(data))
+		assert.NoError(t, err)
+		assert.Equal(t, c.record, record)
+	}
+
+	assert.True(t, (&Record{Key: "failed", Error: "timeout"}).IsValueless())
+	assert.True(t, (&Record{Key: "failed", Type: TypeString}).IsValueless())
+	assert.False(t, (&Record{Key: "k", Type: TypeString, Value: `""`}).IsValueless())
+	assert.False(t, (&Record{Key: "gone", Deleted: true}).IsValueless())
+
+	for _, line := range []string{`{"key":`, `{"key":"k","value":"not base64"}`, `{"key":"k","value":1}`} {
+
+		_, err := UnmarshalRecord(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestLogicalValue(t *testing.T) {
+
+	cases := []struct {
+		keyType string
+		data    interface{}
+		value   string
+	}{
+		{TypeString, "text", `"text"`},
+		{TypeString, "\xff\xfe", `"base64://4="`},
+		{TypeString, "base64:text", `"base64:YmFzZTY0OnRleHQ="`},
+		{TypeHash, map[string]string{"\x80": "base64:"}, `{"base64:gA==":"base64:YmFzZTY0Og=="}`},
+		{TypeList, []string{"a", "\xc3"}, `["a","base64:ww=="]`},
+		{TypeZSet, map[string]Score{"a": 1.5, "b": Score(math.Inf(1))}, `{"a":1.5,"b":"+Inf"}`},
+		{TypeStream, []StreamEntry{{ID: "1-0", Values: map[string]string{"f": "\xff"}}}, `[{"id":"1-0","values":{"f":"base64:/w=="}}]`},
+	}
+
+	for _, c := range cases {
+
+		value, err := encodeLogical(c.data)
+		assert.NoError(t, err)
+		assert.Equal(t, c.value, value)
+
+		data, err := decodeLogical(c.keyType, value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.data, data)
+
+		record := &Record{Key: "k", Type: c.keyType, Value: value}
+		line, err := record.Marshal()
+		assert.NoError(t, err)
+		decoded, err := UnmarshalRecord(string(line))
+		assert.NoError(t, err)
+		assert.Equal(t, record, decoded)
+	}
+
+	_, err := decodeLogical(TypeString, `"base64:!"`)
+	assert.Error(t, err)
+
+	_, err = decodeLogical("module", `""`)
+	assert.Error(t, err)
+}
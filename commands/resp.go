@@ -0,0 +1,239 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const FormatRESP = "resp"
+
+// RESP encodes the commands recreating the record, as written in an AOF or
+// piped into redis-cli --pipe: RESTORE ... REPLACE for a DUMP payload, DEL
+// then native commands for a logical value, and PEXPIREAT with the expiration
+// time computed from now. A Deleted record is a single DEL.
+func (record *Record) RESP(now time.Time) ([]byte, error) {
+
+	var buffer bytes.Buffer
+	if record.Deleted {
+
+		writeRESP(&buffer, "DEL", record.Key)
+		return buffer.Bytes(), nil
+	}
+
+	if record.Type == "" {
+
+		writeRESP(&buffer, "RESTORE", record.Key, "0", record.Value, "REPLACE")
+	} else {
+
+		commands, err := logicalCommands(record.Key, record.Type, record.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if record.Part <= 1 {
+			writeRESP(&buffer, "DEL", record.Key)
+		}
+
+		for _, command := range commands {
+			writeRESP(&buffer, command...)
+		}
+	}
+
+	if !record.More && record.TTL > 0 {
+
+		expireAt := now.Add(time.Duration(record.TTL)*time.Second).UnixNano() / int64(time.Millisecond)
+		writeRESP(&buffer, "PEXPIREAT", record.Key, strconv.FormatInt(expireAt, 10))
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func selectRESP(dbId uint64) []byte {
+
+	var buffer bytes.Buffer
+	writeRESP(&buffer, "SELECT", strconv.FormatUint(dbId, 10))
+	return buffer.Bytes()
+}
+
+func writeRESP(buffer *bytes.Buffer, args ...string) {
+
+	fmt.Fprintf(buffer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(buffer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+func logicalCommands(key, keyType, value string) (commands [][]string, err error) {
+
+	data, err := decodeLogical(keyType, value)
+	if err != nil {
+		return
+	}
+
+	switch data := data.(type) {
+	case string:
+		commands = append(commands, []string{"APPEND", key, data})
+
+	case map[string]string:
+		if len(data) > 0 {
+			command := []string{"HMSET", key}
+			for field, value := range data {
+				command = append(command, field, value)
+			}
+			commands = append(commands, command)
+		}
+
+	case []string:
+		if len(data) > 0 {
+			command := []string{"SADD", key}
+			if keyType == TypeList {
+				command[0] = "RPUSH"
+			}
+			commands = append(commands, append(command, data...))
+		}
+
+	case map[string]Score:
+		if len(data) > 0 {
+			command := []string{"ZADD", key}
+			for member, score := range data {
+				command = append(command, strconv.FormatFloat(float64(score), 'g', -1, 64), member)
+			}
+			commands = append(commands, command)
+		}
+
+	case []StreamEntry:
+		for _, entry := range data {
+
+			command := []string{"XADD", key, entry.ID}
+			for field, value := range entry.Values {
+				command = append(command, field, value)
+			}
+			commands = append(commands, command)
+		}
+	}
+
+	return
+}
+
+// RESPReader reads the commands of an AOF or RESP file, inline commands
+// (space separated words on a line) are accepted as well.
+type RESPReader struct {
+	reader *bufio.Reader
+}
+
+func NewRESPReader(reader io.Reader) *RESPReader {
+
+	return &RESPReader{reader: bufio.NewReaderSize(reader, 64*1024)}
+}
+
+// ReadCommand returns the next command, io.EOF once the file is read.
+func (r *RESPReader) ReadCommand() ([]string, error) {
+
+	for {
+
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if line[0] != '*' {
+			return strings.Fields(line), nil
+		}
+
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid RESP array header %q", line)
+		}
+
+		args := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+
+			arg, err := r.readBulk()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+func (r *RESPReader) readBulk() (string, error) {
+
+	line, err := r.readLine()
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+
+	if line == "" || line[0] != '$' {
+		return "", fmt.Errorf("invalid RESP bulk header %q", line)
+	}
+
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return "", fmt.Errorf("invalid RESP bulk header %q", line)
+	}
+
+	bulk := make([]byte, size+2)
+	if _, err = io.ReadFull(r.reader, bulk); err != nil {
+		return "", unexpectedEOF(err)
+	}
+
+	if bulk[size] != '\r' || bulk[size+1] != '\n' {
+		return "", fmt.Errorf("RESP bulk of %d bytes not followed by CRLF", size)
+	}
+
+	return string(bulk[:size]), nil
+}
+
+func (r *RESPReader) readLine() (string, error) {
+
+	line, err := r.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func unexpectedEOF(err error) error {
+
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// isRESPFile tells whether the file holds RESP commands rather than JSON
+// records, from its first byte, and rewinds it.
+func isRESPFile(file *os.File) (bool, error) {
+
+	first := make([]byte, 1)
+	n, err := file.Read(first)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	return n == 1 && first[0] == '*', nil
+}
@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRESPReader(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		input    string
+		commands [][]string
+	}{
+		{"array", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", [][]string{{"GET", "k"}}},
+		{"binary bulk", "*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n", [][]string{{"ECHO", "a\r\nb"}}},
+		{"empty bulk", "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n", [][]string{{"SET", "k", ""}}},
+		{"inline", "PING\r\nSET k  v\n", [][]string{{"PING"}, {"SET", "k", "v"}}},
+		{"empty lines and arrays", "\r\n*0\r\n\r\n*1\r\n$4\r\nPING\r\n", [][]string{{"PING"}}},
+		{"no final newline", "*1\r\n$4\r\nPING\r\nPING", [][]string{{"PING"}, {"PING"}}},
+		{"empty", "", nil},
+	}
+
+	for _, c := range cases {
+
+		reader := NewRESPReader(strings.NewReader(c.input))
+		var commands [][]string
+		for {
+
+			args, err := reader.ReadCommand()
+			if err != nil {
+
+				assert.Equal(t, "EOF", err.Error(), c.name)
+				break
+			}
+			commands = append(commands, args)
+		}
+		assert.Equal(t, c.commands, commands, c.name)
+	}
+
+	for _, input := range []string{
+		"*x\r\n",
+		"*1\r\n+OK\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n$5\r\nab\r\n",
+		"*1\r\n$2\r\nabcd\r\n",
+		"*2\r\n$1\r\na\r\n",
+	} {
+
+		_, err := NewRESPReader(strings.NewReader(input)).ReadCommand()
+		assert.Error(t, err, "%q", input)
+		assert.NotEqual(t, "EOF", err.Error(), "%q", input)
+	}
+}
+
+func TestRecord_RESP(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+	payload, _ := EncodePayload(TypeString, "v")
+	cases := []struct {
+		name   string
+		record *Record
+		resp   string
+	}{
+		{
+			"payload",
+			&Record{Key: "k", Value: payload, TTL: -1},
+			"*5\r\n$7\r\nRESTORE\r\n$1\r\nk\r\n$1\r\n0\r\n$" + strconv.Itoa(len(payload)) + "\r\n" + payload + "\r\n$7\r\nREPLACE\r\n",
+		},
+		{
+			"payload with TTL",
+			&Record{Key: "k", Value: payload, TTL: 10},
+			"*5\r\n$7\r\nRESTORE\r\n$1\r\nk\r\n$1\r\n0\r\n$" + strconv.Itoa(len(payload)) + "\r\n" + payload + "\r\n$7\r\nREPLACE\r\n" +
+				"*3\r\n$9\r\nPEXPIREAT\r\n$1\r\nk\r\n$7\r\n1010000\r\n",
+		},
+		{
+			"string",
+			logicalRecord(0, "s", "text", -1),
+			"*2\r\n$3\r\nDEL\r\n$1\r\ns\r\n*3\r\n$6\r\nAPPEND\r\n$1\r\ns\r\n$4\r\ntext\r\n",
+		},
+		{
+			"hash",
+			logicalRecord(0, "h", map[string]string{"f": "v"}, -1),
+			"*2\r\n$3\r\nDEL\r\n$1\r\nh\r\n*4\r\n$5\r\nHMSET\r\n$1\r\nh\r\n$1\r\nf\r\n$1\r\nv\r\n",
+		},
+		{
+			"list",
+			logicalRecord(0, "l", []string{"a", "b"}, -1),
+			"*2\r\n$3\r\nDEL\r\n$1\r\nl\r\n*4\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n$1\r\na\r\n$1\r\nb\r\n",
+		},
+		{
+			"set",
+			&Record{Key: "s", Type: TypeSet, Value: `["a"]`, TTL: -1},
+			"*2\r\n$3\r\nDEL\r\n$1\r\ns\r\n*3\r\n$4\r\nSADD\r\n$1\r\ns\r\n$1\r\na\r\n",
+		},
+		{
+			"zset",
+			logicalRecord(0, "z", map[string]Score{"m": 2.5}, -1),
+			"*2\r\n$3\r\nDEL\r\n$1\r\nz\r\n*4\r\n$4\r\nZADD\r\n$1\r\nz\r\n$3\r\n2.5\r\n$1\r\nm\r\n",
+		},
+		{
+			"stream",
+			logicalRecord(0, "x", []StreamEntry{{ID: "1-1", Values: map[string]string{"f": "v"}}}, -1),
+			"*2\r\n$3\r\nDEL\r\n$1\r\nx\r\n*5\r\n$4\r\nXADD\r\n$1\r\nx\r\n$3\r\n1-1\r\n$1\r\nf\r\n$1\r\nv\r\n",
+		},
+		{
+			"first part",
+			&Record{Key: "l", Type: TypeList, Value: `["a"]`, TTL: 10, Part: 1, More: true},
+			"*2\r\n$3\r\nDEL\r\n$1\r\nl\r\n*3\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n$1\r\na\r\n",
+		},
+		{
+			"last part",
+			&Record{Key: "l", Type: TypeList, Value: `["b"]`, TTL: 10, Part: 2},
+			"*3\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n$1\r\nb\r\n*3\r\n$9\r\nPEXPIREAT\r\n$1\r\nl\r\n$7\r\n1010000\r\n",
+		},
+		{
+			"deleted",
+			&Record{Key: "k", Deleted: true},
+			"*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n",
+		},
+	}
+
+	for _, c := range cases {
+
+		data, err := c.record.RESP(now)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.resp, string(data), c.name)
+	}
+
+	_, err := (&Record{Key: "k", Type: TypeHash, Value: `[]`}).RESP(now)
+	assert.Error(t, err)
+}
+
+// TestRecord_RESPReplay checks that replaying the commands of records gives
+// their keys back.
+func TestRecord_RESPReplay(t *testing.T) {
+
+	now := time.Now()
+	records := []*Record{
+		payloadRecord(0, "payload", TypeHash, map[string]string{"f": "v", "g": "w"}, -1),
+		logicalRecord(0, "string", "\xff binary", 100),
+		logicalRecord(0, "hash", map[string]string{"f": "v", "g": "w"}, -1),
+		logicalRecord(0, "list", []string{"b", "a", "b"}, -1),
+		logicalRecord(0, "zset", map[string]Score{"a": 1, "b": -1.5}, -1),
+		logicalRecord(1, "stream", []StreamEntry{{ID: "1-1", Values: map[string]string{"f": "v"}}, {ID: "2-0", Values: map[string]string{"g": "w"}}}, -1),
+	}
+
+	var resp []byte
+	var dbId uint64
+	for _, record := range records {
+
+		if record.DatabaseId != dbId {
+
+			resp = append(resp, selectRESP(record.DatabaseId)...)
+			dbId = record.DatabaseId
+		}
+
+		data, err := record.RESP(now)
+		assert.NoError(t, err)
+		resp = append(resp, data...)
+	}
+
+	keyspace := NewKeyspace()
+	reader := NewRESPReader(strings.NewReader(string(resp)))
+	for {
+
+		args, err := reader.ReadCommand()
+		if err != nil {
+			break
+		}
+		assert.NoError(t, keyspace.Apply(args, now), "%q", args)
+	}
+
+	for _, record := range records {
+
+		entry := keyspace.Lookup(record.DatabaseId, record.Key, now)
+		if !assert.NotNil(t, entry, record.Key) {
+			continue
+		}
+
+		expected, err := recordData(record)
+		assert.NoError(t, err)
+		data, err := entry.Value()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, data, record.Key)
+
+		if record.TTL > 0 {
+			assert.InDelta(t, record.TTL*1000, entry.TTL(now), 1000, record.Key)
+		}
+	}
+}
+
+// recordData decodes the value of a record, payload or logical.
+func recordData(record *Record) (interface{}, error) {
+
+	if record.Type == "" {
+
+		_, data, err := DecodePayload(record.Value)
+		return data, err
+	}
+
+	return decodeLogical(record.Type, record.Value)
+}
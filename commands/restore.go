@@ -2,126 +2,523 @@ package commands
 
 import (
 	"bufio"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis"
+	"go.uber.org/atomic"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
 )
 
+// maxRecordLength bounds a dump file line, a base64 DUMP payload of a key up
+// to the default proto-max-bulk-len of 512MB.
+const maxRecordLength = 1 << 30
+
 type Restorer struct {
 	Host                    string
 	Password                string
 	Client                  map[uint64]*redis.Client
+	Path                    string
 	Stream                  *os.File
-	Count                   uint64
+	Count                   atomic.Uint64
+	Failed                  atomic.Uint64
+	Skipped                 atomic.Uint64
+	Deleted                 atomic.Uint64
 	jsonStringList          chan string
+	workers                 *lib.Workers
+	clientLock              sync.Mutex
+	IsSupportReplaceRestore bool
+	ThreadCount             int
+	Policy                  *ErrorPolicy
+	Limits                  *RateLimits
+	DatabaseMap             *DatabaseMap
+	KeyRewriter             *KeyRewriter
+	Conflict                *ConflictPolicy
+	Plan                    *Plan
+	IsRESP                  bool
+	parts                   map[string]*pendingKey
+	brokenParts             map[string]uint64
+}
+
+// pendingKey is a big key of which some parts are written, it is half-written
+// until its last part.
+type pendingKey struct {
+	client *redis.Client
+	dbId   uint64
+	key    string
+	record *Record
+}
+
+type RestoreWorker struct {
+	IsSupportReplaceRestore bool
+	Policy                  *ErrorPolicy
+	Limits                  *RateLimits
+	Conflict                *ConflictPolicy
+	Plan                    *Plan
+}
+
+type RestoreLauncher struct {
+	Host                    string
+	Password                string
+	Path                    string
 	IsSupportReplaceRestore bool
+	ThreadCount             int
+	Policy                  *ErrorPolicy
+	Limits                  *RateLimits
+	DatabaseMap             *DatabaseMap
+	KeyRewriter             *KeyRewriter
+	Conflict                *ConflictPolicy
+	Plan                    *Plan
 }
 
 func (r *Restorer) Init() {
 
 	r.jsonStringList = make(chan string, 1)
 	r.Client = make(map[uint64]*redis.Client)
-	r.Count = 0
+	r.parts = make(map[string]*pendingKey)
+	r.brokenParts = make(map[string]uint64)
+	r.Count.Store(0)
 }
 
-func (r *Restorer) Restore() {
+func (r *Restorer) Restore(ctx context.Context) (err error) {
 
-	r.readFile()
-	for {
+	r.initSemaphore(ctx, r.ThreadCount)
+	if r.IsRESP {
+		return r.replay(ctx)
+	}
 
-		jsonString := r.getLine()
+	r.readFile(r.workers.Context())
+	for {
 
-		if jsonString == "" {
+		jsonString, ok := r.getLine(r.workers.Context())
+		if !ok {
 			break
 		}
 
-		record := &Record{}
-		err := json.Unmarshal([]byte(jsonString), &record)
-
+		record, err := UnmarshalRecord(jsonString)
 		if err != nil {
 
-			log.Printf("Unmarshal %s error , %s\n", jsonString, err)
+			if err = r.fail(nil, err); err != nil {
+				break
+			}
 			continue
 		}
 
-		b, err := base64.StdEncoding.DecodeString(record.Value)
-		if err != nil {
+		if record.IsValueless() {
 
-			log.Printf("base64 decode %s error , %s\n", record.Value, err)
+			err = fmt.Errorf("db: %d , key: %s has no value to restore, it failed to dump (%s), dump or sync it again", record.DatabaseId, record.Key, record.Error)
+			if err = r.fail(record, err); err != nil {
+				break
+			}
 			continue
 		}
 
-		record.Value = string(b)
-		duration, err := time.ParseDuration(fmt.Sprintf("%ds", record.TTL))
+		dbId := r.DatabaseMap.Map(record.DatabaseId)
+		target := *record
+		if record.Deleted {
+			target.Key = r.KeyRewriter.Release(record.DatabaseId, dbId, record.Key)
+		} else {
+			target.Key, err = r.KeyRewriter.Claim(record.DatabaseId, dbId, record.Key)
+		}
 		if err != nil {
-			log.Printf("Parse ttl(%d) error, %s\n", record.TTL, err)
+
+			if err = r.fail(record, err); err != nil {
+				break
+			}
 			continue
 		}
 
-		client := r.getClient(record.DatabaseId)
+		client := r.getClient(dbId)
 		if client == nil {
 			continue
 		}
 
-		if r.IsSupportReplaceRestore {
-			if duration > 0 {
-				_, err = client.RestoreReplace(record.Key, duration, record.Value).Result()
-			} else {
-				_, err = client.RestoreReplace(record.Key, 0, record.Value).Result()
+		if record.Part > 0 {
+
+			if err = r.restorePart(ctx, client, dbId, record, &target); err != nil {
+				break
 			}
-		} else {
-			client.Del(record.Key).Result()
-			if duration > 0 {
-				_, err = client.Restore(record.Key, duration, record.Value).Result()
-			} else {
-				_, err = client.Restore(record.Key, 0, record.Value).Result()
+			continue
+		}
+
+		if record.Deleted {
+
+			if err = r.delete(ctx, client, dbId, record, &target); err != nil {
+				break
 			}
+			continue
 		}
 
-		if err != nil {
+		err = r.workers.Go(ctx, func(ctx context.Context, worker interface{}) error {
 
-			log.Printf("Restore error , struct: %#v , error: %s\n", record, err)
+			var restored bool
+			err := r.Policy.Do(ctx, func() (err error) {
+				restored, err = worker.(*RestoreWorker).Restore(ctx, client, dbId, &target)
+				return
+			})
+
+			if err != nil {
+
+				log.Printf("Restore error , db: %d , key: %s , error: %s\n", record.DatabaseId, record.Key, err)
+				r.Failed.Inc()
+				return r.Policy.Failed(record, err)
+			}
+
+			if !restored {
+
+				r.Skipped.Inc()
+				return nil
+			}
+
+			if r.Count.Inc()%1000 == 0 {
+
+				r.PrintReport()
+			}
+
+			return nil
+		})
+
+		if err != nil {
 			break
 		}
+	}
 
-		r.Count++
-		if r.Count%1000 == 0 {
+	if r.workers.Context().Err() == nil {
 
-			r.PrintReport()
-		}
+		// a failure stopping the restore is kept by the workers, see fail
+		_ = r.dropParts(ctx)
 	}
 
+	err = r.workers.Wait(ctx)
+
 	r.CloseClients()
 	r.CloseStream()
 
+	r.KeyRewriter.PrintReport()
+	r.Conflict.PrintReport()
+	r.Plan.PrintReport()
 	r.PrintReport()
+	if deleted := r.Deleted.Load(); deleted > 0 {
+		log.Printf("Deleted %d key(s).\n", deleted)
+	}
+	return
 }
 
-func (r *Restorer) getLine() string {
+// delete removes the key of a Deleted record, such as written by diff.
+func (r *Restorer) delete(ctx context.Context, client *redis.Client, dbId uint64, record, target *Record) error {
 
-	var jsonString string
+	return r.workers.Go(ctx, func(ctx context.Context, worker interface{}) error {
 
-	for {
-		select {
-		case jsonString = <-r.jsonStringList:
+		err := r.Policy.Do(ctx, func() error {
+			return worker.(*RestoreWorker).Delete(ctx, client, dbId, target.Key)
+		})
+
+		if err != nil {
+
+			log.Printf("Delete error , db: %d , key: %s , error: %s\n", record.DatabaseId, record.Key, err)
+			r.Failed.Inc()
+			return r.Policy.Failed(record, err)
+		}
+
+		r.Deleted.Inc()
+		return nil
+	})
+}
+
+// restorePart rebuilds a big key in the reading loop, so its parts are written
+// in order. The parts following a skipped first part are ignored, those
+// following a failed part are left out and counted rather than applied to a
+// half-written key. A first part coming again, the dump having retried the
+// key, replaces what was written of it. A failure stopping the restore goes
+// through fail, so it is kept by the workers.
+func (r *Restorer) restorePart(ctx context.Context, client *redis.Client, dbId uint64, record, target *Record) error {
+
+	id := fmt.Sprintf("%d:%s", dbId, target.Key)
+	if count, isBroken := r.brokenParts[id]; isBroken && record.Part > 1 {
+
+		count++
+		r.brokenParts[id] = count
+		if !record.More {
+
+			log.Printf("Left out %d part(s) of key %s after a failed part , db: %d\n", count, record.Key, record.DatabaseId)
+			delete(r.brokenParts, id)
+		}
+		return nil
+	}
+	delete(r.brokenParts, id)
 
-			return jsonString
+	pending := r.parts[id]
+	if record.Part > 1 && pending == nil {
+		return nil
+	}
+
+	if pending != nil && record.Part <= 1 {
+
+		// not to be taken for an existing key by -on-conflict
+		delete(r.parts, id)
+		if err := r.dropPart(ctx, pending); err != nil {
+			return r.fail(record, fmt.Errorf("Restore error , db: %d , key: %s , part: %d , error: %s", record.DatabaseId, record.Key, record.Part, err))
+		}
+	}
+
+	ttl := time.Duration(record.TTL) * time.Second
+	restored, err := r.destination(client, dbId).RestorePart(ctx, target.Key, target.Type, record.Part, record.More, ttl, target.Value)
+	if err != nil {
+
+		delete(r.parts, id)
+		if record.More {
+			r.brokenParts[id] = 0
+		}
+		return r.fail(record, fmt.Errorf("Restore error , db: %d , key: %s , part: %d , error: %s", record.DatabaseId, record.Key, record.Part, err))
+	}
+
+	if !restored {
+
+		delete(r.parts, id)
+		r.Skipped.Inc()
+		return nil
+	}
+
+	if record.More {
+
+		if record.Part <= 1 {
+			r.parts[id] = &pendingKey{client: client, dbId: dbId, key: target.Key, record: record}
+		}
+		return nil
+	}
+
+	delete(r.parts, id)
+	r.Count.Inc()
+	return nil
+}
+
+// dropParts fails the big keys still missing their last part at the end of
+// the file, the dump having stopped during them, and deletes what was written
+// of them. They are dead-lettered without a value, so that they are dumped
+// again rather than restored from the dead-letter file.
+func (r *Restorer) dropParts(ctx context.Context) error {
+
+	ids := make([]string, 0, len(r.parts))
+	for id := range r.parts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+
+		pending := r.parts[id]
+		delete(r.parts, id)
+
+		cause := fmt.Errorf("db: %d , key: %s misses its last part, the dump stopped during the key, dump or sync it again", pending.record.DatabaseId, pending.record.Key)
+		if err := r.dropPart(ctx, pending); err != nil {
+			cause = fmt.Errorf("%s, deleting the half-written key failed: %s", cause, err)
+		}
+
+		record := &Record{DatabaseId: pending.record.DatabaseId, Key: pending.record.Key, TTL: pending.record.TTL}
+		if err := r.fail(record, cause); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-		default:
+// dropPart deletes a half-written big key, there is nothing to delete on a
+// dry-run.
+func (r *Restorer) dropPart(ctx context.Context, pending *pendingKey) error {
 
-			time.Sleep(10 * time.Millisecond)
+	if r.Plan != nil {
+		return nil
+	}
+
+	return r.destination(pending.client, pending.dbId).Delete(ctx, pending.key)
+}
+
+func (r *Restorer) destination(client *redis.Client, dbId uint64) *Destination {
+
+	return &Destination{
+		Client:                  client,
+		DatabaseId:              dbId,
+		Policy:                  r.Policy,
+		Limits:                  r.Limits,
+		Conflict:                r.Conflict,
+		Plan:                    r.Plan,
+		IsSupportReplaceRestore: r.IsSupportReplaceRestore,
+	}
+}
+
+// replayBatchSize is the number of commands sent in one pipeline on replay.
+const replayBatchSize = 100
+
+// replay sends the commands of a RESP file or AOF in order, pipelined per
+// database. SELECT switches the database (through -db-map), MULTI and EXEC
+// are dropped. The keys of an RDB preamble or base are sent as RESTORE.
+func (r *Restorer) replay(ctx context.Context) (err error) {
+
+	defer func() {
+		r.CloseClients()
+		r.CloseStream()
+		log.Printf("Replayed %d command(s), %d failed.\n", r.Count.Load(), r.Failed.Load())
+	}()
+
+	dbId := r.DatabaseMap.Map(0)
+	var batch [][]interface{}
+	var size int
+	flush := func() error {
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err := r.replayBatch(ctx, r.getClient(dbId), batch, size)
+		batch, size = nil, 0
+		return err
+	}
+
+	err = replayAOF(ctx, r.Path, func(args []string) error {
+
+		switch strings.ToUpper(args[0]) {
+		case "SELECT":
+			if len(args) != 2 {
+				return &ConfigError{Err: fmt.Errorf("invalid SELECT command %q", args)}
+			}
+
+			sourceDbId, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				return &ConfigError{Err: fmt.Errorf("invalid SELECT database %q", args[1])}
+			}
+
+			if err = flush(); err != nil {
+				return err
+			}
+			dbId = r.DatabaseMap.Map(sourceDbId)
+			return nil
+
+		case "MULTI", "EXEC":
+			return nil
+		}
+
+		if len(batch) >= replayBatchSize {
+
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		command := make([]interface{}, len(args))
+		for i, arg := range args {
+			command[i] = arg
+			size += len(arg)
+		}
+		batch = append(batch, command)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = flush(); err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+func (r *Restorer) replayBatch(ctx context.Context, client *redis.Client, batch [][]interface{}, size int) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := r.Limits.Write(ctx, len(batch), size); err != nil {
+		return err
+	}
+
+	pipeline := client.Pipeline()
+	defer pipeline.Close()
+
+	commands := make([]*redis.Cmd, len(batch))
+	for i, command := range batch {
+		commands[i] = pipeline.Do(command...)
+	}
+
+	// the error of every command is checked below
+	_, _ = pipeline.Exec()
+	for i, command := range commands {
+
+		err := command.Err()
+		if err == nil || err == redis.Nil {
+
+			r.Count.Inc()
 			continue
 		}
+
+		if err = r.fail(nil, fmt.Errorf("Replay %s error, %s", batch[i][0], err)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fail handles a record which cannot be dispatched, it returns an error when
+// the policy says the restore must stop.
+func (r *Restorer) fail(record *Record, cause error) error {
+
+	log.Println(cause)
+	r.Failed.Inc()
+
+	if err := r.Policy.Failed(record, cause); err != nil {
+
+		r.workers.Fail(err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *Restorer) initSemaphore(ctx context.Context, threadCount int) {
+
+	if threadCount <= 0 {
+		threadCount = 1
+	}
+
+	r.workers = lib.NewWorkers(ctx, threadCount,
+		func() interface{} {
+			return &RestoreWorker{
+				IsSupportReplaceRestore: r.IsSupportReplaceRestore,
+				Policy:                  r.Policy,
+				Limits:                  r.Limits,
+				Conflict:                r.Conflict,
+				Plan:                    r.Plan,
+			}
+		},
+	)
+}
+
+func (r *Restorer) getLine(ctx context.Context) (jsonString string, ok bool) {
+
+	select {
+	case jsonString, ok = <-r.jsonStringList:
+		return
+	case <-ctx.Done():
+		return "", false
 	}
 }
 
 func (r *Restorer) getClient(dbId uint64) (client *redis.Client) {
 
+	r.clientLock.Lock()
+	defer r.clientLock.Unlock()
+
 	var isExist bool
 	if client, isExist = r.Client[dbId]; isExist {
 
@@ -132,26 +529,42 @@ func (r *Restorer) getClient(dbId uint64) (client *redis.Client) {
 		Addr:         r.Host,
 		Password:     r.Password,
 		DB:           int(dbId),
+		PoolSize:     r.ThreadCount,
 		WriteTimeout: 300 * time.Second,
 	})
 
 	return r.Client[dbId]
 }
 
-func (r *Restorer) readFile() {
+func (r *Restorer) readFile(ctx context.Context) {
 
 	go func(stream *os.File, list chan string) {
+		defer close(list)
+
 		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 64*1024), maxRecordLength)
 		for scanner.Scan() {
 
-			list <- scanner.Text()
+			select {
+			case list <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+
+			log.Printf("Read file error, %s\n", err)
+			r.workers.Fail(&ConfigError{Err: err})
 		}
-		close(list)
 	}(r.Stream, r.jsonStringList)
 }
 
 func (r *Restorer) CloseClients() {
 
+	r.clientLock.Lock()
+	defer r.clientLock.Unlock()
+
 	for dbId, client := range r.Client {
 
 		client.Close()
@@ -170,26 +583,187 @@ func (r *Restorer) CloseStream() {
 	r.Stream = nil
 }
 
+func (r *Restorer) Result() Result {
+
+	return Result{
+		Succeeded: r.Count.Load(),
+		Failed:    r.Failed.Load(),
+		Skipped:   r.Skipped.Load(),
+		Deleted:   r.Deleted.Load(),
+	}
+}
+
 func (r *Restorer) PrintReport() {
 
-	log.Printf("Restored %d Record(s).\n", r.Count)
+	log.Printf("Restored %d Record(s).\n", r.Count.Load())
 }
 
-func Restore(host, password, path string, isSupportReplaceRestore bool) {
+func (rw *RestoreWorker) Restore(ctx context.Context, client *redis.Client, dbId uint64, record *Record) (restored bool, err error) {
 
-	fp, err := os.Open(path)
+	if record.Value == "" && record.Type == "" {
+
+		return false, fmt.Errorf("Record has no payload, %s", record.Error)
+	}
+
+	duration, err := time.ParseDuration(fmt.Sprintf("%ds", record.TTL))
 	if err != nil {
 
-		log.Printf("Open data file error, %s\n", err)
+		return false, fmt.Errorf("Parse ttl(%d) error, %s", record.TTL, err)
+	}
+
+	destination := &Destination{
+		Client:                  client,
+		DatabaseId:              dbId,
+		Policy:                  rw.Policy,
+		Limits:                  rw.Limits,
+		Conflict:                rw.Conflict,
+		Plan:                    rw.Plan,
+		IsSupportReplaceRestore: rw.IsSupportReplaceRestore,
+	}
+
+	if record.Type != "" {
+		return destination.RestorePart(ctx, record.Key, record.Type, 0, false, duration, record.Value)
+	}
+
+	restored, err = destination.Restore(ctx, record.Key, duration, record.Value)
+	if isBadPayload(err) {
+		err = fmt.Errorf("%s, dump the source with -transfer=logical for this destination", err)
+	}
+
+	return
+}
+
+func (rw *RestoreWorker) Delete(ctx context.Context, client *redis.Client, dbId uint64, key string) error {
+
+	destination := &Destination{
+		Client:     client,
+		DatabaseId: dbId,
+		Policy:     rw.Policy,
+		Limits:     rw.Limits,
+		Plan:       rw.Plan,
+	}
+
+	return destination.Delete(ctx, key)
+}
+
+func (launcher *RestoreLauncher) SetHost(host string) *RestoreLauncher {
+
+	launcher.Host = host
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetPassword(password string) *RestoreLauncher {
+
+	launcher.Password = password
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetPath(path string) *RestoreLauncher {
+
+	launcher.Path = path
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetIsSupportReplaceRestore(isSupportReplaceRestore bool) *RestoreLauncher {
+
+	launcher.IsSupportReplaceRestore = isSupportReplaceRestore
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetThreadCount(threadCount int) *RestoreLauncher {
+
+	launcher.ThreadCount = threadCount
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetErrorPolicy(policy *ErrorPolicy) *RestoreLauncher {
+
+	launcher.Policy = policy
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetRateLimits(limits *RateLimits) *RestoreLauncher {
+
+	launcher.Limits = limits
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetDatabaseMap(dbMap *DatabaseMap) *RestoreLauncher {
+
+	launcher.DatabaseMap = dbMap
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetKeyRewriter(rewriter *KeyRewriter) *RestoreLauncher {
+
+	launcher.KeyRewriter = rewriter
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetConflictPolicy(conflict *ConflictPolicy) *RestoreLauncher {
+
+	launcher.Conflict = conflict
+	return launcher
+}
+
+func (launcher *RestoreLauncher) SetPlan(plan *Plan) *RestoreLauncher {
+
+	launcher.Plan = plan
+	return launcher
+}
+
+func (launcher *RestoreLauncher) Launch(ctx context.Context) (result Result, err error) {
+
+	fp, err := os.Open(launcher.Path)
+	if err != nil {
+
+		err = &ConfigError{Err: fmt.Errorf("Open data file error, %s", err)}
 		return
 	}
 	restorer := &Restorer{
-		Host:                    host,
-		Password:                password,
+		Host:                    launcher.Host,
+		Password:                launcher.Password,
+		Path:                    launcher.Path,
 		Stream:                  fp,
-		IsSupportReplaceRestore: isSupportReplaceRestore,
+		IsSupportReplaceRestore: launcher.IsSupportReplaceRestore,
+		ThreadCount:             launcher.ThreadCount,
+		Policy:                  launcher.Policy,
+		Limits:                  launcher.Limits,
+		DatabaseMap:             launcher.DatabaseMap,
+		KeyRewriter:             launcher.KeyRewriter,
+		Conflict:                launcher.Conflict,
+		Plan:                    launcher.Plan,
+	}
+
+	restorer.IsRESP, err = isAOF(launcher.Path)
+	if err != nil {
+
+		fp.Close()
+		err = &ConfigError{Err: fmt.Errorf("Read data file error, %s", err)}
+		return
+	}
+
+	if restorer.IsRESP && (launcher.KeyRewriter.HasRules() || launcher.Conflict != nil || launcher.Plan != nil) {
+
+		fp.Close()
+		err = &ConfigError{Err: fmt.Errorf("key rewriting, -on-conflict and -dry-run do not apply to a RESP input")}
+		return
 	}
 
 	restorer.Init()
-	restorer.Restore()
+	if err = ping(restorer.getClient(0), launcher.Host); err != nil {
+
+		restorer.CloseClients()
+		restorer.CloseStream()
+		return
+	}
+
+	err = restorer.Restore(ctx)
+	result = restorer.Result()
+	if err != nil {
+
+		err = fmt.Errorf("Restore aborted, %w", err)
+	}
+
+	return
 }
@@ -0,0 +1,144 @@
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+// Without key rewriting, a key found in several databases merged into one is
+// a collision all the same.
+func TestRestorer_MergedDatabases(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	path := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, path,
+		logicalRecord(0, "a", "db 0", -1),
+		logicalRecord(1, "a", "db 1", -1),
+		logicalRecord(1, "b", "db 1", -1),
+	)
+
+	destination := newFakeRedis(t)
+	defer destination.Close()
+
+	dbMap, err := ParseDatabaseMap("*:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	policy, err := NewErrorPolicy(OnErrorSkip, 0, 0, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := (&RestoreLauncher{}).SetHost(destination.Addr).SetPath(path).SetThreadCount(1).
+		SetErrorPolicy(policy).SetDatabaseMap(dbMap).SetKeyRewriter(NewKeyClaims()).Launch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Succeeded: 2, Failed: 1}, result)
+	assert.Equal(t, "db 0", destination.Value(t, 0, "a"))
+	assert.Equal(t, "db 1", destination.Value(t, 0, "b"))
+}
+
+// Restoring a base dump then an incremental one, the Deleted record of the
+// incremental removes the key.
+func TestRestorer_Incremental(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	base := filepath.Join(dir, "base.json")
+	writeDumpFile(t, base,
+		logicalRecord(0, "kept", "1", -1),
+		logicalRecord(0, "changed", "1", -1),
+		logicalRecord(0, "removed", "1", -1),
+	)
+
+	incremental := filepath.Join(dir, "incremental.json")
+	writeDumpFile(t, incremental,
+		logicalRecord(0, "changed", "2", -1),
+		&Record{Key: "removed", Deleted: true},
+	)
+
+	destination := newFakeRedis(t)
+	defer destination.Close()
+
+	var result Result
+	for _, path := range []string{base, incremental} {
+
+		pathResult, err := (&RestoreLauncher{}).SetHost(destination.Addr).SetPath(path).SetThreadCount(1).Launch(context.Background())
+		assert.NoError(t, err, path)
+		result.Merge(pathResult)
+	}
+
+	assert.Equal(t, Result{Succeeded: 4, Deleted: 1}, result)
+	assert.Equal(t, []string{"changed", "kept"}, destination.Keys(0))
+	assert.Equal(t, "2", destination.Value(t, 0, "changed"))
+}
+
+// A key failing is retried by -on-error=retry, each attempt retrying its
+// commands on a transient error, then skipped or stops the restore.
+func TestRestorer_ErrorPolicy(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	path := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, path,
+		logicalRecord(0, "a", "1", -1),
+		logicalRecord(0, "b", "1", -1),
+		logicalRecord(0, "c", "1", -1),
+	)
+
+	cases := []struct {
+		name       string
+		onError    string
+		retryTimes int
+		attempts   int
+		reply      string
+		failures   int
+		calls      int
+		result     Result
+		isAborted  bool
+	}{
+		{"abort", OnErrorAbort, 0, 1, "ERR injected", 5, 1, Result{Failed: 1}, true},
+		{"skip", OnErrorSkip, 0, 1, "ERR injected", 5, 1, Result{Succeeded: 2, Failed: 1}, false},
+		{"retry until it works", OnErrorRetry, 2, 1, "ERR injected", 2, 3, Result{Succeeded: 3}, false},
+		{"retry then skip", OnErrorRetry, 2, 1, "ERR injected", 5, 3, Result{Succeeded: 2, Failed: 1}, false},
+		{"transient errors retried on every attempt", OnErrorRetry, 1, 2, "LOADING injected", 5, 4, Result{Succeeded: 2, Failed: 1}, false},
+	}
+
+	for _, c := range cases {
+
+		destination := newFakeRedis(t)
+		calls := atomic.NewInt64(0)
+		destination.SetFail(func(args []string) string {
+
+			if args[0] != "del" || args[1] != "b" || calls.Inc() > int64(c.failures) {
+				return ""
+			}
+			return c.reply
+		})
+
+		policy, err := NewErrorPolicy(c.onError, 0, c.retryTimes, "")
+		if !assert.NoError(t, err, c.name) {
+			continue
+		}
+		policy.Backoff = &lib.Backoff{MaxAttempts: c.attempts}
+
+		result, err := (&RestoreLauncher{}).SetHost(destination.Addr).SetPath(path).SetThreadCount(1).
+			SetErrorPolicy(policy).Launch(context.Background())
+		assert.Equal(t, c.isAborted, err != nil, c.name)
+		assert.Equal(t, int64(c.calls), calls.Load(), c.name)
+		if c.isAborted {
+			assert.Equal(t, c.result.Failed, result.Failed, c.name)
+		} else {
+			assert.Equal(t, c.result, result, c.name)
+		}
+		destination.Close()
+	}
+}
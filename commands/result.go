@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis"
+)
+
+type Result struct {
+	Succeeded uint64
+	Failed    uint64
+	Deleted   uint64
+	Skipped   uint64
+}
+
+func (result *Result) Merge(other Result) {
+
+	result.Succeeded += other.Succeeded
+	result.Failed += other.Failed
+	result.Deleted += other.Deleted
+	result.Skipped += other.Skipped
+}
+
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+
+	return fmt.Sprintf("configuration error, %s", e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+
+	return e.Err
+}
+
+type ConnectionError struct {
+	Host string
+	Err  error
+}
+
+func (e *ConnectionError) Error() string {
+
+	return fmt.Sprintf("connect to %s error, %s", e.Host, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+
+	return e.Err
+}
+
+func ping(client *redis.Client, host string) error {
+
+	if _, err := client.Ping().Result(); err != nil {
+
+		return &ConnectionError{Host: host, Err: err}
+	}
+
+	return nil
+}
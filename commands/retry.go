@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+// transientReplies are server error replies which go away by themselves,
+// e.g. while a replica loads its dataset or a script is running.
+var transientReplies = []string{
+	"LOADING ",
+	"BUSY ",
+	"TRYAGAIN ",
+	"MASTERDOWN ",
+	"CLUSTERDOWN ",
+}
+
+func IsRetryable(err error) bool {
+
+	if err == nil || err == redis.Nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	message := err.Error()
+	if message == "redis: connection pool timeout" {
+		return true
+	}
+
+	for _, prefix := range transientReplies {
+
+		if strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Retry runs a single redis command, retrying it with the configured backoff
+// while it fails with a transient error.
+func (p *ErrorPolicy) Retry(ctx context.Context, command string, op func() error) error {
+
+	if p == nil {
+		return op()
+	}
+
+	attempt := 0
+	return p.Backoff.Retry(ctx, op, func(err error) bool {
+
+		if !IsRetryable(err) {
+			return false
+		}
+
+		attempt++
+		log.Printf("%s transient error, retry %d/%d, %s\n", command, attempt, p.Backoff.MaxAttempts-1, err)
+		return true
+	})
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+
+	cases := []struct {
+		name        string
+		err         error
+		isRetryable bool
+	}{
+		{"no error", nil, false},
+		{"missing key", redis.Nil, false},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("dump: %w", context.DeadlineExceeded), false},
+		{"timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"closed connection", io.EOF, true},
+		{"truncated reply", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"pool timeout", errors.New("redis: connection pool timeout"), true},
+		{"loading", errors.New("LOADING Redis is loading the dataset in memory"), true},
+		{"busy script", errors.New("BUSY Redis is busy running a script."), true},
+		{"tryagain", errors.New("TRYAGAIN Multiple keys request during rehashing of slot"), true},
+		{"masterdown", errors.New("MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'."), true},
+		{"clusterdown", errors.New("CLUSTERDOWN The cluster is down"), true},
+		{"busy key", errors.New("BUSYKEY Target key name already exists."), false},
+		{"unknown command", errors.New("ERR unknown command 'MEMORY'"), false},
+		{"wrong type", errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{"read only", errors.New("READONLY You can't write against a read only replica."), false},
+		{"out of memory", errors.New("OOM command not allowed when used memory > 'maxmemory'."), false},
+		{"bad payload", errors.New("ERR Bad data format"), false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.isRetryable, IsRetryable(c.err), c.name)
+	}
+}
+
+func TestErrorPolicy_Retry(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"transient error", errors.New("LOADING Redis is loading the dataset in memory"), 3},
+		{"fatal error", errors.New("ERR unknown command"), 1},
+		{"no error", nil, 1},
+	}
+
+	for _, c := range cases {
+
+		policy := &ErrorPolicy{OnError: OnErrorAbort, Backoff: &lib.Backoff{MaxAttempts: 3}}
+		calls := 0
+		err := policy.Retry(context.Background(), "DUMP", func() error {
+			calls++
+			return c.err
+		})
+		assert.Equal(t, c.err, err, c.name)
+		assert.Equal(t, c.calls, calls, c.name)
+	}
+
+	var none *ErrorPolicy
+	calls := 0
+	assert.Error(t, none.Retry(context.Background(), "DUMP", func() error {
+		calls++
+		return io.EOF
+	}))
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,847 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+const DefaultListen = "127.0.0.1:6390"
+
+// defaultDatabaseCount is the number of databases of a redis by default.
+const defaultDatabaseCount = 16
+
+// RESP replies other than bulk strings (string), integers (int64), nil and
+// arrays ([]interface{}).
+type (
+	respStatus string
+	respError  string
+)
+
+const (
+	errWrongType = respError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSyntax    = respError("ERR syntax error")
+	errNotNumber = respError("ERR value is not an integer or out of range")
+	errReadOnly  = respError("READONLY You can't write against a read only replica.")
+)
+
+// writeCommands are refused with a READONLY error rather than as unknown.
+var writeCommands = map[string]bool{
+	"SET": true, "SETEX": true, "PSETEX": true, "SETNX": true, "MSET": true, "GETSET": true, "GETDEL": true,
+	"APPEND": true, "INCR": true, "INCRBY": true, "DECR": true, "DECRBY": true, "DEL": true, "UNLINK": true,
+	"EXPIRE": true, "PEXPIRE": true, "EXPIREAT": true, "PEXPIREAT": true, "PERSIST": true, "RENAME": true,
+	"MOVE": true, "COPY": true, "RESTORE": true, "HSET": true, "HMSET": true, "HDEL": true, "HINCRBY": true,
+	"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true, "LSET": true, "LREM": true, "SADD": true,
+	"SREM": true, "SPOP": true, "ZADD": true, "ZREM": true, "ZINCRBY": true, "XADD": true, "XDEL": true,
+	"XTRIM": true, "FLUSHDB": true, "FLUSHALL": true,
+}
+
+// Server answers a read-only subset of the redis commands from a dump file
+// loaded in memory, so that redis-cli and applications can read a dump
+// without restoring it. DUMP payloads are only decoded when a command needs
+// their value. Keys expire as they would in redis, from the time of loading.
+// SELECT accepts the databases below DatabaseCount, by default 16 or up to
+// the highest database of the file.
+type Server struct {
+	Input         string
+	InputFormat   string
+	Listen        string
+	DatabaseCount uint64
+	keyspace      *Keyspace
+	keys          map[uint64][]string
+	lock          sync.Mutex
+}
+
+// Load reads the input file, in InputFormat or the format detected from it.
+func (s *Server) Load(ctx context.Context) (count uint64, err error) {
+
+	format := s.InputFormat
+	if format == "" {
+
+		if format, err = detectFormat(s.Input); err != nil {
+			return
+		}
+	}
+
+	now := time.Now()
+	s.keyspace = NewKeyspace()
+	err = readRecords(ctx, s.Input, format, func(record *Record) error {
+
+		if err := s.keyspace.Load(record, now); err != nil {
+			return &ConfigError{Err: err}
+		}
+
+		if !record.More && !record.Deleted {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	isDefaultCount := s.DatabaseCount == 0
+	if isDefaultCount {
+		s.DatabaseCount = defaultDatabaseCount
+	}
+
+	s.keys = make(map[uint64][]string)
+	for _, dbId := range s.keyspace.Databases() {
+
+		s.keys[dbId] = s.keyspace.Keys(dbId, now)
+		if isDefaultCount && dbId >= s.DatabaseCount {
+			s.DatabaseCount = dbId + 1
+		}
+	}
+
+	return
+}
+
+// Serve accepts connections on Listen until ctx is done.
+func (s *Server) Serve(ctx context.Context) error {
+
+	listener, err := net.Listen("tcp", s.Listen)
+	if err != nil {
+
+		return &ConfigError{Err: fmt.Errorf("Listen on %s error, %s", s.Listen, err)}
+	}
+
+	go func() {
+
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("Serving %s on %s, read only\n", s.Input, s.Listen)
+	for {
+
+		conn, err := listener.Accept()
+		if err != nil {
+
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go s.handle(ctx, conn)
+	}
+}
+
+// session is the state of a connection.
+type session struct {
+	dbId   uint64
+	isQuit bool
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+	go func() {
+
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	reader := NewRESPReader(conn)
+	writer := bufio.NewWriter(conn)
+	state := &session{}
+	for !state.isQuit {
+
+		args, err := reader.ReadCommand()
+		if err != nil {
+
+			if err != io.EOF && ctx.Err() == nil {
+				writeReply(writer, respError(fmt.Sprintf("ERR Protocol error, %s", err)))
+				writer.Flush()
+			}
+			return
+		}
+
+		writeReply(writer, s.execute(state, args))
+		if reader.reader.Buffered() == 0 {
+
+			if err = writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+
+	writer.Flush()
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+
+	switch reply := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case respStatus:
+		fmt.Fprintf(w, "+%s\r\n", reply)
+	case respError:
+		fmt.Fprintf(w, "-%s\r\n", reply)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", reply)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(reply), reply)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(reply))
+		for _, item := range reply {
+			writeReply(w, item)
+		}
+	}
+}
+
+// readCommands are the commands about a key, with their least number of
+// arguments, command name included.
+var readCommands = map[string]int{
+	"ECHO": 2, "SELECT": 2, "KEYS": 2, "SCAN": 2, "EXISTS": 2, "TYPE": 2, "TTL": 2, "PTTL": 2, "DUMP": 2,
+	"GET": 2, "MGET": 2, "STRLEN": 2, "GETRANGE": 4, "HGET": 3, "HMGET": 3, "HGETALL": 2, "HKEYS": 2,
+	"HVALS": 2, "HLEN": 2, "HEXISTS": 3, "HSTRLEN": 3, "HSCAN": 3, "LLEN": 2, "LRANGE": 4, "LINDEX": 3,
+	"SMEMBERS": 2, "SCARD": 2, "SISMEMBER": 3, "SSCAN": 3, "ZCARD": 2, "ZSCORE": 3, "ZRANGE": 4,
+	"ZREVRANGE": 4, "ZRANK": 3, "ZSCAN": 3, "XLEN": 2, "XRANGE": 4, "XREVRANGE": 4,
+}
+
+func (s *Server) execute(state *session, args []string) interface{} {
+
+	command := strings.ToUpper(args[0])
+	if len(args) < readCommands[command] {
+		return respError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	switch command {
+	case "PING":
+		if len(args) > 1 {
+			return args[1]
+		}
+		return respStatus("PONG")
+
+	case "ECHO":
+		return args[1]
+
+	case "QUIT":
+		state.isQuit = true
+		return respStatus("OK")
+
+	case "AUTH", "CLIENT", "READONLY":
+		return respStatus("OK")
+
+	case "COMMAND":
+		return []interface{}{}
+
+	case "SELECT":
+		dbId, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil || dbId >= s.DatabaseCount {
+			return respError("ERR DB index is out of range")
+		}
+		state.dbId = dbId
+		return respStatus("OK")
+
+	case "DBSIZE":
+		return int64(len(s.liveKeys(state.dbId, now)))
+
+	case "INFO":
+		return s.info(now)
+
+	case "KEYS":
+		keys := []interface{}{}
+		for _, key := range s.liveKeys(state.dbId, now) {
+
+			if lib.MatchGlob(args[1], key) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+
+	case "SCAN":
+		return s.scan(state.dbId, args[1:], now)
+
+	case "RANDOMKEY":
+		keys := s.liveKeys(state.dbId, now)
+		if len(keys) == 0 {
+			return nil
+		}
+		return keys[rand.Intn(len(keys))]
+
+	case "EXISTS":
+		var count int64
+		for _, key := range args[1:] {
+
+			if s.keyspace.Lookup(state.dbId, key, now) != nil {
+				count++
+			}
+		}
+		return count
+
+	case "MGET":
+		values := make([]interface{}, 0, len(args)-1)
+		for _, key := range args[1:] {
+
+			entry := s.keyspace.Lookup(state.dbId, key, now)
+			if entry == nil || entry.Type != TypeString {
+
+				values = append(values, nil)
+				continue
+			}
+
+			value, err := entry.Value()
+			if err != nil {
+				return respError(fmt.Sprintf("ERR %s", err))
+			}
+			values = append(values, value)
+		}
+		return values
+	}
+
+	if _, isRead := readCommands[command]; !isRead {
+
+		if writeCommands[command] {
+			return errReadOnly
+		}
+		return respError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+
+	entry := s.keyspace.Lookup(state.dbId, args[1], now)
+	switch command {
+	case "TYPE":
+		if entry == nil {
+			return respStatus("none")
+		}
+		return respStatus(entry.Type)
+
+	case "TTL", "PTTL":
+		if entry == nil {
+			return int64(-2)
+		}
+
+		ttl := entry.TTL(now)
+		if ttl > 0 && command == "TTL" {
+			ttl = (ttl + 500) / 1000
+		}
+		return ttl
+
+	case "DUMP":
+		if entry == nil {
+			return nil
+		}
+
+		payload, err := entry.DumpPayload()
+		if err != nil {
+			return respError(fmt.Sprintf("ERR %s", err))
+		}
+		return payload
+	}
+
+	if entry == nil {
+		return emptyReply(command)
+	}
+
+	data, err := entry.Value()
+	if err != nil {
+		return respError(fmt.Sprintf("ERR %s", err))
+	}
+
+	switch data := data.(type) {
+	case string:
+		return stringReply(command, data, args)
+	case map[string]string:
+		return hashReply(command, data, args)
+	case []string:
+		if entry.Type == TypeList {
+			return listReply(command, data, args)
+		}
+		return setReply(command, data, args)
+	case map[string]Score:
+		return zsetReply(command, data, args)
+	case []StreamEntry:
+		return streamReply(command, data, args)
+	}
+
+	return errWrongType
+}
+
+// liveKeys returns the keys of a database which did not expire, sorted.
+func (s *Server) liveKeys(dbId uint64, now time.Time) []string {
+
+	keys := make([]string, 0, len(s.keys[dbId]))
+	for _, key := range s.keys[dbId] {
+
+		if s.keyspace.Lookup(dbId, key, now) != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func (s *Server) info(now time.Time) string {
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# Server\r\nredis_mode:standalone\r\nredis_transmission_input:%s\r\n\r\n# Replication\r\nrole:slave\r\n\r\n# Keyspace\r\n", s.Input)
+	for _, dbId := range s.keyspace.Databases() {
+
+		var keys, expires int
+		for _, key := range s.keys[dbId] {
+
+			if entry := s.keyspace.Lookup(dbId, key, now); entry != nil {
+
+				keys++
+				if entry.ExpireAt != 0 {
+					expires++
+				}
+			}
+		}
+
+		if keys > 0 {
+			fmt.Fprintf(&builder, "db%d:keys=%d,expires=%d,avg_ttl=0\r\n", dbId, keys, expires)
+		}
+	}
+
+	return builder.String()
+}
+
+// scanOptions parses the MATCH, COUNT and TYPE options of the SCAN family.
+func scanOptions(args []string) (match string, count int, keyType string, err respError) {
+
+	count = 10
+	for i := 0; i < len(args); i += 2 {
+
+		if i+1 >= len(args) {
+			return "", 0, "", errSyntax
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			n, parseErr := strconv.Atoi(args[i+1])
+			if parseErr != nil || n < 1 {
+				return "", 0, "", errSyntax
+			}
+			count = n
+		case "TYPE":
+			keyType = strings.ToLower(args[i+1])
+		default:
+			return "", 0, "", errSyntax
+		}
+	}
+
+	return
+}
+
+// scan pages through the sorted keys of a database, the cursor being the
+// position of the next key.
+func (s *Server) scan(dbId uint64, args []string, now time.Time) interface{} {
+
+	cursor, parseErr := strconv.Atoi(args[0])
+	if parseErr != nil || cursor < 0 {
+		return respError("ERR invalid cursor")
+	}
+
+	match, count, keyType, err := scanOptions(args[1:])
+	if err != "" {
+		return err
+	}
+
+	keys := s.keys[dbId]
+	page := []interface{}{}
+	for ; cursor < len(keys) && count > 0; cursor++ {
+
+		count--
+		entry := s.keyspace.Lookup(dbId, keys[cursor], now)
+		if entry == nil || (match != "" && !lib.MatchGlob(match, keys[cursor])) || (keyType != "" && entry.Type != keyType) {
+			continue
+		}
+		page = append(page, keys[cursor])
+	}
+
+	if cursor >= len(keys) {
+		cursor = 0
+	}
+
+	return []interface{}{strconv.Itoa(cursor), page}
+}
+
+// collectionScan answers HSCAN, SSCAN and ZSCAN with every matching element
+// in a single page.
+func collectionScan(args []string, items []string, isPairs bool) interface{} {
+
+	match, _, _, err := scanOptions(args[3:])
+	if err != "" {
+		return err
+	}
+
+	step := 1
+	if isPairs {
+		step = 2
+	}
+
+	page := []interface{}{}
+	for i := 0; i+step <= len(items); i += step {
+
+		if match != "" && !lib.MatchGlob(match, items[i]) {
+			continue
+		}
+		for _, item := range items[i : i+step] {
+			page = append(page, item)
+		}
+	}
+
+	return []interface{}{"0", page}
+}
+
+// emptyReply answers a read on a missing key, as on an empty value.
+func emptyReply(command string) interface{} {
+
+	switch command {
+	case "GET", "HGET", "LINDEX", "ZSCORE", "ZRANK":
+		return nil
+	case "STRLEN", "HLEN", "HEXISTS", "HSTRLEN", "LLEN", "SCARD", "SISMEMBER", "ZCARD", "XLEN":
+		return int64(0)
+	case "HSCAN", "SSCAN", "ZSCAN":
+		return []interface{}{"0", []interface{}{}}
+	case "GETRANGE":
+		return ""
+	}
+
+	return []interface{}{}
+}
+
+func stringReply(command, value string, args []string) interface{} {
+
+	switch command {
+	case "GET":
+		return value
+	case "STRLEN":
+		return int64(len(value))
+	case "GETRANGE":
+		start, stop, err := rangeArgs(args[2], args[3], len(value))
+		if err != "" {
+			return err
+		}
+		if start > stop {
+			return ""
+		}
+		return value[start : stop+1]
+	}
+
+	return errWrongType
+}
+
+func hashReply(command string, hash map[string]string, args []string) interface{} {
+
+	switch command {
+	case "HGET":
+		if value, isExist := hash[args[2]]; isExist {
+			return value
+		}
+		return nil
+
+	case "HMGET":
+		values := make([]interface{}, 0, len(args)-2)
+		for _, field := range args[2:] {
+
+			if value, isExist := hash[field]; isExist {
+				values = append(values, value)
+			} else {
+				values = append(values, nil)
+			}
+		}
+		return values
+
+	case "HGETALL", "HKEYS", "HVALS", "HSCAN":
+		var items []string
+		for _, field := range sortedKeys(hash) {
+
+			switch command {
+			case "HKEYS":
+				items = append(items, field)
+			case "HVALS":
+				items = append(items, hash[field])
+			default:
+				items = append(items, field, hash[field])
+			}
+		}
+		if command == "HSCAN" {
+			return collectionScan(args, items, true)
+		}
+		return stringsReply(items)
+
+	case "HLEN":
+		return int64(len(hash))
+
+	case "HEXISTS":
+		if _, isExist := hash[args[2]]; isExist {
+			return int64(1)
+		}
+		return int64(0)
+
+	case "HSTRLEN":
+		return int64(len(hash[args[2]]))
+	}
+
+	return errWrongType
+}
+
+func listReply(command string, list []string, args []string) interface{} {
+
+	switch command {
+	case "LLEN":
+		return int64(len(list))
+
+	case "LRANGE":
+		start, stop, err := rangeArgs(args[2], args[3], len(list))
+		if err != "" {
+			return err
+		}
+		if start > stop {
+			return []interface{}{}
+		}
+		return stringsReply(list[start : stop+1])
+
+	case "LINDEX":
+		index, parseErr := strconv.Atoi(args[2])
+		if parseErr != nil {
+			return errNotNumber
+		}
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 || index >= len(list) {
+			return nil
+		}
+		return list[index]
+	}
+
+	return errWrongType
+}
+
+func setReply(command string, members []string, args []string) interface{} {
+
+	switch command {
+	case "SMEMBERS", "SSCAN":
+		sorted := append([]string{}, members...)
+		sort.Strings(sorted)
+		if command == "SSCAN" {
+			return collectionScan(args, sorted, false)
+		}
+		return stringsReply(sorted)
+
+	case "SCARD":
+		return int64(len(members))
+
+	case "SISMEMBER":
+		for _, member := range members {
+
+			if member == args[2] {
+				return int64(1)
+			}
+		}
+		return int64(0)
+	}
+
+	return errWrongType
+}
+
+func zsetReply(command string, scores map[string]Score, args []string) interface{} {
+
+	// members by score then member, as redis orders them
+	members := make([]string, 0, len(scores))
+	for member := range scores {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if scores[members[i]] != scores[members[j]] {
+			return scores[members[i]] < scores[members[j]]
+		}
+		return members[i] < members[j]
+	})
+
+	switch command {
+	case "ZCARD":
+		return int64(len(scores))
+
+	case "ZSCORE":
+		if score, isExist := scores[args[2]]; isExist {
+			return formatScore(score)
+		}
+		return nil
+
+	case "ZRANK":
+		for rank, member := range members {
+
+			if member == args[2] {
+				return int64(rank)
+			}
+		}
+		return nil
+
+	case "ZSCAN":
+		var items []string
+		for _, member := range members {
+			items = append(items, member, formatScore(scores[member]))
+		}
+		return collectionScan(args, items, true)
+
+	case "ZRANGE", "ZREVRANGE":
+		withScores := false
+		for _, option := range args[4:] {
+
+			if !strings.EqualFold(option, "WITHSCORES") {
+				return errSyntax
+			}
+			withScores = true
+		}
+
+		if command == "ZREVRANGE" {
+
+			for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+				members[i], members[j] = members[j], members[i]
+			}
+		}
+
+		start, stop, err := rangeArgs(args[2], args[3], len(members))
+		if err != "" {
+			return err
+		}
+
+		items := []interface{}{}
+		for i := start; i <= stop; i++ {
+
+			items = append(items, members[i])
+			if withScores {
+				items = append(items, formatScore(scores[members[i]]))
+			}
+		}
+		return items
+	}
+
+	return errWrongType
+}
+
+func streamReply(command string, entries []StreamEntry, args []string) interface{} {
+
+	switch command {
+	case "XLEN":
+		return int64(len(entries))
+
+	case "XRANGE", "XREVRANGE":
+		low, high := args[2], args[3]
+		if command == "XREVRANGE" {
+			low, high = high, low
+		}
+
+		count := -1
+		if len(args) > 4 {
+
+			if len(args) != 6 || !strings.EqualFold(args[4], "COUNT") {
+				return errSyntax
+			}
+
+			n, parseErr := strconv.Atoi(args[5])
+			if parseErr != nil {
+				return errNotNumber
+			}
+			count = n
+		}
+
+		var selected []StreamEntry
+		for _, entry := range entries {
+
+			if (low == "-" || compareStreamIds(entry.ID, low) >= 0) && (high == "+" || compareStreamIds(entry.ID, high) <= 0) {
+				selected = append(selected, entry)
+			}
+		}
+
+		if command == "XREVRANGE" {
+
+			for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+				selected[i], selected[j] = selected[j], selected[i]
+			}
+		}
+
+		items := []interface{}{}
+		for _, entry := range selected {
+
+			if count >= 0 && len(items) >= count {
+				break
+			}
+
+			var values []string
+			for _, field := range sortedKeys(entry.Values) {
+				values = append(values, field, entry.Values[field])
+			}
+			items = append(items, []interface{}{entry.ID, stringsReply(values)})
+		}
+		return items
+	}
+
+	return errWrongType
+}
+
+func stringsReply(items []string) []interface{} {
+
+	reply := make([]interface{}, len(items))
+	for i, item := range items {
+		reply[i] = item
+	}
+
+	return reply
+}
+
+// formatScore formats a score as redis replies it.
+func formatScore(score Score) string {
+
+	if math.IsInf(float64(score), 0) {
+		return strings.ToLower(strconv.FormatFloat(float64(score), 'f', -1, 64))
+	}
+
+	return strconv.FormatFloat(float64(score), 'g', 17, 64)
+}
+
+// rangeArgs resolves the inclusive start and stop indexes of a range command
+// over length elements, negative ones counting from the end. start > stop
+// selects nothing.
+func rangeArgs(startArg, stopArg string, length int) (start, stop int, err respError) {
+
+	start, startErr := strconv.Atoi(startArg)
+	stop, stopErr := strconv.Atoi(stopArg)
+	if startErr != nil || stopErr != nil {
+		return 0, 0, errNotNumber
+	}
+
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+
+	return start, stop, ""
+}
@@ -0,0 +1,191 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Execute(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	input := filepath.Join(dir, "dump.json")
+	writeDumpFile(t, input,
+		payloadRecord(0, "string", TypeString, "hello", -1),
+		logicalRecord(0, "hash", map[string]string{"b": "2", "a": "1"}, 100),
+		&Record{Key: "list", Type: TypeList, Value: `["a","b"]`, TTL: -1, Part: 1, More: true},
+		&Record{Key: "list", Type: TypeList, Value: `["c"]`, TTL: -1, Part: 2},
+		payloadRecord(0, "set", TypeSet, []string{"b", "a"}, -1),
+		logicalRecord(0, "zset", map[string]Score{"b": 2.5, "a": 1, "c": Score(math.Inf(-1))}, -1),
+		logicalRecord(0, "stream", []StreamEntry{{ID: "1-0", Values: map[string]string{"f": "1"}}, {ID: "2-0", Values: map[string]string{"f": "2", "e": "0"}}}, -1),
+		logicalRecord(20, "far", "v", -1),
+	)
+
+	server := &Server{Input: input}
+	count, err := server.Load(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, uint64(7), count)
+	assert.Equal(t, uint64(21), server.DatabaseCount)
+
+	payload, _ := EncodePayload(TypeString, "hello")
+	cases := []struct {
+		args  []string
+		reply interface{}
+	}{
+		{[]string{"PING"}, respStatus("PONG")},
+		{[]string{"ping", "hi"}, "hi"},
+		{[]string{"DBSIZE"}, int64(6)},
+		{[]string{"KEYS", "s*"}, []interface{}{"set", "stream", "string"}},
+		{[]string{"SCAN", "0", "COUNT", "4"}, []interface{}{"4", []interface{}{"hash", "list", "set", "stream"}}},
+		{[]string{"SCAN", "4", "COUNT", "4"}, []interface{}{"0", []interface{}{"string", "zset"}}},
+		{[]string{"SCAN", "0", "MATCH", "*s*", "TYPE", "string", "COUNT", "100"}, []interface{}{"0", []interface{}{"string"}}},
+		{[]string{"SCAN", "0", "COUNT"}, errSyntax},
+		{[]string{"EXISTS", "string", "hash", "missing"}, int64(2)},
+		{[]string{"TYPE", "zset"}, respStatus("zset")},
+		{[]string{"TYPE", "missing"}, respStatus("none")},
+		{[]string{"TTL", "hash"}, int64(100)},
+		{[]string{"TTL", "string"}, int64(-1)},
+		{[]string{"PTTL", "missing"}, int64(-2)},
+		{[]string{"DUMP", "string"}, payload},
+		{[]string{"DUMP", "missing"}, nil},
+		{[]string{"GET", "string"}, "hello"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"GET", "hash"}, errWrongType},
+		{[]string{"MGET", "string", "hash", "missing"}, []interface{}{"hello", nil, nil}},
+		{[]string{"STRLEN", "string"}, int64(5)},
+		{[]string{"GETRANGE", "string", "1", "-2"}, "ell"},
+		{[]string{"GETRANGE", "string", "3", "1"}, ""},
+		{[]string{"HGET", "hash", "a"}, "1"},
+		{[]string{"HGET", "hash", "z"}, nil},
+		{[]string{"HMGET", "hash", "b", "z"}, []interface{}{"2", nil}},
+		{[]string{"HGETALL", "hash"}, []interface{}{"a", "1", "b", "2"}},
+		{[]string{"HKEYS", "hash"}, []interface{}{"a", "b"}},
+		{[]string{"HVALS", "hash"}, []interface{}{"1", "2"}},
+		{[]string{"HLEN", "hash"}, int64(2)},
+		{[]string{"HEXISTS", "hash", "a"}, int64(1)},
+		{[]string{"HSCAN", "hash", "0", "MATCH", "b"}, []interface{}{"0", []interface{}{"b", "2"}}},
+		{[]string{"LLEN", "list"}, int64(3)},
+		{[]string{"LRANGE", "list", "0", "-1"}, []interface{}{"a", "b", "c"}},
+		{[]string{"LRANGE", "list", "-2", "100"}, []interface{}{"b", "c"}},
+		{[]string{"LRANGE", "list", "2", "1"}, []interface{}{}},
+		{[]string{"LRANGE", "list", "a", "1"}, errNotNumber},
+		{[]string{"LINDEX", "list", "-1"}, "c"},
+		{[]string{"LINDEX", "list", "3"}, nil},
+		{[]string{"SMEMBERS", "set"}, []interface{}{"a", "b"}},
+		{[]string{"SCARD", "set"}, int64(2)},
+		{[]string{"SISMEMBER", "set", "b"}, int64(1)},
+		{[]string{"SISMEMBER", "set", "c"}, int64(0)},
+		{[]string{"SSCAN", "set", "0"}, []interface{}{"0", []interface{}{"a", "b"}}},
+		{[]string{"ZCARD", "zset"}, int64(3)},
+		{[]string{"ZSCORE", "zset", "b"}, "2.5"},
+		{[]string{"ZSCORE", "zset", "c"}, "-inf"},
+		{[]string{"ZRANK", "zset", "a"}, int64(1)},
+		{[]string{"ZRANGE", "zset", "0", "-1"}, []interface{}{"c", "a", "b"}},
+		{[]string{"ZRANGE", "zset", "1", "1", "WITHSCORES"}, []interface{}{"a", "1"}},
+		{[]string{"ZREVRANGE", "zset", "0", "0", "withscores"}, []interface{}{"b", "2.5"}},
+		{[]string{"ZRANGE", "zset", "0", "1", "BYSCORE"}, errSyntax},
+		{[]string{"ZSCAN", "zset", "0"}, []interface{}{"0", []interface{}{"c", "-inf", "a", "1", "b", "2.5"}}},
+		{[]string{"XLEN", "stream"}, int64(2)},
+		{[]string{"XRANGE", "stream", "-", "+"}, []interface{}{
+			[]interface{}{"1-0", []interface{}{"f", "1"}},
+			[]interface{}{"2-0", []interface{}{"e", "0", "f", "2"}},
+		}},
+		{[]string{"XRANGE", "stream", "2", "+"}, []interface{}{[]interface{}{"2-0", []interface{}{"e", "0", "f", "2"}}}},
+		{[]string{"XREVRANGE", "stream", "+", "-", "COUNT", "1"}, []interface{}{[]interface{}{"2-0", []interface{}{"e", "0", "f", "2"}}}},
+		{[]string{"XRANGE", "stream", "-", "+", "COUNT"}, errSyntax},
+		{[]string{"LLEN", "missing"}, int64(0)},
+		{[]string{"LRANGE", "missing", "0", "-1"}, []interface{}{}},
+		{[]string{"HSCAN", "missing", "0"}, []interface{}{"0", []interface{}{}}},
+		{[]string{"GET"}, respError("ERR wrong number of arguments for 'get' command")},
+		{[]string{"SET", "k", "v"}, errReadOnly},
+		{[]string{"FLUSHALL"}, errReadOnly},
+		{[]string{"EVAL", "return 1", "0"}, respError("ERR unknown command 'EVAL'")},
+		{[]string{"SELECT", "21"}, respError("ERR DB index is out of range")},
+		{[]string{"SELECT", "-1"}, respError("ERR DB index is out of range")},
+		{[]string{"SELECT", "one"}, respError("ERR DB index is out of range")},
+		{[]string{"GET", "far"}, nil},
+		{[]string{"SELECT", "20"}, respStatus("OK")},
+		{[]string{"GET", "far"}, "v"},
+		{[]string{"DBSIZE"}, int64(1)},
+		{[]string{"RANDOMKEY"}, "far"},
+		{[]string{"SELECT", "1"}, respStatus("OK")},
+		{[]string{"RANDOMKEY"}, nil},
+		{[]string{"KEYS", "*"}, []interface{}{}},
+		{[]string{"QUIT"}, respStatus("OK")},
+	}
+
+	state := &session{}
+	for _, c := range cases {
+		assert.Equal(t, c.reply, server.execute(state, c.args), "%q", c.args)
+	}
+	assert.True(t, state.isQuit)
+
+	server = &Server{Input: input, DatabaseCount: 4}
+	_, err = server.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, respError("ERR DB index is out of range"), server.execute(&session{}, []string{"SELECT", "4"}))
+}
+
+func TestWriteReply(t *testing.T) {
+
+	cases := []struct {
+		reply interface{}
+		resp  string
+	}{
+		{nil, "$-1\r\n"},
+		{respStatus("OK"), "+OK\r\n"},
+		{respError("ERR no"), "-ERR no\r\n"},
+		{int64(-2), ":-2\r\n"},
+		{"a\r\nb", "$4\r\na\r\nb\r\n"},
+		{"", "$0\r\n\r\n"},
+		{[]interface{}{}, "*0\r\n"},
+		{[]interface{}{"0", []interface{}{"k", nil}}, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nk\r\n$-1\r\n"},
+	}
+
+	for _, c := range cases {
+
+		var buffer bytes.Buffer
+		writer := bufio.NewWriter(&buffer)
+		writeReply(writer, c.reply)
+		writer.Flush()
+		assert.Equal(t, c.resp, buffer.String(), "%#v", c.reply)
+	}
+}
+
+func TestServer_LoadMalformedRDB(t *testing.T) {
+
+	dir, remove := tempDir(t)
+	defer remove()
+
+	for i, data := range []string{
+		"REDIS0009\xfe\x00\x01\x01k\x81\xff\xff\xff\xff\xff\xff\xff\xff",
+		"REDIS0009\xfe\x00\x02\x01k\x80\x7f\xff\xff\xff\x01a",
+		"REDIS0009\xfe\x00\x00\x01k\x80\x3f\xff\xff\xffabc\xff",
+		"REDIS0009\xfe\x00\x0b\x01k\x0c\x02\x00\x00\x00\xff\xff\xff\x7f\x01\x00\x02\x00\xff",
+		"REDIS0009\xfe\x00\x00\x01k",
+	} {
+
+		input := filepath.Join(dir, "dump.rdb")
+		if err := ioutil.WriteFile(input, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		server := &Server{Input: input}
+		assert.NotPanics(t, func() {
+			_, err := server.Load(context.Background())
+			assert.Error(t, err, "file %d", i)
+			_, isConfigError := err.(*ConfigError)
+			assert.True(t, isConfigError, "file %d", i)
+		}, "file %d", i)
+	}
+}
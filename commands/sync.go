@@ -1,7 +1,10 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,23 +15,58 @@ import (
 )
 
 type Synchronizer struct {
-	Workers map[uint64]*SyncOneRound
+	Workers     map[uint64]*SyncOneRound
+	Policy      *ErrorPolicy
+	Limits      *RateLimits
+	Throttle    *Throttle
+	DatabaseMap *DatabaseMap
+	KeyRewriter *KeyRewriter
+	Conflict    *ConflictPolicy
+	Deletion    *DeletionGuard
+	Plan        *Plan
+	Chunker     *Chunker
+	Transfer    string
+	lock        sync.Mutex
+	result      Result
+	err         error
 }
 
 type SyncOneRound struct {
 	DatabaseId              uint64
+	DestinationDatabaseId   uint64
 	SourceClient            *redis.Client
 	DestinationClient       *redis.Client
+	MergedSourceClients     []*redis.Client
 	KeysPipeline            chan string
 	DestinationKeysPipeline chan string
 	Workers                 *lib.Workers
 	ThreadCount             int
 	IsSupportReplace        bool
+	Policy                  *ErrorPolicy
+	Limits                  *RateLimits
+	Throttle                *Throttle
+	KeyRewriter             *KeyRewriter
+	Conflict                *ConflictPolicy
+	Deletion                *DeletionGuard
+	Plan                    *Plan
+	Chunker                 *Chunker
+	Transfer                string
+	LogicalFallback         atomic.Bool
+	Count                   atomic.Uint64
+	Failed                  atomic.Uint64
+	Deleted                 atomic.Uint64
+	Skipped                 atomic.Uint64
 }
 
 type SyncWorker struct {
-	SourceClient      *redis.Client
-	DestinationClient *redis.Client
+	SourceClient        *redis.Client
+	Destination         *Destination
+	MergedSourceClients []*redis.Client
+	Policy              *ErrorPolicy
+	Limits              *RateLimits
+	Chunker             *Chunker
+	Transfer            string
+	LogicalFallback     *atomic.Bool
 }
 
 func (s *Synchronizer) InitClients(sourceHost, sourcePassword, destinationHost, destinationPassword string, dbCount uint64, threadCount int, isSupportReplace bool) {
@@ -38,7 +76,8 @@ func (s *Synchronizer) InitClients(sourceHost, sourcePassword, destinationHost,
 	for dbId := uint64(0); dbId < dbCount; dbId++ {
 
 		s.Workers[dbId] = &SyncOneRound{
-			DatabaseId: dbId,
+			DatabaseId:            dbId,
+			DestinationDatabaseId: s.DatabaseMap.Map(dbId),
 			SourceClient: redis.NewClient(&redis.Options{
 				Addr:        sourceHost,
 				Password:    sourcePassword,
@@ -49,18 +88,67 @@ func (s *Synchronizer) InitClients(sourceHost, sourcePassword, destinationHost,
 			DestinationClient: redis.NewClient(&redis.Options{
 				Addr:         destinationHost,
 				Password:     destinationPassword,
-				DB:           int(dbId),
+				DB:           int(s.DatabaseMap.Map(dbId)),
 				PoolSize:     threadCount,
 				ReadTimeout:  300 * time.Second,
 				WriteTimeout: 300 * time.Second,
 			}),
 			ThreadCount:      threadCount,
 			IsSupportReplace: isSupportReplace,
+			Policy:           s.Policy,
+			Limits:           s.Limits,
+			Throttle:         s.Throttle,
+			KeyRewriter:      s.KeyRewriter,
+			Conflict:         s.Conflict,
+			Deletion:         s.Deletion,
+			Plan:             s.Plan,
+			Chunker:          s.Chunker,
+			Transfer:         s.Transfer,
 		}
 	}
+
+	// when several source databases are merged into one destination database,
+	// a destination key is extraneous only if none of them has it
+	for _, round := range s.Workers {
+
+		for _, other := range s.Workers {
+
+			if other != round && other.DestinationDatabaseId == round.DestinationDatabaseId {
+				round.MergedSourceClients = append(round.MergedSourceClients, other.SourceClient)
+			}
+		}
+	}
+}
+
+func (s *Synchronizer) Ping(sourceHost, destinationHost string) error {
+
+	for _, worker := range s.Workers {
+
+		if err := ping(worker.SourceClient, sourceHost); err != nil {
+			return err
+		}
+
+		if err := ping(worker.DestinationClient, destinationHost); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Synchronizer) CloseClients() {
+
+	for _, worker := range s.Workers {
+
+		worker.SourceClient.Close()
+		worker.DestinationClient.Close()
+	}
 }
 
-func (s *Synchronizer) Go(syncTimes uint64) {
+func (s *Synchronizer) Go(ctx context.Context, syncTimes uint64) (Result, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	var wg sync.WaitGroup
 	log.Println("Starting synchronizer")
@@ -68,73 +156,172 @@ func (s *Synchronizer) Go(syncTimes uint64) {
 
 		wg.Add(1)
 		go func(worker *SyncOneRound, syncTimes uint64) {
+
+			defer wg.Done()
+			isLoop := syncTimes == 0
 			for {
-				if worker.Sync() <= 0 {
+				result, err := worker.Sync(ctx)
+				if isLoop && errors.Is(err, context.Canceled) {
 
-					time.Sleep(time.Second)
+					// interrupting an endless synchronization is the normal way to stop it
+					s.report(result, nil)
+					return
+				}
+
+				s.report(result, err)
+				if err != nil {
+
+					log.Printf("Synchronize database(%d) aborted, %s\n", worker.DatabaseId, err)
+					cancel()
+					return
 				}
 
 				if syncTimes > 0 {
 
 					syncTimes--
 					if syncTimes <= 0 {
-						break
+						return
 					}
 				}
-			}
 
-			wg.Done()
+				if result.Succeeded+result.Deleted <= 0 {
+
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(time.Second):
+					}
+				}
+			}
 		}(worker, syncTimes)
 	}
 
 	wg.Wait()
+	s.KeyRewriter.PrintReport()
+	s.Conflict.PrintReport()
+	s.Plan.PrintReport()
+
+	return s.result, s.err
 }
 
-func (round *SyncOneRound) Sync() (count uint64) {
+func (s *Synchronizer) report(result Result, err error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.result.Merge(result)
+	if s.err == nil {
+		s.err = err
+	}
+}
 
-	log.Printf("Start %d database thread\n", round.DatabaseId)
-	round.InitChannel()
-	go round.ReadKeys()
+func (round *SyncOneRound) Sync(ctx context.Context) (result Result, err error) {
 
-	count = round.SyncData()
+	if round.DestinationDatabaseId != round.DatabaseId {
+		log.Printf("Start %d database thread, into destination database %d\n", round.DatabaseId, round.DestinationDatabaseId)
+	} else {
+		log.Printf("Start %d database thread\n", round.DatabaseId)
+	}
+	round.InitChannel(ctx)
+	round.KeyRewriter.Reset(round.DatabaseId, round.DestinationDatabaseId)
+	round.Throttle.Attach(round.Workers)
+	defer round.Throttle.Detach(round.Workers)
 
-	go round.ReadDestinationKeys()
-	count += round.CheckNotExistKeys()
+	go round.ReadKeys(round.Workers.Context())
 
-	log.Printf("Synchronized database(%d) %d records.", round.DatabaseId, count)
+	err = round.SyncData(ctx)
+	result = round.Result()
+	if err != nil {
+		return
+	}
+
+	if round.Deletion != nil {
+
+		go round.ReadDestinationKeys(round.Workers.Context())
+		err = round.CheckNotExistKeys(ctx)
+		result = round.Result()
+		if err != nil {
+			return
+		}
+	}
+
+	log.Printf("Synchronized database(%d) %d records.", round.DatabaseId, result.Succeeded+result.Deleted)
 
 	return
 }
 
-func (round *SyncOneRound) InitChannel() {
+func (round *SyncOneRound) Result() Result {
 
+	return Result{
+		Succeeded: round.Count.Load(),
+		Failed:    round.Failed.Load(),
+		Deleted:   round.Deleted.Load(),
+		Skipped:   round.Skipped.Load(),
+	}
+}
+
+func (round *SyncOneRound) InitChannel(ctx context.Context) {
+
+	round.Count.Store(0)
+	round.Failed.Store(0)
+	round.Deleted.Store(0)
+	round.Skipped.Store(0)
 	round.KeysPipeline = make(chan string, 1000)
 	round.DestinationKeysPipeline = make(chan string, 1000)
-	round.Workers = lib.NewWorkers(round.ThreadCount, func() interface{} {
+	round.Workers = lib.NewWorkers(ctx, round.ThreadCount, func() interface{} {
 		return &SyncWorker{
-			SourceClient:      round.SourceClient,
-			DestinationClient: round.DestinationClient,
+			SourceClient: round.SourceClient,
+			Destination: &Destination{
+				Client:                  round.DestinationClient,
+				DatabaseId:              round.DestinationDatabaseId,
+				Policy:                  round.Policy,
+				Limits:                  round.Limits,
+				Conflict:                round.Conflict,
+				Plan:                    round.Plan,
+				IsSupportReplaceRestore: round.IsSupportReplace,
+			},
+			MergedSourceClients: round.MergedSourceClients,
+			Policy:              round.Policy,
+			Limits:              round.Limits,
+			Chunker:             round.Chunker,
+			Transfer:            round.Transfer,
+			LogicalFallback:     &round.LogicalFallback,
 		}
 	})
 }
 
-func (round *SyncOneRound) ReadKeys() {
+func (round *SyncOneRound) ReadKeys(ctx context.Context) {
+
+	defer close(round.KeysPipeline)
 
 	log.Printf("Scan database(%d) start\n", round.DatabaseId)
 	var currentCursor, keyCount uint64
 	for {
 
-		keys, nextCursor, err := round.SourceClient.Scan(currentCursor, "", 1000).Result()
+		var keys []string
+		var nextCursor uint64
+		err := round.Policy.Retry(ctx, "SCAN", func() (err error) {
+			if err = round.Limits.Read(ctx, 1, 0); err != nil {
+				return
+			}
+			keys, nextCursor, err = round.SourceClient.Scan(currentCursor, "", 1000).Result()
+			return
+		})
 
 		if err != nil {
 
 			log.Printf("Scan database(%d) error , %s\n", currentCursor, err)
-			break
+			round.Workers.Fail(err)
+			return
 		}
 
 		for _, key := range keys {
 
-			round.KeysPipeline <- key
+			select {
+			case round.KeysPipeline <- key:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		if nextCursor == 0 {
@@ -146,89 +333,117 @@ func (round *SyncOneRound) ReadKeys() {
 		keyCount += uint64(len(keys))
 	}
 
-	close(round.KeysPipeline)
 	log.Printf("Scan database(%d) finished\n", round.DatabaseId)
 }
 
-func (round *SyncOneRound) SyncData() uint64 {
-
-	var count atomic.Uint64
-	for {
-		key := round.getKey()
-		if key == "" {
-			break
-		}
+func (round *SyncOneRound) SyncData(ctx context.Context) error {
 
-		worker := round.getWorker()
+	for key := range round.KeysPipeline {
 
-		go func(key string) {
+		key := key
+		err := round.Workers.Go(ctx, func(ctx context.Context, w interface{}) error {
 
-			defer func() {
-				round.putWorker(worker)
-			}()
+			worker := w.(*SyncWorker)
+			failed := &Record{DatabaseId: round.DatabaseId, Key: key}
+			var restored bool
+			err := round.Policy.Do(ctx, func() error {
 
-			record, err := worker.dump(key)
-			if err != nil {
-				log.Printf("Dump key \"%s\" error, %s\n", key, err)
-				return
-			}
+				keyType, err := round.Chunker.IsBig(ctx, worker.SourceClient, key, round.Policy, round.Limits)
+				if err != nil {
+					log.Printf("Get key \"%s\" size error, %s\n", key, err)
+					return err
+				}
 
-			if !round.IsSupportReplace {
-				worker.removeDestinationKey(record.Key)
-				err = worker.restore(record)
-			} else {
-				err = worker.restoreReplace(record)
-			}
+				if keyType != "" {
 
-			if err != nil {
-				log.Printf("Restore key \"%s\" error, %s\n", key, err)
-				return
-			}
+					destinationKey, err := round.KeyRewriter.Claim(round.DatabaseId, round.DestinationDatabaseId, key)
+					if err != nil {
+						log.Printf("Rewrite key \"%s\" error, %s\n", key, err)
+						return err
+					}
 
-			count.Inc()
-		}(key)
-	}
+					restored, err = worker.transferChunks(ctx, key, destinationKey, keyType)
+					if err != nil {
+						log.Printf("Transfer big key \"%s\" error, %s\n", key, err)
+					}
+					return err
+				}
 
-	round.Workers.Wait()
+				record, err := worker.dump(ctx, key)
+				if err != nil {
+					log.Printf("Dump key \"%s\" error, %s\n", key, err)
+					return err
+				}
 
-	return count.Load()
-}
+				failed = record.Record(round.DatabaseId)
+				record.Key, err = round.KeyRewriter.Claim(round.DatabaseId, round.DestinationDatabaseId, key)
+				if err != nil {
+					log.Printf("Rewrite key \"%s\" error, %s\n", key, err)
+					return err
+				}
 
-func (round *SyncOneRound) getKey() string {
+				restored, err = worker.restore(ctx, key, record)
+				if err != nil {
+					log.Printf("Restore key \"%s\" error, %s\n", key, err)
+				}
 
-	var key string
+				return err
+			})
 
-	for {
-		select {
-		case key = <-round.KeysPipeline:
+			if err != nil {
+				round.Failed.Inc()
+				return round.Policy.Failed(failed, err)
+			}
 
-			return key
+			if !restored {
+				round.Skipped.Inc()
+				return nil
+			}
 
-		default:
+			round.Count.Inc()
+			return nil
+		})
 
-			time.Sleep(10 * time.Millisecond)
-			continue
+		if err != nil {
+			break
 		}
 	}
+
+	return round.Workers.Wait(ctx)
 }
 
-func (round *SyncOneRound) ReadDestinationKeys() {
+func (round *SyncOneRound) ReadDestinationKeys(ctx context.Context) {
+
+	defer close(round.DestinationKeysPipeline)
 
 	log.Printf("Scan destination database(%d) start\n", round.DatabaseId)
 	var currentCursor uint64
 	for {
 
-		keys, nextCursor, err := round.DestinationClient.Scan(currentCursor, "", 100).Result()
+		var keys []string
+		var nextCursor uint64
+		err := round.Policy.Retry(ctx, "SCAN", func() (err error) {
+			if err = round.Limits.Write(ctx, 1, 0); err != nil {
+				return
+			}
+			keys, nextCursor, err = round.DestinationClient.Scan(currentCursor, "", 100).Result()
+			return
+		})
 
 		if err != nil {
 
 			log.Printf("Scan destination database(%d) error , %s\n", currentCursor, err)
-			break
+			round.Workers.Fail(err)
+			return
 		}
 
 		for _, key := range keys {
 
-			round.DestinationKeysPipeline <- key
+			select {
+			case round.DestinationKeysPipeline <- key:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		if nextCursor == 0 {
@@ -239,130 +454,221 @@ func (round *SyncOneRound) ReadDestinationKeys() {
 		currentCursor = nextCursor
 	}
 
-	close(round.DestinationKeysPipeline)
 	log.Printf("Scan destination database(%d) finished\n", round.DatabaseId)
 }
 
-func (round *SyncOneRound) CheckNotExistKeys() uint64 {
+// CheckNotExistKeys collects the destination keys missing on the source, then
+// deletes them once the deletion guard accepts their number.
+func (round *SyncOneRound) CheckNotExistKeys(ctx context.Context) error {
 
-	var count atomic.Uint64
-	for {
-		key := round.getDestinationKey()
-		if key == "" {
-			break
-		}
+	var (
+		lock       sync.Mutex
+		extraneous []string
+		total      uint64
+	)
 
-		worker := round.getWorker()
+	for key := range round.DestinationKeysPipeline {
 
-		go func(key string) {
+		total++
+		if round.Deletion.IsProtected(key) {
+			continue
+		}
 
-			defer func() {
-				round.putWorker(worker)
-			}()
+		key := key
+		err := round.Workers.Go(ctx, func(ctx context.Context, w interface{}) error {
 
-			if worker.sourceExist(key) {
+			worker := w.(*SyncWorker)
+			sourceKeys, isOwned := round.KeyRewriter.Reverse(key)
+			if !isOwned || worker.sourceExist(ctx, sourceKeys...) {
 
-				return
+				return nil
 			}
 
-			err := worker.removeDestinationKey(key)
-			if err != nil {
-				log.Printf("Remove key \"%s\" error, %s\n", key, err)
-				return
-			}
+			lock.Lock()
+			extraneous = append(extraneous, key)
+			lock.Unlock()
+			return nil
+		})
 
-			count.Inc()
-		}(key)
+		if err != nil {
+			break
+		}
 	}
 
-	round.Workers.Wait()
+	if err := round.Workers.Wait(ctx); err != nil {
+		return err
+	}
 
-	return count.Load()
-}
+	if err := round.Deletion.Check(round.DestinationDatabaseId, uint64(len(extraneous)), total); err != nil {
 
-func (round *SyncOneRound) getDestinationKey() string {
+		// only this round deletes nothing, the next one checks again
+		log.Printf("Synchronize database(%d) deletion skipped this round, %s\n", round.DatabaseId, err)
+		return nil
+	}
 
-	var key string
+	if round.Deletion.DryRun {
 
-	for {
-		select {
-		case key = <-round.DestinationKeysPipeline:
+		round.Deletion.List(round.DestinationDatabaseId, extraneous)
+		return nil
+	}
 
-			return key
+	for _, key := range extraneous {
 
-		default:
+		key := key
+		err := round.Workers.Go(ctx, func(ctx context.Context, w interface{}) error {
 
-			time.Sleep(10 * time.Millisecond)
-			continue
-		}
-	}
-}
+			worker := w.(*SyncWorker)
+			err := round.Policy.Do(ctx, func() error {
+				return worker.Destination.Delete(ctx, key)
+			})
 
-func (round *SyncOneRound) getWorker() *SyncWorker {
+			if err != nil {
+				log.Printf("Remove key \"%s\" error, %s\n", key, err)
+				round.Failed.Inc()
+				return round.Policy.Failed(nil, err)
+			}
 
-	return round.Workers.Get().(*SyncWorker)
-}
+			sourceKeys, _ := round.KeyRewriter.Reverse(key)
+			for _, sourceKey := range sourceKeys {
+				round.KeyRewriter.Release(round.DatabaseId, round.DestinationDatabaseId, sourceKey)
+			}
+			round.Deleted.Inc()
+			return nil
+		})
 
-func (round *SyncOneRound) putWorker(worker *SyncWorker) {
+		if err != nil {
+			break
+		}
+	}
 
-	round.Workers.Put(worker)
+	return round.Workers.Wait(ctx)
 }
 
-func (round *SyncWorker) dump(key string) (record TransferRecord, err error) {
+func (round *SyncWorker) dump(ctx context.Context, key string) (record TransferRecord, err error) {
 
 	record.Key = key
-	record.TTL, err = round.SourceClient.TTL(key).Result()
+	err = round.Policy.Retry(ctx, "PTTL", func() (err error) {
+		if err = round.Limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		record.TTL, err = round.SourceClient.PTTL(key).Result()
+		return
+	})
 	if err != nil {
 
 		return
 	}
 
-	record.Value, err = round.SourceClient.Dump(key).Result()
+	if round.isLogical() {
+
+		record.Type, record.Value, err = ReadLogical(ctx, round.SourceClient, key, round.Policy, round.Limits)
+		return
+	}
+
+	err = round.Policy.Retry(ctx, "DUMP", func() (err error) {
+		if err = round.Limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		record.Value, err = round.SourceClient.Dump(key).Result()
+		return
+	})
 	if err != nil {
 
 		return
 	}
 
+	err = round.Limits.Read(ctx, 0, len(record.Value))
 	return
 }
 
-func (round *SyncWorker) restoreReplace(record TransferRecord) (err error) {
+// transferChunks copies a big key chunk by chunk, a retry starts over from the
+// first chunk which replaces the partly written key.
+func (round *SyncWorker) transferChunks(ctx context.Context, key, destinationKey, keyType string) (restored bool, err error) {
 
-	if record.TTL > 0 {
-		_, err = round.DestinationClient.RestoreReplace(record.Key, record.TTL, record.Value).Result()
-	} else {
-		_, err = round.DestinationClient.RestoreReplace(record.Key, 0, record.Value).Result()
+	var ttl time.Duration
+	err = round.Policy.Retry(ctx, "PTTL", func() (err error) {
+		if err = round.Limits.Read(ctx, 1, 0); err != nil {
+			return
+		}
+		ttl, err = round.SourceClient.PTTL(key).Result()
+		return
+	})
+	if err != nil {
+		return
 	}
 
-	return
-}
+	log.Printf("Big %s key \"%s\", transferred in chunks of %d\n", keyType, key, round.Chunker.ChunkSize)
+	reader := round.Chunker.Reader(round.SourceClient, key, keyType, round.Policy, round.Limits)
+	for part := uint64(1); ; part++ {
 
-func (round *SyncWorker) restore(record TransferRecord) (err error) {
+		value, err := reader.Next(ctx)
+		if err != nil {
+			return false, err
+		}
 
-	if record.TTL > 0 {
-		_, err = round.DestinationClient.Restore(record.Key, record.TTL, record.Value).Result()
-	} else {
-		_, err = round.DestinationClient.Restore(record.Key, 0, record.Value).Result()
+		restored, err = round.Destination.RestorePart(ctx, destinationKey, keyType, part, !reader.Done(), ttl, value)
+		if !restored || err != nil || reader.Done() {
+			return restored, err
+		}
 	}
+}
 
-	return
+// isLogical tells whether keys are copied by type instead of DUMP/RESTORE,
+// either as asked or since the destination rejected a DUMP payload.
+func (round *SyncWorker) isLogical() bool {
+
+	return round.Transfer == TransferLogical || round.LogicalFallback.Load()
 }
 
-func (round *SyncWorker) sourceExist(key string) bool {
+// restore writes a dumped key, when the destination cannot read the DUMP
+// payload the source key is read again by type and written logically.
+func (round *SyncWorker) restore(ctx context.Context, sourceKey string, record TransferRecord) (restored bool, err error) {
 
-	isExist, err := round.SourceClient.Exists(key).Result()
+	if record.Type != "" {
+		return round.Destination.RestorePart(ctx, record.Key, record.Type, 0, false, record.TTL, record.Value)
+	}
+
+	restored, err = round.Destination.Restore(ctx, record.Key, record.TTL, record.Value)
+	if !isBadPayload(err) {
+		return
+	}
+
+	if round.LogicalFallback.CAS(false, true) {
+		log.Printf("Destination rejected the payload of key \"%s\" (%s), switching to logical transfer\n", sourceKey, err)
+	}
+
+	record.Type, record.Value, err = ReadLogical(ctx, round.SourceClient, sourceKey, round.Policy, round.Limits)
 	if err != nil {
-		log.Printf("Judge Key in source error , key: %s , error: %s\n", key, err)
-		return true
+		return
 	}
 
-	return isExist != 0
+	return round.Destination.RestorePart(ctx, record.Key, record.Type, 0, false, record.TTL, record.Value)
 }
 
-func (round *SyncWorker) removeDestinationKey(key string) (err error) {
+func (round *SyncWorker) sourceExist(ctx context.Context, keys ...string) bool {
 
-	_, err = round.DestinationClient.Del(key).Result()
-	return
+	clients := append([]*redis.Client{round.SourceClient}, round.MergedSourceClients...)
+	for _, client := range clients {
+
+		var isExist int64
+		err := round.Policy.Retry(ctx, "EXISTS", func() (err error) {
+			if err = round.Limits.Read(ctx, 1, 0); err != nil {
+				return
+			}
+			isExist, err = client.Exists(keys...).Result()
+			return
+		})
+		if err != nil {
+			log.Printf("Judge Key in source error , key: %s , error: %s\n", strings.Join(keys, ", "), err)
+			return true
+		}
+
+		if isExist != 0 {
+			return true
+		}
+	}
+
+	return false
 }
 
 type SyncLauncher struct {
@@ -374,6 +680,17 @@ type SyncLauncher struct {
 	SyncTimes               uint64
 	ThreadCount             int
 	IsSupportReplaceRestore bool
+	Policy                  *ErrorPolicy
+	Limits                  *RateLimits
+	TargetLatency           time.Duration
+	ThrottleInterval        time.Duration
+	DatabaseMap             *DatabaseMap
+	KeyRewriter             *KeyRewriter
+	Conflict                *ConflictPolicy
+	Deletion                *DeletionGuard
+	Plan                    *Plan
+	Chunker                 *Chunker
+	Transfer                string
 }
 
 func (launcher *SyncLauncher) SetSourceHost(sourceHost string) *SyncLauncher {
@@ -424,21 +741,114 @@ func (launcher *SyncLauncher) SetIsSupportReplaceRestore(isSupportReplaceRestore
 	return launcher
 }
 
-func (launcher *SyncLauncher) Launch() {
+func (launcher *SyncLauncher) SetErrorPolicy(policy *ErrorPolicy) *SyncLauncher {
 
-	s := &Synchronizer{}
-	if launcher.DatabaseCount == 0 {
-		launcher.DatabaseCount = getDatabaseCount(launcher.SourceHost, launcher.SourcePassword)
-	}
+	launcher.Policy = policy
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetRateLimits(limits *RateLimits) *SyncLauncher {
+
+	launcher.Limits = limits
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetThrottle(targetLatency, interval time.Duration) *SyncLauncher {
+
+	launcher.TargetLatency = targetLatency
+	launcher.ThrottleInterval = interval
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetDatabaseMap(dbMap *DatabaseMap) *SyncLauncher {
+
+	launcher.DatabaseMap = dbMap
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetKeyRewriter(rewriter *KeyRewriter) *SyncLauncher {
+
+	launcher.KeyRewriter = rewriter
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetConflictPolicy(conflict *ConflictPolicy) *SyncLauncher {
+
+	launcher.Conflict = conflict
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetDeletionGuard(deletion *DeletionGuard) *SyncLauncher {
+
+	launcher.Deletion = deletion
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetPlan(plan *Plan) *SyncLauncher {
+
+	launcher.Plan = plan
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetChunker(chunker *Chunker) *SyncLauncher {
+
+	launcher.Chunker = chunker
+	return launcher
+}
+
+func (launcher *SyncLauncher) SetTransfer(transfer string) *SyncLauncher {
+
+	launcher.Transfer = transfer
+	return launcher
+}
 
+func (launcher *SyncLauncher) Launch(ctx context.Context) (result Result, err error) {
+
+	s := &Synchronizer{
+		Policy:      launcher.Policy,
+		Limits:      launcher.Limits,
+		DatabaseMap: launcher.DatabaseMap,
+		KeyRewriter: launcher.KeyRewriter,
+		Conflict:    launcher.Conflict,
+		Deletion:    launcher.Deletion,
+		Plan:        launcher.Plan,
+		Chunker:     launcher.Chunker,
+		Transfer:    launcher.Transfer,
+	}
 	if launcher.DatabaseCount == 0 {
+		launcher.DatabaseCount, err = getDatabaseCount(launcher.SourceHost, launcher.SourcePassword)
+		if err != nil {
+			return
+		}
+	}
 
-		log.Println("Get database count error.")
+	// the databases are synchronized at the same time, each with ThreadCount
+	s.Throttle, err = newThrottle(launcher.SourceHost, launcher.SourcePassword,
+		launcher.TargetLatency, launcher.ThrottleInterval, launcher.ThreadCount*int(launcher.DatabaseCount))
+	if err != nil {
 		return
 	}
+	defer s.Throttle.Close()
+
+	throttleCtx, stopThrottle := context.WithCancel(ctx)
+	defer stopThrottle()
+	go s.Throttle.Run(throttleCtx)
 
 	s.InitClients(launcher.SourceHost, launcher.SourcePassword,
 		launcher.DestinationHost, launcher.DestinationPassword,
 		launcher.DatabaseCount, launcher.ThreadCount, launcher.IsSupportReplaceRestore)
-	s.Go(launcher.SyncTimes)
+	defer s.CloseClients()
+
+	if err = s.Ping(launcher.SourceHost, launcher.DestinationHost); err != nil {
+		return
+	}
+
+	syncTimes := launcher.SyncTimes
+	if s.Plan != nil && syncTimes == 0 {
+
+		// a dry run plans a single round instead of looping forever
+		syncTimes = 1
+	}
+
+	return s.Go(ctx, syncTimes)
 }
@@ -0,0 +1,236 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+type SourceHealth struct {
+	Latency time.Duration
+}
+
+// Throttle polls the latency of the source server and shrinks the concurrency
+// while it is above TargetLatency, growing it back one worker at a time once
+// the latency is well under the target. The limit, at most MaxThreads, is
+// shared between the attached worker pools, each keeping one worker at least.
+type Throttle struct {
+	Client        *redis.Client
+	TargetLatency time.Duration
+	Interval      time.Duration
+	MaxThreads    int
+	limit         int
+	lock          sync.Mutex
+	workers       map[*lib.Workers]struct{}
+}
+
+func NewThrottle(client *redis.Client, targetLatency, interval time.Duration, maxThreads int) *Throttle {
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	return &Throttle{
+		Client:        client,
+		TargetLatency: targetLatency,
+		Interval:      interval,
+		MaxThreads:    maxThreads,
+		limit:         maxThreads,
+		workers:       make(map[*lib.Workers]struct{}),
+	}
+}
+
+func (t *Throttle) Attach(workers *lib.Workers) {
+
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.workers[workers] = struct{}{}
+	t.resize()
+}
+
+func (t *Throttle) Detach(workers *lib.Workers) {
+
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.workers, workers)
+	t.resize()
+}
+
+// resize shares the limit between the attached pools.
+func (t *Throttle) resize() {
+
+	if len(t.workers) == 0 {
+		return
+	}
+
+	share, extra := t.limit/len(t.workers), t.limit%len(t.workers)
+	for workers := range t.workers {
+
+		size := share
+		if extra > 0 {
+			size++
+			extra--
+		}
+		workers.Resize(size)
+	}
+}
+
+// Run polls the source until ctx is done.
+func (t *Throttle) Run(ctx context.Context) {
+
+	if t == nil {
+		return
+	}
+
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		health, err := t.Poll()
+		if err != nil {
+
+			log.Printf("Poll source health error, %s\n", err)
+			continue
+		}
+
+		t.adjust(health)
+	}
+}
+
+func (t *Throttle) Poll() (health SourceHealth, err error) {
+
+	start := time.Now()
+	if _, err = t.Client.Ping().Result(); err != nil {
+		return
+	}
+	health.Latency = time.Since(start)
+
+	if latest := t.latestCommandLatency(); latest > health.Latency {
+		health.Latency = latest
+	}
+
+	return
+}
+
+// latestCommandLatency reads the latency monitor, it only knows about events
+// above latency-monitor-threshold and is disabled by default, so an error or
+// an empty reply simply means PING round trip is the only measure.
+func (t *Throttle) latestCommandLatency() (latency time.Duration) {
+
+	reply, err := t.Client.Do("LATENCY", "LATEST").Result()
+	if err != nil {
+		return
+	}
+
+	events, ok := reply.([]interface{})
+	if !ok {
+		return
+	}
+
+	since := time.Now().Add(-2 * t.Interval).Unix()
+	for _, event := range events {
+
+		fields, ok := event.([]interface{})
+		if !ok || len(fields) < 3 {
+			continue
+		}
+
+		timestamp, _ := fields[1].(int64)
+		latest, _ := fields[2].(int64)
+		if timestamp < since {
+			continue
+		}
+
+		if d := time.Duration(latest) * time.Millisecond; d > latency {
+			latency = d
+		}
+	}
+
+	return
+}
+
+func (t *Throttle) adjust(health SourceHealth) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	limit := t.limit
+	if health.Latency > t.TargetLatency {
+		limit = limit / 2
+	} else if health.Latency < t.TargetLatency/2 {
+		limit++
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	if limit > t.MaxThreads {
+		limit = t.MaxThreads
+	}
+
+	if limit == t.limit {
+		return
+	}
+
+	log.Printf("Source %s (target latency %s), concurrency %d -> %d\n", health, t.TargetLatency, t.limit, limit)
+
+	t.limit = limit
+	t.resize()
+}
+
+func (t *Throttle) Close() {
+
+	if t == nil {
+		return
+	}
+
+	t.Client.Close()
+}
+
+func newThrottle(host, password string, targetLatency, interval time.Duration, maxThreads int) (*Throttle, error) {
+
+	if targetLatency <= 0 {
+		return nil, nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     host,
+		Password: password,
+	})
+
+	if err := ping(client, host); err != nil {
+
+		client.Close()
+		return nil, err
+	}
+
+	return NewThrottle(client, targetLatency, interval, maxThreads), nil
+}
+
+func (health SourceHealth) String() string {
+
+	return fmt.Sprintf("latency %s", health.Latency)
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
+)
+
+func TestThrottle_Adjust(t *testing.T) {
+
+	ctx := context.Background()
+	newWorkers := func() *lib.Workers {
+		return lib.NewWorkers(ctx, 8, func() interface{} { return nil })
+	}
+
+	throttle := NewThrottle(nil, 100*time.Millisecond, 0, 8)
+	first, second := newWorkers(), newWorkers()
+	throttle.Attach(first)
+	throttle.Attach(second)
+	assert.Equal(t, []int{4, 4}, []int{first.Limit(), second.Limit()})
+
+	steps := []struct {
+		name    string
+		latency time.Duration
+		limit   int
+		limits  []int
+	}{
+		{"slow source halves", 200 * time.Millisecond, 4, []int{2, 2}},
+		{"odd limit shared", 10 * time.Millisecond, 5, []int{3, 2}},
+		{"near the target keeps", 70 * time.Millisecond, 5, []int{3, 2}},
+		{"slow again", 200 * time.Millisecond, 2, []int{1, 1}},
+		{"every pool keeps one worker", 200 * time.Millisecond, 1, []int{1, 1}},
+	}
+
+	for _, step := range steps {
+
+		throttle.adjust(SourceHealth{Latency: step.latency})
+		assert.Equal(t, step.limit, throttle.limit, step.name)
+
+		limits := []int{first.Limit(), second.Limit()}
+		if limits[0] < limits[1] {
+			limits[0], limits[1] = limits[1], limits[0]
+		}
+		assert.Equal(t, step.limits, limits, step.name)
+	}
+
+	// a detached pool gives its share back, up to MaxThreads
+	throttle.Detach(second)
+	for i := 0; i < 10; i++ {
+		throttle.adjust(SourceHealth{Latency: time.Millisecond})
+	}
+	assert.Equal(t, 8, throttle.limit)
+	assert.Equal(t, 8, first.Limit())
+
+	var none *Throttle
+	none.Attach(first)
+	none.Detach(first)
+	none.Run(ctx)
+}
+
+func TestThrottle_Poll(t *testing.T) {
+
+	fake := newFakeRedis(t)
+	defer fake.Close()
+
+	throttle := NewThrottle(redis.NewClient(&redis.Options{Addr: fake.Addr}), time.Second, 0, 1)
+	defer throttle.Close()
+
+	health, err := throttle.Poll()
+	assert.NoError(t, err)
+	assert.True(t, health.Latency > 0)
+	assert.Equal(t, time.Second, throttle.Interval)
+}
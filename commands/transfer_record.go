@@ -8,4 +8,27 @@ type TransferRecord struct {
 	Key   string        `json:"key"`
 	Value string        `json:"value"`
 	TTL   time.Duration `json:"ttl"`
+	Type  string        `json:"type,omitempty"`
+}
+
+func (record TransferRecord) Record(dbId uint64) *Record {
+
+	return &Record{
+		DatabaseId: dbId,
+		Key:        record.Key,
+		Value:      record.Value,
+		TTL:        ttlSeconds(record.TTL),
+		Type:       record.Type,
+	}
+}
+
+// ttlSeconds converts a PTTL reply into the whole seconds kept in the dump file,
+// rounding up so a key about to expire does not become persistent.
+func ttlSeconds(ttl time.Duration) int64 {
+
+	if ttl <= 0 {
+		return -1
+	}
+
+	return int64((ttl + time.Second - 1) / time.Second)
 }
@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	jitterLock sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+type Backoff struct {
+	MaxAttempts int
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+}
+
+// Delay is the pause before the attempt following attempt (counted from 1),
+// growing by Multiplier up to Max, then spread by +/- Jitter of itself.
+func (b *Backoff) Delay(attempt int) time.Duration {
+
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(b.Initial)
+	for i := 1; i < attempt; i++ {
+
+		delay *= multiplier
+		if b.Max > 0 && delay >= float64(b.Max) {
+			break
+		}
+	}
+
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+
+		jitterLock.Lock()
+		delay += delay * b.Jitter * (2*jitterRand.Float64() - 1)
+		jitterLock.Unlock()
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	return time.Duration(delay)
+}
+
+// Retry runs op until it succeeds, returns an error retryable refuses, or
+// MaxAttempts is reached. A nil Backoff runs op once.
+func (b *Backoff) Retry(ctx context.Context, op func() error, retryable func(err error) bool) (err error) {
+
+	for attempt := 1; ; attempt++ {
+
+		err = op()
+		if err == nil || b == nil || attempt >= b.MaxAttempts || !retryable(err) {
+			return
+		}
+
+		if sleepErr := Sleep(ctx, b.Delay(attempt)); sleepErr != nil {
+			return
+		}
+	}
+}
+
+func Sleep(ctx context.Context, d time.Duration) error {
+
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
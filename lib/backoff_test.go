@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+
+	backoff := &Backoff{
+		Initial:    100 * time.Millisecond,
+		Max:        time.Second,
+		Multiplier: 2,
+	}
+
+	assert.Equal(t, 100*time.Millisecond, backoff.Delay(1))
+	assert.Equal(t, 200*time.Millisecond, backoff.Delay(2))
+	assert.Equal(t, 400*time.Millisecond, backoff.Delay(3))
+	assert.Equal(t, 800*time.Millisecond, backoff.Delay(4))
+	assert.Equal(t, time.Second, backoff.Delay(5))
+	assert.Equal(t, time.Second, backoff.Delay(100))
+
+	backoff.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+
+		delay := backoff.Delay(2)
+		assert.True(t, delay >= 100*time.Millisecond && delay <= 300*time.Millisecond, "delay %s", delay)
+	}
+}
+
+func TestBackoff_Retry(t *testing.T) {
+
+	transient := errors.New("transient")
+	fatal := errors.New("fatal")
+	retryable := func(err error) bool {
+		return err == transient
+	}
+
+	backoff := &Backoff{
+		MaxAttempts: 3,
+		Initial:     time.Millisecond,
+		Multiplier:  2,
+	}
+
+	var attempts int
+	err := backoff.Retry(context.Background(), func() error {
+		attempts++
+		return transient
+	}, retryable)
+	assert.Equal(t, transient, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = backoff.Retry(context.Background(), func() error {
+		attempts++
+		return fatal
+	}, retryable)
+	assert.Equal(t, fatal, err)
+	assert.Equal(t, 1, attempts)
+
+	attempts = 0
+	err = backoff.Retry(context.Background(), func() error {
+		attempts++
+		if attempts < 2 {
+			return transient
+		}
+		return nil
+	}, retryable)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	err = (*Backoff)(nil).Retry(context.Background(), func() error {
+		attempts++
+		return transient
+	}, retryable)
+	assert.Equal(t, transient, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestBackoff_RetryCancel(t *testing.T) {
+
+	backoff := &Backoff{
+		MaxAttempts: 10,
+		Initial:     time.Hour,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var attempts int
+	start := time.Now()
+	err := backoff.Retry(ctx, func() error {
+		attempts++
+		return errors.New("transient")
+	}, func(err error) bool {
+		return true
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.True(t, time.Since(start) < time.Second)
+}
@@ -0,0 +1,35 @@
+package lib
+
+// crc64Table is the reflected table of the Jones polynomial used by redis for
+// DUMP payloads and RDB files.
+var crc64Table = makeCRC64Table(0x95ac9329ac4bc9b5)
+
+func makeCRC64Table(poly uint64) *[256]uint64 {
+
+	table := new([256]uint64)
+	for i := range table {
+
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+
+	return table
+}
+
+// CRC64 continues the redis checksum crc with data, 0 starting a new one.
+// Unlike hash/crc64 it neither inverts the initial value nor the result.
+func CRC64(crc uint64, data []byte) uint64 {
+
+	for _, b := range data {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+
+	return crc
+}
@@ -0,0 +1,17 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRC64(t *testing.T) {
+
+	// the check value of redis crc64.c
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), CRC64(0, []byte("123456789")))
+
+	crc := CRC64(0, []byte("1234"))
+	assert.Equal(t, CRC64(0, []byte("123456789")), CRC64(crc, []byte("56789")))
+	assert.Equal(t, uint64(0), CRC64(0, nil))
+}
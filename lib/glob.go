@@ -0,0 +1,108 @@
+package lib
+
+// MatchGlob reports whether key matches pattern with the redis KEYS/SCAN MATCH
+// rules: * and ? match any run of characters and any single character, [abc],
+// [^abc] and [a-z] match a character class and \ escapes the next character.
+// Unlike path.Match, * also matches separators such as / or :.
+func MatchGlob(pattern, key string) bool {
+
+	for len(pattern) > 0 {
+
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(key); i++ {
+				if MatchGlob(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+
+			return false
+
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			key = key[1:]
+
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+
+			var isMatch bool
+			isMatch, pattern = matchClass(pattern[1:], key[0])
+			if !isMatch {
+				return false
+			}
+			key = key[1:]
+			continue
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			key = key[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(key) == 0
+}
+
+// matchClass matches c against the class starting right after '[' and returns
+// the pattern left after the closing ']'.
+func matchClass(pattern string, c byte) (bool, string) {
+
+	isNot := len(pattern) > 0 && pattern[0] == '^'
+	if isNot {
+		pattern = pattern[1:]
+	}
+
+	isMatch := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			pattern = pattern[1:]
+			if pattern[0] == c {
+				isMatch = true
+			}
+
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				isMatch = true
+			}
+			pattern = pattern[2:]
+
+		case pattern[0] == c:
+			isMatch = true
+		}
+
+		pattern = pattern[1:]
+	}
+
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return isMatch != isNot, pattern
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+
+	cases := []struct {
+		pattern string
+		key     string
+		isMatch bool
+	}{
+		{"*", "", true},
+		{"*", "user:1/profile", true},
+		{"user:*", "user:1/profile", true},
+		{"user:*", "session:1", false},
+		{"*:lock", "job:42:lock", true},
+		{"*:lock", "job:42:locked", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"a**b", "ab", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+
+	for _, c := range cases {
+
+		assert.Equal(t, c.isMatch, MatchGlob(c.pattern, c.key), "%q ~ %q", c.pattern, c.key)
+	}
+}
@@ -0,0 +1,26 @@
+package lib
+
+import "strings"
+
+// KeyPrefix returns the first depth segments of key split on delimiter,
+// delimiter included, e.g. "service:entity:" for "service:entity:42" at depth
+// 2. The last segment is never part of the prefix, so a key with fewer
+// segments gets a shorter prefix and a key without delimiter gets "".
+func KeyPrefix(key, delimiter string, depth int) string {
+
+	if delimiter == "" || depth <= 0 {
+		return ""
+	}
+
+	end := 0
+	for i := 0; i < depth; i++ {
+
+		next := strings.Index(key[end:], delimiter)
+		if next < 0 {
+			break
+		}
+		end += next + len(delimiter)
+	}
+
+	return key[:end]
+}
@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPrefix(t *testing.T) {
+
+	cases := []struct {
+		key       string
+		delimiter string
+		depth     int
+		prefix    string
+	}{
+		{"service:entity:42", ":", 1, "service:"},
+		{"service:entity:42", ":", 2, "service:entity:"},
+		{"service:entity:42", ":", 3, "service:entity:"},
+		{"service:42", ":", 2, "service:"},
+		{"counter", ":", 1, ""},
+		{"a::b", ":", 2, "a::"},
+		{"cache/user/1", "/", 1, "cache/"},
+		{"tenant--user--1", "--", 2, "tenant--user--"},
+		{"service:entity:42", ":", 0, ""},
+		{"service:entity:42", "", 1, ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.prefix, KeyPrefix(c.key, c.delimiter, c.depth), "%q %q %d", c.key, c.delimiter, c.depth)
+	}
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type RateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+// NewRateLimiter allows rate units per second on average and up to one second
+// worth of burst, it returns nil (no limit) when rate is not positive.
+func NewRateLimiter(rate float64) *RateLimiter {
+
+	if rate <= 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// Wait takes n units, sleeping until the bucket can afford them. n may exceed
+// the burst, e.g. a single big payload, the bucket then goes into debt and
+// later callers wait for it to be paid back.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+
+	if l == nil || n <= 0 {
+		return ctx.Err()
+	}
+
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.lock.Unlock()
+
+	return Sleep(ctx, wait)
+}
+
+func (l *RateLimiter) Rate() float64 {
+
+	if l == nil {
+		return 0
+	}
+
+	return l.rate
+}
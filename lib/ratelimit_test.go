@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+
+	assert.Nil(t, NewRateLimiter(0))
+	assert.Nil(t, NewRateLimiter(-1))
+
+	var limiter *RateLimiter
+	assert.NoError(t, limiter.Wait(context.Background(), 1000))
+	assert.Equal(t, float64(0), limiter.Rate())
+
+	assert.Equal(t, float64(10), NewRateLimiter(10).Rate())
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+
+	limiter := NewRateLimiter(100)
+
+	// the first second worth of tokens is available as burst
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, limiter.Wait(context.Background(), 1))
+	}
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	start = time.Now()
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, limiter.Wait(context.Background(), 1))
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 150*time.Millisecond, "elapsed %s", elapsed)
+	assert.True(t, elapsed < time.Second, "elapsed %s", elapsed)
+}
+
+func TestRateLimiter_WaitMoreThanBurst(t *testing.T) {
+
+	limiter := NewRateLimiter(1000)
+
+	start := time.Now()
+	assert.NoError(t, limiter.Wait(context.Background(), 1200))
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 150*time.Millisecond, "elapsed %s", elapsed)
+	assert.True(t, elapsed < time.Second, "elapsed %s", elapsed)
+}
+
+func TestRateLimiter_WaitCancel(t *testing.T) {
+
+	limiter := NewRateLimiter(1)
+	assert.NoError(t, limiter.Wait(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx, 100))
+	assert.True(t, time.Since(start) < time.Second)
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// TopN keeps the n items with the highest scores, it is not safe for
+// concurrent use.
+type TopN struct {
+	n     int
+	items topItems
+}
+
+type topItem struct {
+	score int64
+	value interface{}
+}
+
+// topItems is a min-heap, the lowest kept score is evicted first.
+type topItems []topItem
+
+func (t topItems) Len() int            { return len(t) }
+func (t topItems) Less(i, j int) bool  { return t[i].score < t[j].score }
+func (t topItems) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
+func (t *topItems) Push(x interface{}) { *t = append(*t, x.(topItem)) }
+func (t *topItems) Pop() interface{} {
+	old := *t
+	item := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return item
+}
+
+func NewTopN(n int) *TopN {
+
+	return &TopN{n: n}
+}
+
+func (t *TopN) Push(score int64, value interface{}) {
+
+	if t.n <= 0 {
+		return
+	}
+
+	if len(t.items) < t.n {
+		heap.Push(&t.items, topItem{score: score, value: value})
+		return
+	}
+
+	if score > t.items[0].score {
+		t.items[0] = topItem{score: score, value: value}
+		heap.Fix(&t.items, 0)
+	}
+}
+
+// Values returns the kept values, highest score first.
+func (t *TopN) Values() []interface{} {
+
+	items := make(topItems, len(t.items))
+	copy(items, t.items)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].score > items[j].score })
+
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		values[i] = item.value
+	}
+
+	return values
+}
@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopN(t *testing.T) {
+
+	top := NewTopN(3)
+	for _, score := range []int64{5, 1, 9, 3, 7, 2} {
+		top.Push(score, score)
+	}
+
+	assert.Equal(t, []interface{}{int64(9), int64(7), int64(5)}, top.Values())
+
+	empty := NewTopN(0)
+	empty.Push(1, "a")
+	assert.Empty(t, empty.Values())
+
+	few := NewTopN(5)
+	few.Push(1, "a")
+	few.Push(2, "b")
+	assert.Equal(t, []interface{}{"b", "a"}, few.Values())
+}
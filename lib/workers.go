@@ -1,17 +1,28 @@
 package lib
 
 import (
+	"context"
 	"sync"
 )
 
 type Workers struct {
-	count chan interface{}
-	wg    sync.WaitGroup
+	count  chan interface{}
+	parked []interface{}
+	size   int
+	limit  int
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	err    error
 }
 
-func NewWorkers(n int, initFunc func() interface{}) *Workers {
+func NewWorkers(ctx context.Context, n int, initFunc func() interface{}) *Workers {
 	ws := Workers{}
 	ws.count = make(chan interface{}, n)
+	ws.size = n
+	ws.limit = n
+	ws.ctx, ws.cancel = context.WithCancel(ctx)
 	for i := 0; i < n; i++ {
 		ws.count <- initFunc()
 	}
@@ -19,22 +30,170 @@ func NewWorkers(n int, initFunc func() interface{}) *Workers {
 	return &ws
 }
 
-func (ws *Workers) Get() interface{} {
+// Context is cancelled as soon as a task fails or the parent context is done,
+// tasks should check it before issuing any further request.
+func (ws *Workers) Context() context.Context {
+
+	return ws.ctx
+}
+
+func (ws *Workers) Get(ctx context.Context) (interface{}, error) {
+
+	if err := ws.done(ctx); err != nil {
+		return nil, err
+	}
+
 	ws.wg.Add(1)
-	return <-ws.count
+	select {
+	case obj := <-ws.count:
+		return obj, nil
+	case <-ctx.Done():
+		ws.wg.Done()
+		return nil, ctx.Err()
+	case <-ws.ctx.Done():
+		ws.wg.Done()
+		return nil, ws.Err()
+	}
 }
 
 func (ws *Workers) Put(obj interface{}) {
+
+	ws.mu.Lock()
+	if ws.size-len(ws.parked) > ws.limit {
+
+		ws.parked = append(ws.parked, obj)
+		ws.mu.Unlock()
+		ws.wg.Done()
+		return
+	}
+	ws.mu.Unlock()
+
 	ws.count <- obj
 	ws.wg.Done()
 }
 
-func (ws *Workers) Wait() {
+// Resize changes how many workers may run at the same time, between 1 and the
+// number of workers created. Idle workers are parked at once, busy ones when
+// they are put back. It returns the effective limit.
+func (ws *Workers) Resize(n int) int {
+
+	if n < 1 {
+		n = 1
+	}
+
+	if n > ws.size {
+		n = ws.size
+	}
 
-	ws.wg.Wait()
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.limit = n
+	for len(ws.parked) > 0 && ws.size-len(ws.parked) < ws.limit {
+
+		last := len(ws.parked) - 1
+		ws.count <- ws.parked[last]
+		ws.parked = ws.parked[:last]
+	}
+
+	for ws.size-len(ws.parked) > ws.limit {
+
+		select {
+		case obj := <-ws.count:
+			ws.parked = append(ws.parked, obj)
+		default:
+			return n
+		}
+	}
+
+	return n
+}
+
+func (ws *Workers) Limit() int {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.limit
+}
+
+// Go runs task on an idle worker, the first error returned by a task is kept
+// and cancels the context handed to every other in-flight task.
+func (ws *Workers) Go(ctx context.Context, task func(ctx context.Context, worker interface{}) error) error {
+
+	worker, err := ws.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+
+		defer ws.Put(worker)
+
+		if err := task(ws.ctx, worker); err != nil {
+			ws.Fail(err)
+		}
+	}()
+
+	return nil
+}
+
+func (ws *Workers) Fail(err error) {
+
+	if err == nil {
+		return
+	}
+
+	ws.mu.Lock()
+	if ws.err == nil {
+		ws.err = err
+	}
+	ws.mu.Unlock()
+
+	ws.cancel()
+}
+
+func (ws *Workers) Err() error {
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.err != nil {
+		return ws.err
+	}
+
+	return ws.ctx.Err()
+}
+
+// Wait blocks until every task handed out has been put back, or ctx is done,
+// in which case the in-flight tasks are cancelled.
+func (ws *Workers) Wait(ctx context.Context) error {
+
+	finished := make(chan struct{})
+	go func() {
+		ws.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return ws.Err()
+	case <-ctx.Done():
+		ws.Fail(ctx.Err())
+		return ws.Err()
+	}
 }
 
 func (ws *Workers) IdleCount() int {
 
 	return len(ws.count)
 }
+
+func (ws *Workers) done(ctx context.Context) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return ws.Err()
+}
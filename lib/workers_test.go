@@ -1,35 +1,39 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
 )
 
 func TestNewWorkers(t *testing.T) {
 
 	var i int
 
-	workers := NewWorkers(5, func() interface{} {
+	workers := NewWorkers(context.Background(), 5, func() interface{} {
 		i++
 		return i
 	})
 
 	assert.Len(t, workers.count, 5)
 
-	worker := workers.Get()
+	worker, err := workers.Get(context.Background())
+	assert.NoError(t, err)
 	assert.NotNil(t, worker)
 
 	assert.True(t, worker.(int) < 5)
 
 	assert.Len(t, workers.count, 4)
 
-	workers.Get()
-	workers.Get()
-	workers.Get()
-	workers.Get()
+	workers.Get(context.Background())
+	workers.Get(context.Background())
+	workers.Get(context.Background())
+	workers.Get(context.Background())
 	assert.Len(t, workers.count, 0)
 
 	workers.Put(0)
@@ -44,19 +48,19 @@ func TestWorkers_Wait(t *testing.T) {
 
 	var i int
 
-	workers := NewWorkers(5, func() interface{} {
+	workers := NewWorkers(context.Background(), 5, func() interface{} {
 		i++
 		return i
 	})
 
-	workers.Wait()
+	workers.Wait(context.Background())
 
-	workers.Get()
+	workers.Get(context.Background())
 
 	var c chan int
 	c = make(chan int, 0)
 	f := func() {
-		workers.Wait()
+		workers.Wait(context.Background())
 		fmt.Println("must be not reach")
 		c <- 0
 	}
@@ -69,3 +73,124 @@ func TestWorkers_Wait(t *testing.T) {
 
 	}
 }
+
+func TestWorkers_GetCancel(t *testing.T) {
+
+	workers := NewWorkers(context.Background(), 1, func() interface{} {
+		return 1
+	})
+
+	_, err := workers.Get(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	worker, err := workers.Get(ctx)
+	assert.Nil(t, worker)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the failed Get must not be counted as in-flight
+	workers.Put(1)
+	assert.NoError(t, workers.Wait(context.Background()))
+}
+
+func TestWorkers_WaitTimeout(t *testing.T) {
+
+	workers := NewWorkers(context.Background(), 2, func() interface{} {
+		return 1
+	})
+
+	var cancelled atomic.Bool
+	err := workers.Go(context.Background(), func(ctx context.Context, worker interface{}) error {
+		<-ctx.Done()
+		cancelled.Store(true)
+		return ctx.Err()
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, workers.Wait(ctx))
+	assert.Equal(t, context.DeadlineExceeded, workers.Wait(context.Background()))
+	assert.True(t, cancelled.Load())
+}
+
+func TestWorkers_FirstError(t *testing.T) {
+
+	workers := NewWorkers(context.Background(), 3, func() interface{} {
+		return 1
+	})
+
+	first := errors.New("first")
+	second := errors.New("second")
+
+	workers.Go(context.Background(), func(ctx context.Context, worker interface{}) error {
+		return first
+	})
+	workers.Wait(context.Background())
+
+	err := workers.Go(context.Background(), func(ctx context.Context, worker interface{}) error {
+		return second
+	})
+	assert.Equal(t, first, err)
+
+	assert.Equal(t, first, workers.Wait(context.Background()))
+	assert.Equal(t, first, workers.Err())
+	assert.Error(t, workers.Context().Err())
+	assert.Equal(t, 3, workers.IdleCount())
+}
+
+func TestWorkers_ParentCancel(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	workers := NewWorkers(ctx, 1, func() interface{} {
+		return 1
+	})
+
+	cancel()
+
+	_, err := workers.Get(context.Background())
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, workers.Wait(context.Background()))
+}
+
+func TestWorkers_Resize(t *testing.T) {
+
+	workers := NewWorkers(context.Background(), 4, func() interface{} {
+		return 1
+	})
+
+	assert.Equal(t, 4, workers.Limit())
+	assert.Equal(t, 1, workers.Resize(0))
+	assert.Equal(t, 4, workers.Resize(10))
+
+	busy1, _ := workers.Get(context.Background())
+	busy2, _ := workers.Get(context.Background())
+
+	// two idle workers are parked at once, the busy ones when put back
+	assert.Equal(t, 1, workers.Resize(1))
+	assert.Equal(t, 1, workers.Limit())
+	assert.Equal(t, 0, workers.IdleCount())
+
+	workers.Put(busy1)
+	assert.Equal(t, 0, workers.IdleCount())
+	workers.Put(busy2)
+	assert.Equal(t, 1, workers.IdleCount())
+
+	worker, err := workers.Get(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = workers.Get(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	workers.Put(worker)
+	assert.Equal(t, 3, workers.Resize(3))
+	assert.Equal(t, 3, workers.IdleCount())
+
+	assert.NoError(t, workers.Wait(context.Background()))
+}
@@ -1,21 +1,52 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/commands"
+	"github.com/QiNiuQVMSolutionTeam/Redis-Transmission/lib"
 )
 
 const ModeDump = "dump"
 const ModeRestore = "restore"
 const ModeSync = "sync"
+const ModeInventory = "inventory"
+const ModeAnalyze = "analyze"
+const ModeInspect = "inspect"
+const ModeFilter = "filter"
+const ModeSplit = "split"
+const ModeMerge = "merge"
+const ModeDiff = "diff"
+const ModeConvert = "convert"
+const ModeServe = "serve"
+
+const (
+	ExitSuccess         = 0
+	ExitFailure         = 1
+	ExitConfigError     = 2
+	ExitConnectionError = 3
+	ExitPartialFailure  = 4
+)
 
 func main() {
 
+	os.Exit(run())
+}
+
+func run() int {
+
 	var (
 		mode                          string
 		host                          string
@@ -30,9 +61,59 @@ func main() {
 		syncTimesString               string
 		threadCountString             string
 		isSupportReplaceRestoreString string
+		timeoutString                 string
+		onError                       string
+		maxErrorsString               string
+		retryTimesString              string
+		deadLetter                    string
+		retryAttemptsString           string
+		retryBackoffString            string
+		retryMaxBackoffString         string
+		retryJitterString             string
+		maxOpsString                  string
+		maxBytesString                string
+		targetLatencyString           string
+		throttleIntervalString        string
+		dbMapString                   string
+		keyAddPrefix                  string
+		keyStripPrefix                string
+		keyRename                     string
+		onConflict                    string
+		deleteExtraneous              string
+		maxDeleteString               string
+		maxDeletePercentString        string
+		protectKeys                   string
+		dryRunString                  string
+		planPath                      string
+		bigKeyBytesString             string
+		bigKeyElementsString          string
+		chunkSizeString               string
+		transfer                      string
+		outputFormat                  string
+		inputFormat                   string
+		listen                        string
+		topString                     string
+		reportFormat                  string
+		prefixDelimiter               string
+		prefixDepthString             string
+		inspect                       string
+		match                         string
+		databases                     string
+		key                           string
+		matchRegex                    string
+		types                         string
+		minTTLString                  string
+		maxTTLString                  string
+		split                         string
+		splitCountString              string
+		splitSizeString               string
+		inputDbMapString              string
+		input2                        string
+		ttlToleranceString            string
+		base                          string
 	)
 
-	flag.StringVar(&mode, "mode", "", "-mode=[dump|restore]")
+	flag.StringVar(&mode, "mode", "", "-mode=[dump|restore|sync|inventory|analyze|inspect|filter|split|merge|diff|convert|serve]")
 	flag.StringVar(&host, "host", "127.0.0.1:6379", "-host=127.0.0.1:6379")
 	flag.StringVar(&password, "password", "", "-password=your_password")
 	flag.StringVar(&output, "output", "dump.json", "-output=/path/to/file")
@@ -45,36 +126,580 @@ func main() {
 	flag.StringVar(&syncTimesString, "sync-times", "0", "-sync-times=0")
 	flag.StringVar(&threadCountString, "thread-count", strconv.Itoa(runtime.NumCPU()), "-thread-count=4")
 	flag.StringVar(&isSupportReplaceRestoreString, "replace-restore", "1", "-replace-restore=1")
+	flag.StringVar(&timeoutString, "timeout", "0", "-timeout=2h")
+	flag.StringVar(&onError, "on-error", commands.OnErrorAbort, "-on-error=[abort|skip|retry]")
+	flag.StringVar(&maxErrorsString, "max-errors", "0", "-max-errors=100")
+	flag.StringVar(&retryTimesString, "retry-times", "3", "-retry-times=3")
+	flag.StringVar(&deadLetter, "dead-letter", "dead-letter.json", "-dead-letter=/path/to/file")
+	flag.StringVar(&retryAttemptsString, "retry-attempts", "3", "-retry-attempts=3")
+	flag.StringVar(&retryBackoffString, "retry-backoff", "100ms", "-retry-backoff=100ms")
+	flag.StringVar(&retryMaxBackoffString, "retry-max-backoff", "5s", "-retry-max-backoff=5s")
+	flag.StringVar(&retryJitterString, "retry-jitter", "0.2", "-retry-jitter=0.2")
+	flag.StringVar(&maxOpsString, "max-ops-per-sec", "0", "-max-ops-per-sec=[1000|read=1000,write=500]")
+	flag.StringVar(&maxBytesString, "max-bytes-per-sec", "0", "-max-bytes-per-sec=[10MB|read=10MB,write=5MB]")
+	flag.StringVar(&targetLatencyString, "target-latency", "0", "-target-latency=5ms")
+	flag.StringVar(&throttleIntervalString, "throttle-interval", "1s", "-throttle-interval=1s")
+	flag.StringVar(&dbMapString, "db-map", "", "-db-map=0:3,1:4")
+	flag.StringVar(&keyAddPrefix, "key-add-prefix", "", "-key-add-prefix=svcA:")
+	flag.StringVar(&keyStripPrefix, "key-strip-prefix", "", "-key-strip-prefix=legacy:")
+	flag.StringVar(&keyRename, "key-rename", "", "-key-rename='^user:(\\d+)$=>u:$1'")
+	flag.StringVar(&onConflict, "on-conflict", commands.OnConflictReplace, "-on-conflict=[replace|skip|fail|keep-newer-ttl]")
+	flag.StringVar(&deleteExtraneous, "delete-extraneous", commands.DeleteExtraneousOff, "-delete-extraneous=[0|1|dry-run]")
+	flag.StringVar(&maxDeleteString, "max-delete", "0", "-max-delete=1000")
+	flag.StringVar(&maxDeletePercentString, "max-delete-percent", "0", "-max-delete-percent=5")
+	flag.StringVar(&protectKeys, "protect-keys", "", "-protect-keys='local:*,lock:*'")
+	flag.StringVar(&dryRunString, "dry-run", "0", "-dry-run=[0|1]")
+	flag.StringVar(&planPath, "plan", "", "-plan=/path/to/plan.json")
+	flag.StringVar(&bigKeyBytesString, "big-key-bytes", "0", "-big-key-bytes=64MB")
+	flag.StringVar(&bigKeyElementsString, "big-key-elements", "0", "-big-key-elements=100000")
+	flag.StringVar(&chunkSizeString, "chunk-size", "1000", "-chunk-size=1000")
+	flag.StringVar(&transfer, "transfer", commands.TransferDump, "-transfer=[dump|logical]")
+	flag.StringVar(&outputFormat, "output-format", commands.FormatJSON, "-output-format=[json|json-logical|resp|rdb]")
+	flag.StringVar(&inputFormat, "input-format", "", "-input-format=[json|json-logical|resp|rdb]")
+	flag.StringVar(&listen, "listen", commands.DefaultListen, "-listen=127.0.0.1:6390")
+	flag.StringVar(&topString, "top", "10", "-top=10")
+	flag.StringVar(&reportFormat, "report-format", commands.ReportText, "-report-format=[text|json]")
+	flag.StringVar(&prefixDelimiter, "prefix-delimiter", commands.DefaultPrefixDelimiter, "-prefix-delimiter=:")
+	flag.StringVar(&prefixDepthString, "prefix-depth", "1", "-prefix-depth=1")
+	flag.StringVar(&inspect, "inspect", commands.InspectStats, "-inspect=[stats|keys|show]")
+	flag.StringVar(&match, "match", "", "-match='user:*'")
+	flag.StringVar(&databases, "db", "", "-db=0,3")
+	flag.StringVar(&key, "key", "", "-key=user:42")
+	flag.StringVar(&matchRegex, "match-regex", "", "-match-regex='^user:\\d+$'")
+	flag.StringVar(&types, "type", "", "-type=hash,zset")
+	flag.StringVar(&minTTLString, "min-ttl", "0", "-min-ttl=1h")
+	flag.StringVar(&maxTTLString, "max-ttl", "0", "-max-ttl=24h")
+	flag.StringVar(&split, "split", commands.SplitByDB, "-split=[db|hash|size]")
+	flag.StringVar(&splitCountString, "split-count", "0", "-split-count=8")
+	flag.StringVar(&splitSizeString, "split-size", "0", "-split-size=1GB")
+	flag.StringVar(&inputDbMapString, "input-db-map", "", "-input-db-map='0:3;*:1'")
+	flag.StringVar(&input2, "input2", "", "-input2=/path/to/file")
+	flag.StringVar(&ttlToleranceString, "ttl-tolerance", "0", "-ttl-tolerance=1h")
+	flag.StringVar(&base, "base", "", "-base=full.json,incremental1.json")
 
 	flag.Parse()
 
+	timeout, err := getTimeout(timeoutString)
+	if err != nil {
+
+		log.Printf("Parse timeout error, %s\n", err)
+		return ExitConfigError
+	}
+
+	policy, err := getErrorPolicy(onError, maxErrorsString, retryTimesString, deadLetter)
+	if err != nil {
+
+		log.Printf("Parse error policy error, %s\n", err)
+		return ExitConfigError
+	}
+
+	policy.Backoff, err = getBackoff(retryAttemptsString, retryBackoffString, retryMaxBackoffString, retryJitterString)
+	if err != nil {
+
+		log.Printf("Parse retry backoff error, %s\n", err)
+		return ExitConfigError
+	}
+	defer policy.Close()
+
+	limits, err := getRateLimits(maxOpsString, maxBytesString)
+	if err != nil {
+
+		log.Printf("Parse rate limit error, %s\n", err)
+		return ExitConfigError
+	}
+
+	targetLatency, err := getTimeout(targetLatencyString)
+	if err != nil {
+
+		log.Printf("Parse target-latency error, %s\n", err)
+		return ExitConfigError
+	}
+
+	throttleInterval, err := getTimeout(throttleIntervalString)
+	if err != nil {
+
+		log.Printf("Parse throttle-interval error, %s\n", err)
+		return ExitConfigError
+	}
+
+	dbMap, err := commands.ParseDatabaseMap(dbMapString)
+	if err != nil {
+
+		log.Printf("Parse db-map error, %s\n", err)
+		return ExitConfigError
+	}
+
+	keyRewriter, err := commands.NewKeyRewriter(keyAddPrefix, keyStripPrefix, keyRename)
+	if err != nil {
+
+		log.Printf("Parse key rewriting error, %s\n", err)
+		return ExitConfigError
+	}
+
+	// the keys of databases merged into one are claimed to report collisions
+	if keyRewriter == nil && dbMap.IsMerging() {
+		keyRewriter = commands.NewKeyClaims()
+	}
+
+	conflict, err := commands.NewConflictPolicy(onConflict)
+	if err != nil {
+
+		log.Printf("Parse on-conflict error, %s\n", err)
+		return ExitConfigError
+	}
+
+	deletion, err := getDeletionGuard(deleteExtraneous, maxDeleteString, maxDeletePercentString, protectKeys)
+	if err != nil {
+
+		log.Printf("Parse deletion guard error, %s\n", err)
+		return ExitConfigError
+	}
+
+	chunker, err := getChunker(bigKeyBytesString, bigKeyElementsString, chunkSizeString)
+	if err != nil {
+
+		log.Printf("Parse big key threshold error, %s\n", err)
+		return ExitConfigError
+	}
+
+	if transfer != commands.TransferDump && transfer != commands.TransferLogical {
+
+		log.Printf("Parse transfer error, unknown transfer %q, use dump or logical\n", transfer)
+		return ExitConfigError
+	}
+
+	// convert checks its own formats, rdb included
+	convertFormat := outputFormat
+	switch outputFormat {
+	case commands.FormatJSON, commands.FormatRESP:
+	case commands.FormatJSONLogical:
+		transfer = commands.TransferLogical
+		outputFormat = commands.FormatJSON
+	default:
+		if mode != ModeConvert {
+
+			log.Printf("Parse output-format error, unknown format %q, use json, json-logical or resp\n", outputFormat)
+			return ExitConfigError
+		}
+	}
+
+	var plan *commands.Plan
+	if dryRunString != "0" {
+
+		plan = commands.NewPlan(planPath)
+		defer plan.Close()
+	}
+
+	ctx, cancel := newContext(timeout)
+	defer cancel()
+
 	if mode == ModeDump {
 
 		databaseCount, err := getDatabaseCount(databaseCountString)
 		if err != nil {
 
 			log.Printf("Parse database-count error, %s\n", err)
-			return
+			return ExitConfigError
 		}
 
 		threadCount, err := getThreadCount(threadCountString)
 		if err != nil {
 
 			log.Printf("Parse thread-count error, %s\n", err)
-			return
+			return ExitConfigError
 		}
 
 		if threadCount <= 0 {
 
 			log.Printf("thread-count parameter error, %s\n", err)
-			return
+			return ExitConfigError
+		}
+
+		var baseline *commands.Baseline
+		if base != "" {
+
+			baseline, err = commands.LoadBaseline(ctx, strings.Split(base, ","))
+			if err != nil {
+
+				log.Printf("Load base error, %s\n", err)
+				return ExitConfigError
+			}
+
+			ttlTolerance, err := getTimeout(ttlToleranceString)
+			if err != nil {
+
+				log.Printf("ttl-tolerance parameter error, %s\n", err)
+				return ExitConfigError
+			}
+			baseline.TTLTolerance = int64(ttlTolerance / time.Second)
+		}
+
+		launcher := &commands.DumpLauncher{}
+		result, err := launcher.
+			SetHost(host).
+			SetPassword(password).
+			SetPath(output).
+			SetDatabaseCount(databaseCount).
+			SetThreadCount(threadCount).
+			SetErrorPolicy(policy).
+			SetRateLimits(limits).
+			SetThrottle(targetLatency, throttleInterval).
+			SetChunker(chunker).
+			SetTransfer(transfer).
+			SetFormat(outputFormat).
+			SetBaseline(baseline).
+			Launch(ctx)
+		return exitCode(result, err)
+
+	} else if mode == ModeInventory {
+
+		databaseCount, err := getDatabaseCount(databaseCountString)
+		if err != nil {
+
+			log.Printf("Parse database-count error, %s\n", err)
+			return ExitConfigError
+		}
+
+		threadCount, err := getThreadCount(threadCountString)
+		if err != nil || threadCount <= 0 {
+
+			log.Printf("thread-count parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		if !isFlagSet("output") {
+			output = "keys.csv"
+		}
+
+		inventory, err := commands.NewInventory(output)
+		if err != nil {
+
+			log.Printf("Create inventory error, %s\n", err)
+			return ExitConfigError
+		}
+
+		launcher := &commands.DumpLauncher{}
+		result, err := launcher.
+			SetHost(host).
+			SetPassword(password).
+			SetDatabaseCount(databaseCount).
+			SetThreadCount(threadCount).
+			SetErrorPolicy(policy).
+			SetRateLimits(limits).
+			SetThrottle(targetLatency, throttleInterval).
+			SetVisitor(inventory).
+			SetFrequency(true).
+			Launch(ctx)
+
+		if closeErr := inventory.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		return exitCode(result, err)
+
+	} else if mode == ModeAnalyze {
+
+		databaseCount, err := getDatabaseCount(databaseCountString)
+		if err != nil {
+
+			log.Printf("Parse database-count error, %s\n", err)
+			return ExitConfigError
+		}
+
+		threadCount, err := getThreadCount(threadCountString)
+		if err != nil || threadCount <= 0 {
+
+			log.Printf("thread-count parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		top, err := strconv.Atoi(topString)
+		if err != nil || top < 0 {
+
+			log.Printf("top parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		if reportFormat != commands.ReportText && reportFormat != commands.ReportJSON {
+
+			log.Printf("Parse report-format error, unknown format %q, use text or json\n", reportFormat)
+			return ExitConfigError
+		}
+
+		prefixDepth, err := strconv.Atoi(prefixDepthString)
+		if err != nil || prefixDepth < 0 {
+
+			log.Printf("prefix-depth parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		analyzer := commands.NewAnalyzer(top, prefixDelimiter, prefixDepth)
+		if isFlagSet("input") {
+
+			if err = commands.VisitDumpFile(ctx, input, analyzer); err != nil {
+				return exitCode(commands.Result{}, err)
+			}
+
+			err = writeReport(output, analyzer.Report().Write(reportFormat))
+			if err != nil {
+
+				log.Printf("Write report error, %s\n", err)
+				return ExitFailure
+			}
+			return ExitSuccess
+		}
+
+		launcher := &commands.DumpLauncher{}
+		result, err := launcher.
+			SetHost(host).
+			SetPassword(password).
+			SetDatabaseCount(databaseCount).
+			SetThreadCount(threadCount).
+			SetErrorPolicy(policy).
+			SetRateLimits(limits).
+			SetThrottle(targetLatency, throttleInterval).
+			SetVisitor(analyzer).
+			SetFrequency(true).
+			Launch(ctx)
+		if err != nil {
+			return exitCode(result, err)
+		}
+
+		if err = writeReport(output, analyzer.Report().Write(reportFormat)); err != nil {
+
+			log.Printf("Write report error, %s\n", err)
+			return ExitFailure
+		}
+		return exitCode(result, nil)
+
+	} else if mode == ModeInspect {
+
+		if reportFormat != commands.ReportText && reportFormat != commands.ReportJSON {
+
+			log.Printf("Parse report-format error, unknown format %q, use text or json\n", reportFormat)
+			return ExitConfigError
+		}
+
+		databaseSet, err := commands.ParseDatabaseSet(databases)
+		if err != nil {
+
+			log.Printf("Parse db error, %s\n", err)
+			return ExitConfigError
+		}
+
+		inspector := &commands.Inspector{
+			Path:      input,
+			Databases: databaseSet,
+			Match:     match,
+			Key:       key,
+			Format:    reportFormat,
+		}
+
+		err = writeReport(output, func(w io.Writer) error {
+
+			inspector.Out = w
+			return inspector.Inspect(ctx, inspect)
+		})
+		return exitCode(commands.Result{}, err)
+
+	} else if mode == ModeFilter {
+
+		minTTL, err := getTimeout(minTTLString)
+		if err != nil {
+
+			log.Printf("min-ttl parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		maxTTL, err := getTimeout(maxTTLString)
+		if err != nil {
+
+			log.Printf("max-ttl parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		filter, err := commands.NewRecordFilter(databases, match, matchRegex, types, minTTL, maxTTL)
+		if err != nil {
+
+			log.Printf("Parse filter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		result, err := commands.Filter(ctx, input, output, filter)
+		if err == nil {
+			log.Printf("%d key(s) written to %s, %d filtered out\n", result.Succeeded, output, result.Skipped)
+		}
+		return exitCode(result, err)
+
+	} else if mode == ModeSplit {
+
+		splitCount, err := strconv.Atoi(splitCountString)
+		if err != nil {
+
+			log.Printf("split-count parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		splitSize, err := parseBytes(splitSizeString, 10, 64)
+		if err != nil {
+
+			log.Printf("split-size parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		splitter := &commands.Splitter{
+			Input:    input,
+			Output:   output,
+			By:       split,
+			Count:    splitCount,
+			MaxBytes: int64(splitSize),
+		}
+		paths, err := splitter.Split(ctx)
+		if err == nil {
+			log.Printf("%s split into %d file(s)\n", input, len(paths))
+		}
+		return exitCode(commands.Result{}, err)
+
+	} else if mode == ModeMerge {
+
+		inputs := strings.Split(input, ",")
+		dbMaps, err := commands.ParseDatabaseMaps(inputDbMapString, len(inputs))
+		if err != nil {
+
+			log.Printf("Parse input-db-map error, %s\n", err)
+			return ExitConfigError
+		}
+
+		merger := &commands.Merger{
+			Inputs:       inputs,
+			Output:       output,
+			DatabaseMaps: dbMaps,
+			Conflict:     conflict,
+		}
+		result, err := merger.Merge(ctx)
+		conflict.PrintReport()
+		if err == nil {
+			log.Printf("%d key(s) written to %s, %d duplicate(s) left out\n", result.Succeeded, output, result.Skipped)
+		}
+		return exitCode(result, err)
+
+	} else if mode == ModeDiff {
+
+		if reportFormat != commands.ReportText && reportFormat != commands.ReportJSON {
+
+			log.Printf("Parse report-format error, unknown format %q, use text or json\n", reportFormat)
+			return ExitConfigError
+		}
+
+		if input2 == "" {
+
+			log.Printf("diff needs -input2, the newer dump file\n")
+			return ExitConfigError
+		}
+
+		ttlTolerance, err := getTimeout(ttlToleranceString)
+		if err != nil {
+
+			log.Printf("ttl-tolerance parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		differ := &commands.Differ{
+			Old:          input,
+			New:          input2,
+			TTLTolerance: int64(ttlTolerance / time.Second),
+		}
+		if isFlagSet("output") {
+			differ.Output = output
+		}
+
+		report, err := differ.Diff(ctx)
+		if err != nil {
+			return exitCode(commands.Result{}, err)
+		}
+
+		if err = report.Write(reportFormat)(os.Stdout); err != nil {
+
+			log.Printf("Write report error, %s\n", err)
+			return ExitFailure
 		}
+		return ExitSuccess
 
-		commands.Dump(host, password, output, databaseCount, threadCount)
+	} else if mode == ModeConvert {
+
+		converter := &commands.Converter{
+			Input:        input,
+			InputFormat:  inputFormat,
+			Output:       output,
+			OutputFormat: convertFormat,
+		}
+		result, err := converter.Convert(ctx)
+		if err == nil {
+			log.Printf("%d key(s) written to %s, %d failed\n", result.Succeeded, output, result.Failed)
+		}
+		return exitCode(result, err)
+
+	} else if mode == ModeServe {
+
+		databaseCount, err := getDatabaseCount(databaseCountString)
+		if err != nil {
+
+			log.Printf("Parse database-count error, %s\n", err)
+			return ExitConfigError
+		}
+
+		server := &commands.Server{
+			Input:         input,
+			InputFormat:   inputFormat,
+			Listen:        listen,
+			DatabaseCount: databaseCount,
+		}
+		count, err := server.Load(ctx)
+		if err != nil {
+			return exitCode(commands.Result{}, err)
+		}
+
+		log.Printf("Loaded %d key(s) from %s\n", count, input)
+		return exitCode(commands.Result{}, server.Serve(ctx))
 
 	} else if mode == ModeRestore {
 
-		commands.Restore(host, password, input, isSupportReplaceRestoreString != "0")
+		threadCount, err := getThreadCount(threadCountString)
+		if err != nil {
+
+			log.Printf("Parse thread-count error, %s\n", err)
+			return ExitConfigError
+		}
+
+		if threadCount <= 0 {
+
+			log.Printf("thread-count parameter error, %s\n", err)
+			return ExitConfigError
+		}
+
+		// a base dump then its incrementals, restored one after the other
+		var result commands.Result
+		for _, path := range strings.Split(input, ",") {
+
+			launcher := &commands.RestoreLauncher{}
+			pathResult, err := launcher.
+				SetHost(host).
+				SetPassword(password).
+				SetPath(path).
+				SetIsSupportReplaceRestore(isSupportReplaceRestoreString != "0").
+				SetThreadCount(threadCount).
+				SetErrorPolicy(policy).
+				SetRateLimits(limits).
+				SetDatabaseMap(dbMap).
+				SetKeyRewriter(keyRewriter).
+				SetConflictPolicy(conflict).
+				SetPlan(plan).
+				Launch(ctx)
+			result.Merge(pathResult)
+			if err != nil {
+				return exitCode(result, err)
+			}
+		}
+		return exitCode(result, nil)
 
 	} else if mode == ModeSync {
 
@@ -82,30 +707,30 @@ func main() {
 		if err != nil {
 
 			log.Printf("Parse database-count err, %s\n", err)
-			return
+			return ExitConfigError
 		}
 		syncTimes, err := getSyncTimes(syncTimesString)
 		if err != nil {
 
 			log.Printf("Parse database-count err, %s\n", err)
-			return
+			return ExitConfigError
 		}
 
 		threadCount, err := getThreadCount(threadCountString)
 		if err != nil {
 
 			log.Printf("Parse thread-count error, %s\n", err)
-			return
+			return ExitConfigError
 		}
 
 		if threadCount <= 0 {
 
 			log.Printf("thread-count parameter error, %s\n", err)
-			return
+			return ExitConfigError
 		}
 
 		launcher := &commands.SyncLauncher{}
-		launcher.
+		result, err := launcher.
 			SetSourceHost(sourceHost).
 			SetSourcePassword(sourcePassword).
 			SetDestinationHost(destinationHost).
@@ -114,13 +739,81 @@ func main() {
 			SetSyncTimes(syncTimes).
 			SetThreadCount(threadCount).
 			SetIsSupportReplaceRestore(isSupportReplaceRestoreString != "0").
-			Launch()
+			SetErrorPolicy(policy).
+			SetRateLimits(limits).
+			SetThrottle(targetLatency, throttleInterval).
+			SetChunker(chunker).
+			SetTransfer(transfer).
+			SetDatabaseMap(dbMap).
+			SetKeyRewriter(keyRewriter).
+			SetConflictPolicy(conflict).
+			SetDeletionGuard(deletion).
+			SetPlan(plan).
+			Launch(ctx)
+		return exitCode(result, err)
 
 	} else {
 
 		printHelp()
+		return ExitConfigError
+	}
+}
+
+// writeReport calls write with the standard output, or with the -output
+// file when it is given.
+func writeReport(path string, write func(w io.Writer) error) error {
+
+	if !isFlagSet("output") {
+		return write(os.Stdout)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return &commands.ConfigError{Err: fmt.Errorf("Init file error , %s", err)}
+	}
 
+	err = write(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
 	}
+
+	return err
+}
+
+func isFlagSet(name string) (isSet bool) {
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			isSet = true
+		}
+	})
+	return
+}
+
+func exitCode(result commands.Result, err error) int {
+
+	var (
+		configError     *commands.ConfigError
+		connectionError *commands.ConnectionError
+	)
+
+	if err != nil {
+		log.Printf("Error: %s\n", err)
+	}
+
+	switch {
+	case errors.As(err, &configError):
+		return ExitConfigError
+	case errors.As(err, &connectionError):
+		return ExitConnectionError
+	case err != nil:
+		return ExitFailure
+	case result.Failed > 0:
+		log.Printf("%d key(s) failed\n", result.Failed)
+		return ExitPartialFailure
+	}
+
+	return ExitSuccess
 }
 
 func printHelp() {
@@ -129,12 +822,12 @@ func printHelp() {
 Usage:
 	redis-transmission -mode=dump -host=127.0.0.1:6379 [-password=Auth] [-database-count=16] [-output=/path/to/file] [-input=/path/to/file]
 
-	redis-transmission -mode=restore -host=127.0.0.1:6379 [-password=Auth] [-input=/path/to/file]
+	redis-transmission -mode=restore -host=127.0.0.1:6379 [-password=Auth] [-input=/path/to/file] [-thread-count=4]
 
 	redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 [-source-password=Auth] [-destination-password=Auth] [-database-count=16] [-sync-times=Count]
 
 Options:
-	-mode=MODE                        Select dump mode, or restore mode. Options: Dump, Restore.
+	-mode=MODE                        Select the mode. Options: dump, restore, sync, inventory (one CSV line per key: db,key,type,ttl,encoding,memory_usage,element_count,frequency,idle_time, frequency with an LFU maxmemory-policy and idle_time otherwise, -output defaults to keys.csv), analyze (report of the biggest keys, hot keys by OBJECT FREQ when the maxmemory-policy is allkeys-lfu or volatile-lfu, TTL distribution, key prefixes and per database totals, of a dump file instead of a redis when -input is given), inspect (read the -input dump file without any redis, see -inspect), filter (copy the keys of the -input dump file selected by -db, -match, -match-regex, -type, -min-ttl and -max-ttl to -output), split (shard the -input dump file into -output files, see -split), merge (combine the comma separated -input dump files into -output, -on-conflict choosing the input kept for a key found in several ones), diff (report the keys added, removed and modified from the -input dump file to the -input2 one, and write the delta to -output when given), convert (rewrite the -input file, in -input-format, to -output in -output-format, without any redis), serve (answer read-only redis commands from the -input file on -listen).
 	-host=NODE                        The redis instance (host:port).
 	-password=PASSWORD                The redis authorization password, if empty then no use this parameter.
 	-input=FILE                       Use for restore data file.
@@ -147,6 +840,67 @@ Options:
 	-sync-times=TIMES                 synchronization times, default loop execution. Do not fill in this parameter if you need to execute it in a loop
 	-thread-count=COUNT               Number of concurrent executions, if empty then use cpu cores count.
 	-replace-restore=[1|0]            If the destination-side not support restore command use replace option, please use 0 to off this feature, when off this feature, it will remove key before restore command executive, if empty then use replace option.
+	-timeout=DURATION                 Abort the whole run after DURATION (e.g. 30m, 2h), in-flight requests are cancelled. 0 means no timeout.
+	-on-error=[abort|skip|retry]      What to do when a key fails: abort the run, skip the key, or retry it -retry-times times then skip it. Default abort.
+	-max-errors=COUNT                 With skip or retry, abort the run once more than COUNT keys failed. 0 means no limit.
+	-retry-times=TIMES                Number of retries for a failed key when on-error is retry, default 3. Each retry runs the commands of the key with their own -retry-attempts, a transient error being retried up to (TIMES + 1) x COUNT times.
+	-dead-letter=FILE                 Every failed key is written to FILE in the dump format so it can be restored later, default dead-letter.json. Empty to disable.
+	                                  Keys that failed to dump carry no value, restore reports them as failed: dump or sync them again.
+	-retry-attempts=COUNT             Attempts of a single SCAN, DUMP, PTTL, RESTORE or DEL failing with a transient error (network error, LOADING, BUSY, TRYAGAIN...), default 3. 1 disables retries.
+	-retry-backoff=DURATION           Pause before the first retry, doubled on every attempt, default 100ms.
+	-retry-max-backoff=DURATION       Upper bound of the pause between retries, default 5s.
+	-retry-jitter=RATIO               Randomise every pause by +/- RATIO of itself (0 to 1), default 0.2.
+	-max-ops-per-sec=LIMIT            Commands per second sent to the source (read) and to the destination (write). Either one number for both, or read=N,write=M. 0 means no limit.
+	-max-bytes-per-sec=LIMIT          Payload bytes per second read from the source and written to the destination, same form as -max-ops-per-sec, KB/MB/GB suffixes allowed. 0 means no limit.
+	-target-latency=DURATION          Dump and sync poll the source every -throttle-interval and lower the concurrency while its latency (PING round trip or LATENCY LATEST) is above DURATION, raising it back once under half of it. The limit is shared between the databases synchronized at the same time. 0 disables it.
+	-throttle-interval=DURATION       How often the source latency is polled, default 1s.
+	-db-map=MAP                       Restore and sync write source database N into another destination database, e.g. 0:3,1:4, or *:0 to put every database into DB 0 (cluster, proxies). Unlisted databases keep their number. A key found in several databases merged into one is reported as a failed key (collision), the first one is kept.
+	-key-add-prefix=PREFIX            Restore and sync prepend PREFIX to every key written to the destination, e.g. svcA:
+	-key-strip-prefix=PREFIX          Restore and sync remove PREFIX from the keys having it.
+	-key-rename=PATTERN=>REPLACEMENT  Restore and sync rename keys matching the regular expression, $1 refers to a group. Applied after -key-strip-prefix and before -key-add-prefix.
+	                                  Two source keys rewritten to the same destination key are reported as a failed key (collision), the first one is kept.
+	-on-conflict=POLICY               Restore and sync, what to do with a key already on the destination: replace it (default), skip it, fail it (see -on-error), or keep-newer-ttl to replace it only when the incoming key expires later. Merge, which input keeps a key found in several ones: the last one (replace), the first one (skip), the one expiring last (keep-newer-ttl), or stop (fail).
+	-delete-extraneous=[0|1|dry-run]  Sync deletes the destination keys missing on the source only when 1, dry-run lists them without deleting. Default 0.
+	-max-delete=COUNT                 Skip the deletions of a sync round when more than COUNT keys would be deleted from a database, the sync goes on. 0 means no limit.
+	-max-delete-percent=PERCENT       Same as -max-delete, relative to the number of keys in the destination database.
+	-protect-keys=PATTERNS            Comma separated glob patterns (e.g. local:*,lock:*) of destination keys sync never deletes.
+	-dry-run=[0|1]                    Restore and sync read and decide as usual (dump file, key rewriting, conflicts, deletions) but never RESTORE or DEL, a plan summary is printed instead. A dry sync runs one round.
+	-plan=FILE                        With -dry-run, also write the decision for every key to FILE, one JSON line {"db","key","action"} with action create, replace, skip, fail or delete.
+	-big-key-bytes=SIZE               Dump and sync copy a key whose MEMORY USAGE is above SIZE (KB/MB/GB suffixes allowed) in chunks with HSCAN/SSCAN/ZSCAN/LRANGE/XRANGE instead of one DUMP. 0 disables it.
+	-big-key-elements=COUNT           Same as -big-key-bytes for a key with more than COUNT elements (HLEN, LLEN, SCARD, ZCARD, XLEN).
+	-chunk-size=COUNT                 Elements per chunk of a big key, default 1000. A chunk is one record of the dump file.
+	-transfer=[dump|logical]          How dump and sync read keys: dump (DUMP/RESTORE, default) or logical (GET, HGETALL, LRANGE, SMEMBERS, ZRANGE WITHSCORES, XRANGE, written back with native commands), for a destination of an older redis version.
+	                                  Sync switches to logical by itself once the destination rejects a payload (Bad data format).
+	-output-format=FORMAT             Dump file format: json (default) or json-logical, where every record holds the key type and its decoded value (string, hash object, list or set array, zset member:score object, stream entries) instead of a base64 DUMP payload. Convert also writes resp and rdb, an RDB file loadable by redis (streams cannot be written there).
+	                                  resp writes redis commands (SELECT, RESTORE ... REPLACE or native commands with -transfer=logical, PEXPIREAT) for redis-cli --pipe or an AOF. Restore reads the three, and replays any AOF, one with an RDB preamble (any file starting with REDIS, commands after it or not) or a redis 7 appendonlydir (or its manifest).
+	-input-format=FORMAT              Convert and serve input format: json, json-logical, resp (RESP or AOF commands, an AOF directory or manifest) or rdb. Default detected from the file.
+	-listen=ADDRESS                   Serve listens on ADDRESS, default 127.0.0.1:6390. SELECT accepts the databases below -database-count, by default 16 or up to the highest database of the file.
+	-top=COUNT                        Analyze shows the COUNT biggest keys of every type, by memory usage and by element count, and the COUNT hottest keys, default 10.
+	-report-format=[text|json]        Format of the analyze report, written to the standard output unless -output is given, default text.
+	-prefix-delimiter=DELIMITER       Analyze groups keys by prefix, the segments of the key split on DELIMITER, default ":".
+	-prefix-depth=DEPTH               Number of segments making a prefix, "service:entity:" for "service:entity:42" at depth 2, default 1, 0 disables the grouping.
+	-inspect=[stats|keys|show]        Inspect command: stats gives the keys, size and types of every database and the TTL distribution, keys lists the keys, show prints the record of -key with the type decoded from its DUMP payload. Default stats, written to the standard output unless -output is given, as JSON with -report-format=json.
+	-match=PATTERN                    Inspect or filter only the keys matching the glob PATTERN, e.g. 'user:*'.
+	-db=LIST                          Inspect or filter only the databases of the comma separated LIST, e.g. 0,3.
+	-key=KEY                          Key printed by -inspect=show.
+	-match-regex=REGEX                Filter only the keys matching the regular expression REGEX.
+	-type=LIST                        Filter only the keys of the types of the comma separated LIST, e.g. hash,zset.
+	-min-ttl=DURATION                 Filter only the keys expiring in DURATION or later, e.g. 1h, keys without expiration included.
+	-max-ttl=DURATION                 Filter only the keys expiring within DURATION, e.g. 24h, keys without expiration excluded.
+	-split=[db|hash|size]             Split the dump file by database, by key hash into -split-count files, or into files of about -split-size each. Files are named after -output, dump.db0.json or dump.0.json for -output=dump.json. Default db.
+	-split-count=COUNT                Number of files of -split=hash.
+	-split-size=SIZE                  Size of the files of -split=size, e.g. 1GB, the records of a big key are never split.
+	-input-db-map=MAPS                Merge renumbers the databases of every input with its db-map, separated by ";" in the order of the inputs, e.g. '0:3;*:1'. A key found in several databases mapped to one, even of the same input, is a conflict for -on-conflict.
+	-input2=FILE                      Newer dump file compared by diff to -input.
+	-ttl-tolerance=DURATION           Diff and incremental dumps ignore TTL differences up to DURATION, e.g. 24h between nightly dumps, default 0: any TTL difference modifies the key.
+	-base=FILES                       Incremental dump: write only the keys added or changed (payload, or TTL beyond -ttl-tolerance) since the comma separated base dump file and its incrementals, and a deletion record per removed key. Restore such a chain with -input=FILES,incremental.json.
+
+Exit codes:
+	0    Success.
+	1    The run was aborted (first fatal error, timeout or interrupt).
+	2    Configuration error (bad parameter, unreadable input, unknown database count).
+	3    Connection failure (cannot reach or authenticate to a redis-server).
+	4    Partial failure, the run finished but some keys failed.
 
 Examples:
 	$ redis-transmission -mode=dump
@@ -160,12 +914,41 @@ Examples:
 	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=/tmp/dump.json
 	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -password=Password -input=/tmp/dump.json
 	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -password=Password -input=/tmp/dump.json -replace-restore=0
+	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=/tmp/dump.json -thread-count=8 -timeout=1h
 	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378
 	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -thread-count=16
 	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -database-count=16
 	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -source-password=Password -destination-password=Password
 	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -source-password=Password -destination-password=Password -database-count=1 -sync-times=1
 	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -source-password=Password -destination-password=Password -database-count=1 -replace-restore=0
+	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -on-error=retry -retry-times=5 -max-errors=100 -dead-letter=/tmp/failed.json
+	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=/tmp/failed.json
+	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=/tmp/dump.json -db-map=*:0
+	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -database-count=2 -db-map=0:3,1:4
+	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -key-add-prefix=svcA:
+	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=/tmp/dump.json -key-rename='^session:(.*)$=>sess:$1'
+	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -max-ops-per-sec=read=2000,write=5000 -max-bytes-per-sec=read=20MB
+	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -delete-extraneous=1 -max-delete-percent=5 -protect-keys='local:*'
+	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=/tmp/dump.json -on-conflict=skip -dry-run=1 -plan=/tmp/plan.json
+	$ redis-transmission -mode=dump -host=127.0.0.1:6379 -output=/tmp/dump.json -big-key-bytes=64MB -big-key-elements=100000
+	$ redis-transmission -mode=sync -source=127.0.0.1:6379 -destination=127.0.0.1:6378 -transfer=logical
+	$ redis-transmission -mode=dump -host=127.0.0.1:6379 -output=/tmp/dump.json -output-format=json-logical
+	$ redis-transmission -mode=dump -host=127.0.0.1:6379 -output=/tmp/dump.resp -output-format=resp && redis-cli --pipe < /tmp/dump.resp
+	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=/var/lib/redis/appendonly.aof
+	$ redis-transmission -mode=inventory -host=127.0.0.1:6379 -output=keys.csv
+	$ redis-transmission -mode=analyze -host=127.0.0.1:6379 -top=20 -report-format=json -output=report.json
+	$ redis-transmission -mode=analyze -input=dump.json -prefix-delimiter=: -prefix-depth=2
+	$ redis-transmission -mode=inspect -input=dump.json -inspect=keys -db=0 -match='user:*'
+	$ redis-transmission -mode=inspect -input=dump.json -inspect=show -key=user:42
+	$ redis-transmission -mode=filter -input=dump.json -output=tenant.json -db=0 -match='tenant42:*' -type=hash
+	$ redis-transmission -mode=split -input=dump.json -output=/tmp/shard.json -split=hash -split-count=8
+	$ redis-transmission -mode=merge -input=a.json,b.json -input-db-map=';0:1' -on-conflict=keep-newer-ttl -output=merged.json
+	$ redis-transmission -mode=diff -input=monday.json -input2=tuesday.json -ttl-tolerance=25h -output=delta.json
+	$ redis-transmission -mode=dump -host=127.0.0.1:6379 -base=full.json,monday.json -output=tuesday.json
+	$ redis-transmission -mode=restore -host=127.0.0.1:6379 -input=full.json,monday.json,tuesday.json
+	$ redis-transmission -mode=convert -input=/var/lib/redis/dump.rdb -output=dump.json -output-format=json-logical
+	$ redis-transmission -mode=convert -input=dump.json -output=dump.rdb -output-format=rdb
+	$ redis-transmission -mode=serve -input=dump.json -listen=127.0.0.1:6390 && redis-cli -p 6390
 `)
 }
 
@@ -217,3 +1000,225 @@ func getSyncTimes(syncTimesString string) (syncTimes uint64, err error) {
 
 	return
 }
+
+func getChunker(bigKeyBytesString, bigKeyElementsString, chunkSizeString string) (*commands.Chunker, error) {
+
+	bigKeyBytes, err := parseBytes(bigKeyBytesString, 10, 64)
+	if err != nil {
+
+		return nil, err
+	}
+
+	bigKeyElements, err := strconv.ParseInt(bigKeyElementsString, 10, 64)
+	if err != nil {
+
+		return nil, err
+	}
+
+	chunkSize, err := strconv.ParseInt(chunkSizeString, 10, 64)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return commands.NewChunker(int64(bigKeyBytes), bigKeyElements, chunkSize), nil
+}
+
+func getDeletionGuard(deleteExtraneous, maxDeleteString, maxDeletePercentString, protectKeys string) (*commands.DeletionGuard, error) {
+
+	maxDelete, err := strconv.ParseUint(maxDeleteString, 10, 64)
+	if err != nil {
+
+		return nil, err
+	}
+
+	maxDeletePercent, err := strconv.ParseFloat(strings.TrimSuffix(maxDeletePercentString, "%"), 64)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return commands.NewDeletionGuard(deleteExtraneous, maxDelete, maxDeletePercent, protectKeys)
+}
+
+func getErrorPolicy(onError, maxErrorsString, retryTimesString, deadLetter string) (*commands.ErrorPolicy, error) {
+
+	maxErrors, err := strconv.ParseUint(maxErrorsString, 10, 64)
+	if err != nil {
+
+		return nil, err
+	}
+
+	retryTimes, err := strconv.ParseInt(retryTimesString, 10, 64)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return commands.NewErrorPolicy(onError, maxErrors, int(retryTimes), deadLetter)
+}
+
+func getBackoff(attemptsString, backoffString, maxBackoffString, jitterString string) (backoff *lib.Backoff, err error) {
+
+	attempts, err := strconv.ParseInt(attemptsString, 10, 64)
+	if err != nil {
+
+		return
+	}
+
+	initial, err := time.ParseDuration(backoffString)
+	if err != nil {
+
+		return
+	}
+
+	maxBackoff, err := time.ParseDuration(maxBackoffString)
+	if err != nil {
+
+		return
+	}
+
+	jitter, err := strconv.ParseFloat(jitterString, 64)
+	if err != nil {
+
+		return
+	}
+
+	if jitter < 0 || jitter > 1 {
+
+		err = fmt.Errorf("retry-jitter must be between 0 and 1")
+		return
+	}
+
+	backoff = &lib.Backoff{
+		MaxAttempts: int(attempts),
+		Initial:     initial,
+		Max:         maxBackoff,
+		Multiplier:  2,
+		Jitter:      jitter,
+	}
+	return
+}
+
+func getRateLimits(opsString, bytesString string) (limits *commands.RateLimits, err error) {
+
+	readOps, writeOps, err := getRatePair(opsString, strconv.ParseUint)
+	if err != nil {
+
+		return
+	}
+
+	readBytes, writeBytes, err := getRatePair(bytesString, parseBytes)
+	if err != nil {
+
+		return
+	}
+
+	limits = commands.NewRateLimits(float64(readOps), float64(readBytes), float64(writeOps), float64(writeBytes))
+	return
+}
+
+// getRatePair parses either a single limit applied to both directions, or
+// "read=N,write=M" where any side may be omitted.
+func getRatePair(limitString string, parse func(string, int, int) (uint64, error)) (read, write uint64, err error) {
+
+	if limitString == "" {
+
+		return
+	}
+
+	if !strings.Contains(limitString, "=") {
+
+		read, err = parse(limitString, 10, 64)
+		write = read
+		return
+	}
+
+	for _, part := range strings.Split(limitString, ",") {
+
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) != 2 {
+
+			err = fmt.Errorf("invalid limit %q", part)
+			return
+		}
+
+		value, parseErr := parse(strings.TrimSpace(pair[1]), 10, 64)
+		if parseErr != nil {
+
+			err = parseErr
+			return
+		}
+
+		switch strings.TrimSpace(pair[0]) {
+		case "read":
+			read = value
+		case "write":
+			write = value
+		default:
+			err = fmt.Errorf("invalid limit direction %q, use read or write", pair[0])
+			return
+		}
+	}
+
+	return
+}
+
+func parseBytes(bytesString string, base int, bitSize int) (uint64, error) {
+
+	units := []struct {
+		suffix     string
+		multiplier uint64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(bytesString)
+	for _, unit := range units {
+
+		if strings.HasSuffix(upper, unit.suffix) {
+
+			value, err := strconv.ParseUint(strings.TrimSpace(upper[:len(upper)-len(unit.suffix)]), base, bitSize)
+			return value * unit.multiplier, err
+		}
+	}
+
+	return strconv.ParseUint(bytesString, base, bitSize)
+}
+
+func getTimeout(timeoutString string) (timeout time.Duration, err error) {
+
+	if timeoutString == "" {
+
+		return
+	}
+
+	timeout, err = time.ParseDuration(timeoutString)
+	return
+}
+
+func newContext(timeout time.Duration) (ctx context.Context, cancel context.CancelFunc) {
+
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %s, cancelling\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return
+}